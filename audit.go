@@ -0,0 +1,71 @@
+package smartlog
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// AuditLogger writes an append-only audit trail of security-relevant events to its own
+// file, separate from operational logs. Entries are always written, regardless of the
+// configured log level, and are never sampled or rotated away.
+type AuditLogger struct {
+	logger *zap.Logger
+	file   *os.File
+}
+
+// NewAuditLogger creates an AuditLogger writing to cfg.Audit.Filename.
+func NewAuditLogger(cfg *Config) (*AuditLogger, error) {
+	if cfg.Audit.Filename == "" {
+		return nil, errors.New("smartlog: audit.filename is required")
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.Audit.Filename), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(cfg.Audit.Filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, err
+	}
+
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	encoderConfig.TimeKey = "timestamp"
+	encoderConfig.MessageKey = "message"
+	encoderConfig.LevelKey = zapcore.OmitKey
+	encoderConfig.CallerKey = zapcore.OmitKey
+	encoderConfig.StacktraceKey = zapcore.OmitKey
+
+	// A dedicated core that accepts every level, so the global level never applies
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), zapcore.AddSync(file), zapcore.DebugLevel)
+	logger := zap.New(core).With(
+		zap.String("service", cfg.ServiceName),
+		zap.String("env", cfg.Env),
+	)
+
+	return &AuditLogger{logger: logger, file: file}, nil
+}
+
+// Audit records that actor performed action on resource with the given result.
+// The log_id of the request in ctx is always included, empty if there is none.
+func (a *AuditLogger) Audit(ctx context.Context, actor, action, resource, result string, fields ...zap.Field) {
+	logID, _ := ctx.Value(LogIDKey).(string)
+	a.logger.Info("audit", append([]zap.Field{
+		zap.String("actor", actor),
+		zap.String("action", action),
+		zap.String("resource", resource),
+		zap.String("result", result),
+		zap.String("log_id", logID),
+	}, fields...)...)
+}
+
+// Close flushes and closes the audit file.
+func (a *AuditLogger) Close() error {
+	if err := a.logger.Sync(); err != nil {
+		return err
+	}
+	return a.file.Close()
+}
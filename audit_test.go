@@ -0,0 +1,67 @@
+package smartlog
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestAuditLogger(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit", "audit.log")
+	cfg := &Config{
+		ServiceName: "test-service",
+		Log:         TimberjackConfig{Level: "error"}, // The global level must not apply
+		Audit:       AuditConfig{Filename: auditPath},
+	}
+
+	auditLogger, err := NewAuditLogger(cfg)
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), LogIDKey, "audit-log-id")
+	auditLogger.Audit(ctx, "user-42", "delete", "invoice/7", "success", zap.String("ip", "10.0.0.1"))
+	require.NoError(t, auditLogger.Close())
+
+	content, err := os.ReadFile(auditPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 1)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "user-42", entry["actor"])
+	assert.Equal(t, "delete", entry["action"])
+	assert.Equal(t, "invoice/7", entry["resource"])
+	assert.Equal(t, "success", entry["result"])
+	assert.Equal(t, "audit-log-id", entry["log_id"])
+	assert.Equal(t, "test-service", entry["service"])
+	assert.Equal(t, "10.0.0.1", entry["ip"])
+}
+
+func TestAuditLogger_AppendsToExistingFile(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	require.NoError(t, os.WriteFile(auditPath, []byte("{\"existing\":true}\n"), 0o640))
+
+	auditLogger, err := NewAuditLogger(&Config{Audit: AuditConfig{Filename: auditPath}})
+	require.NoError(t, err)
+	auditLogger.Audit(context.Background(), "admin", "login", "console", "failure")
+	require.NoError(t, auditLogger.Close())
+
+	content, err := os.ReadFile(auditPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, `{"existing":true}`, lines[0])
+	assert.Contains(t, lines[1], `"log_id":""`, "log_id should always be present")
+}
+
+func TestNewAuditLogger_RequiresFilename(t *testing.T) {
+	_, err := NewAuditLogger(&Config{})
+	assert.Error(t, err)
+}
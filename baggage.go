@@ -0,0 +1,122 @@
+package smartlog
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// BaggageKey is the key for the baggage in the request context.
+	BaggageKey contextKey = "baggage"
+	// HeaderBaggage is the name of the header used to propagate baggage, in W3C baggage format.
+	HeaderBaggage = "Baggage"
+
+	// maxBaggageHeaderBytes is the W3C limit on the size of a baggage header.
+	maxBaggageHeaderBytes = 8192
+)
+
+// Baggage holds cross-cutting key/value pairs (tenant, region, experiment, ...) that are
+// propagated across services alongside the log ID and logged with every request.
+type Baggage map[string]string
+
+// MarshalLogObject implements zapcore.ObjectMarshaler, emitting keys in sorted order.
+func (b Baggage) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, key := range b.keys() {
+		enc.AddString(key, b[key])
+	}
+	return nil
+}
+
+func (b Baggage) keys() []string {
+	keys := make([]string, 0, len(b))
+	for key := range b {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WithBaggage returns a copy of ctx carrying baggage merged over any baggage already in ctx.
+// The caller's map is copied, so later changes to it aren't seen by the context.
+func WithBaggage(ctx context.Context, baggage Baggage) context.Context {
+	merged := make(Baggage, len(baggage))
+	for key, value := range BaggageFromContext(ctx) {
+		merged[key] = value
+	}
+	for key, value := range baggage {
+		merged[key] = value
+	}
+	return context.WithValue(ctx, BaggageKey, merged)
+}
+
+// BaggageFromContext returns the baggage stored in the context, or nil if there is none.
+// The returned map must not be modified; use WithBaggage to add entries.
+func BaggageFromContext(ctx context.Context) Baggage {
+	if ctx == nil {
+		return nil
+	}
+	baggage, _ := ctx.Value(BaggageKey).(Baggage)
+	return baggage
+}
+
+// parseBaggage parses a W3C baggage header ("key1=value1,key2=value2;property").
+// Member properties are ignored and malformed members are skipped.
+func parseBaggage(header string) Baggage {
+	if header == "" || len(header) > maxBaggageHeaderBytes {
+		return nil
+	}
+	var baggage Baggage
+	for _, member := range strings.Split(header, ",") {
+		// Drop member properties
+		if i := strings.IndexByte(member, ';'); i >= 0 {
+			member = member[:i]
+		}
+		key, value, ok := strings.Cut(member, "=")
+		key, keyErr := url.PathUnescape(strings.TrimSpace(key))
+		if !ok || key == "" || keyErr != nil {
+			continue
+		}
+		value, err := url.PathUnescape(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		if baggage == nil {
+			baggage = make(Baggage)
+		}
+		baggage[key] = value
+	}
+	return baggage
+}
+
+// formatBaggage serializes baggage as a W3C baggage header value, percent-encoding keys
+// and values.
+func formatBaggage(baggage Baggage) string {
+	members := make([]string, 0, len(baggage))
+	for _, key := range baggage.keys() {
+		members = append(members, escapeBaggage(key)+"="+escapeBaggage(baggage[key]))
+	}
+	return strings.Join(members, ",")
+}
+
+// escapeBaggage percent-encodes the bytes of s the W3C baggage format doesn't allow as is:
+// controls, spaces, non-ASCII bytes, '"', ',', ';' and backslashes. '=' and '%' are encoded too,
+// so members split unambiguously and decode back to s.
+func escapeBaggage(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`",;\=%`, c) >= 0 {
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,101 @@
+package smartlog
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestParseAndFormatBaggage(t *testing.T) {
+	baggage := parseBaggage(" tenant = acme ,region=eu%20west;ttl=30,bad,=novalue")
+	assert.Equal(t, Baggage{"tenant": "acme", "region": "eu west"}, baggage)
+	assert.Equal(t, "region=eu%20west,tenant=acme", formatBaggage(baggage))
+	assert.Equal(t, baggage, parseBaggage(formatBaggage(baggage)))
+
+	// Delimiters in keys and values are percent-encoded so the header stays well formed
+	tricky := Baggage{"filter": "a=1,b=2;c", "odd key": `50% "off"`}
+	assert.Equal(t, "filter=a%3D1%2Cb%3D2%3Bc,odd%20key=50%25%20%22off%22", formatBaggage(tricky))
+	assert.Equal(t, tricky, parseBaggage(formatBaggage(tricky)))
+}
+
+func TestBaggageField_Redacts(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	cfg := &Config{RedactKeys: []string{"token"}, DropKeys: []string{"internal"}}
+	handler := ServerLogging(zap.New(core), cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/checkout", nil)
+	req.Header.Set(HeaderBaggage, "tenant=acme,Token=s3cret,internal=yes")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	for _, entry := range recorded.All() {
+		assert.Equal(t, map[string]interface{}{"tenant": "acme", "Token": redactionPlaceholder}, entry.ContextMap()["baggage"], entry.Message)
+	}
+}
+
+func TestWithBaggage_Merges(t *testing.T) {
+	ctx := WithBaggage(context.Background(), Baggage{"tenant": "acme", "region": "eu"})
+	ctx = WithBaggage(ctx, Baggage{"region": "us"})
+
+	assert.Equal(t, Baggage{"tenant": "acme", "region": "us"}, BaggageFromContext(ctx))
+	assert.Nil(t, BaggageFromContext(context.Background()))
+}
+
+func TestBaggage_PropagatesFromServerToClient(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	cfg := &Config{}
+
+	// Downstream service records the baggage header it receives
+	var downstreamBaggage string
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downstreamBaggage = r.Header.Get(HeaderBaggage)
+	}))
+	defer downstream.Close()
+
+	client := NewLoggingClient(cfg, logger)
+	handler := ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithBaggage(r.Context(), Baggage{"experiment": "b"})
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, downstream.URL, nil)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/checkout", nil)
+	req.Header.Set(HeaderBaggage, "tenant=acme,region=eu")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "experiment=b,region=eu,tenant=acme", downstreamBaggage)
+
+	logs := recorded.All()
+	require.Len(t, logs, 4)
+	for _, entry := range logs {
+		baggage := entry.ContextMap()["baggage"]
+		switch entry.Message {
+		case defaultRequestMessage, defaultResponseMessage:
+			assert.Equal(t, map[string]interface{}{"tenant": "acme", "region": "eu"}, baggage, entry.Message)
+		default:
+			assert.Equal(t, map[string]interface{}{"tenant": "acme", "region": "eu", "experiment": "b"}, baggage, entry.Message)
+		}
+	}
+
+	// Without a request logger, the client logs the baggage it sends
+	recorded.TakeAll()
+	ctx := WithBaggage(context.Background(), Baggage{"experiment": "b"})
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, downstream.URL, nil)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	for _, entry := range recorded.All() {
+		assert.Equal(t, map[string]interface{}{"experiment": "b"}, entry.ContextMap()["baggage"], entry.Message)
+	}
+}
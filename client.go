@@ -2,100 +2,315 @@ package smartlog
 
 import (
 	"bytes"
-	"encoding/json"
+	"errors"
 	"github.com/google/uuid"
 	"io"
+	"mime"
+	"net"
 	"net/http"
-	"time"
+	"strings"
 
 	"go.uber.org/zap"
 )
 
 // loggingRoundTripper is an http.RoundTripper that logs requests and responses.
 type loggingRoundTripper struct {
-	next   http.RoundTripper
-	logger *zap.Logger
-	cfg    *Config
+	next     http.RoundTripper
+	logger   *zap.Logger
+	cfg      *Config
+	buckets  *latencyBuckets
+	secrets  *secretDetector
+	errors   *errorLogLimiter
+	health   *downstreamTracker
+	clock    clock
+	keys     logKeys
+	redact   []string
+	messages MessagesConfig
+	metrics  *Metrics
 }
 
-// NewClientLogger creates a new loggingRoundTripper.
-func NewClientLogger(next http.RoundTripper, logger *zap.Logger, cfg *Config) http.RoundTripper {
+// NewClientLogger creates a new loggingRoundTripper. If next is nil, http.DefaultTransport is used.
+func NewClientLogger(next http.RoundTripper, logger *zap.Logger, cfg *Config, opts ...Option) http.RoundTripper {
+	o := newOptions(opts)
+	if next == nil {
+		next = http.DefaultTransport
+	}
 	return &loggingRoundTripper{
-		next:   next,
-		logger: logger,
-		cfg:    cfg,
+		next:     next,
+		logger:   logger,
+		cfg:      cfg,
+		buckets:  newLatencyBuckets(cfg.LatencyBuckets),
+		secrets:  newSecretDetector(cfg),
+		errors:   newErrorLogLimiter(cfg.ClientErrorLogIntervalMs, o.clock),
+		health:   newDownstreamTracker(cfg.ClientDegradedThreshold, o.clock),
+		clock:    o.clock,
+		keys:     newLogKeys(cfg.FlattenFields),
+		redact:   cfg.allRedactKeys(),
+		messages: cfg.messages(),
+		metrics:  o.metrics,
 	}
 }
 
+// WrapTransport wraps base with request/response logging. If base is nil,
+// http.DefaultTransport is used.
+//
+// The logging transport sees requests after any transports wrapping it and before base.
+// To log what actually goes over the wire (e.g. headers added by an auth transport), wrap
+// the logging transport with those transports rather than the other way around:
+//
+//	transport := NewAuthTransport(smartlog.WrapTransport(nil, logger, cfg))
+func WrapTransport(base http.RoundTripper, logger *zap.Logger, cfg *Config, opts ...Option) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return NewClientLogger(base, logger, cfg, opts...)
+}
+
+// NewLoggingClient returns an http.Client whose requests are logged, using http.DefaultTransport.
+func NewLoggingClient(cfg *Config, logger *zap.Logger, opts ...Option) *http.Client {
+	return &http.Client{Transport: WrapTransport(nil, logger, cfg, opts...)}
+}
+
 // RoundTrip executes a single HTTP transaction, adding logging around it.
 func (lrt *loggingRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
-	startTime := time.Now()
+	// Fail before logging anything rather than leave a request log without its response
+	if lrt.next == nil {
+		return nil, errors.New("smartlog: client logger has no transport to send the request")
+	}
 
-	// Get Log ID from context and add to header
-	logID, _ := r.Context().Value(LogIDKey).(string)
+	startTime := lrt.clock.Now()
 
-	ctxLogger := lrt.logger
-	if logID != "" {
+	// Get Log ID from context (or create one) and add to header
+	logID, _ := r.Context().Value(LogIDKey).(string)
+	if logID == "" {
 		logID = uuid.NewString()
-		r.Header.Set(HeaderLogID, logID)
-		ctxLogger = lrt.logger.With(zap.String("log_id", logID))
+	}
+	r.Header.Set(HeaderLogID, logID)
+
+	// Propagate baggage from the context unless the caller set the header explicitly
+	baggage := BaggageFromContext(r.Context())
+	if len(baggage) > 0 && r.Header.Get(HeaderBaggage) == "" {
+		r.Header.Set(HeaderBaggage, formatBaggage(baggage))
 	}
 
-	// Read and log request body
-	var reqBodyBytes []byte
-	if r.Body != nil {
-		reqBodyBytes, _ = io.ReadAll(r.Body)
-		r.Body = io.NopCloser(bytes.NewBuffer(reqBodyBytes)) // Restore body
+	// Tag the client's logger with the log ID, the outbound baggage and the fields the
+	// handler added to the request's logger, so outbound calls carry them too
+	sanitize := lrt.cfg.sanitizeControlChars()
+	ctxLogger := lrt.logger.With(zap.String("log_id", logID), lrt.keys.baggage(baggage, lrt.redact, lrt.cfg.DropKeys, sanitize))
+	if fields := scopedFields(r.Context()); len(fields) > 0 {
+		ctxLogger = ctxLogger.With(fields...)
+	}
+	if len(lrt.cfg.HostServiceMap) > 0 {
+		ctxLogger = ctxLogger.With(zap.String("downstream_service", downstreamService(r.URL.Host, lrt.cfg.HostServiceMap)))
+	}
+	// Requests flagged for debug logging are logged in full, at every level, even when
+	// logging is turned off
+	debug := DebugFromContext(r.Context())
+	if debug {
+		ctxLogger = debugLogger(ctxLogger)
 	}
-	redactedReqBody := redactJSONBody(reqBodyBytes, lrt.cfg.RedactKeys)
-	var reqBodyForLog json.RawMessage
-	if len(redactedReqBody) > 0 {
-		reqBodyForLog = json.RawMessage(redactedReqBody)
+	logURL := r.URL.String()
+	if sanitize {
+		logURL = sanitizeString(logURL)
 	}
 
-	redactedHeaders := redactHeaders(r.Header, lrt.cfg.RedactKeys)
+	// Report which redact keys matched once the request is done
+	audit := newRedactionAudit(lrt.cfg.RedactAudit, lrt.redact, sanitize)
+	defer audit.log(ctxLogger, zap.String("method", r.Method), zap.String("url", logURL))
+
+	if debug || boolOrDefault(lrt.cfg.ClientLogRequest, true) {
+		// Read and log request body
+		var reqBodyBytes []byte
+		if r.Body != nil {
+			reqBodyBytes, _ = io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewBuffer(reqBodyBytes)) // Restore body
+		}
+		var reqLog httpRequestLog
+		if lrt.cfg.HashBodiesInsteadOfLog {
+			reqLog.bodySHA256 = bodySHA256(reqBodyBytes)
+		} else {
+			var redactedReqBody []byte
+			var rawTruncated, redactionSkipped bool
+			redactedReqBody, reqLog.bodyTruncated, redactionSkipped, reqLog.redactionApplied = redactBodyForLog(reqBodyBytes, lrt.redact, lrt.cfg.DropKeys, lrt.secrets, nestedJSONDepth(lrt.cfg), lrt.cfg.MaxBodyLogBytes)
+			if redactionSkipped {
+				reqLog.redactionSkipped = "parse_error"
+			}
+			if redactionSkipped && !lrt.cfg.LogUnredactableBodies {
+				reqLog.bodyOmitted = "redaction_skipped"
+			} else {
+				audit.jsonBody("request.body", reqBodyBytes)
+				reqLog.body, reqLog.bodyRaw, rawTruncated = loggedBody(redactedReqBody, lrt.cfg.MaxBodyLogBytes)
+				lrt.metrics.observeBody(reqBodyBytes, redactedReqBody, reqLog.bodyTruncated || rawTruncated)
+			}
+		}
+
+		reqLog.accept = r.Header.Get("Accept")
+		reqLog.acceptLanguage = r.Header.Get("Accept-Language")
+		reqLog.headers = redactHeaders(r.Header, lrt.redact, lrt.cfg.DropKeys, lrt.cfg.AsyncCore)
+		audit.headers("request.headers", r.Header)
+		lrt.metrics.observeHeaders(r.Header, reqLog.headers)
+		if sanitize {
+			reqLog.accept = sanitizeString(reqLog.accept)
+			reqLog.acceptLanguage = sanitizeString(reqLog.acceptLanguage)
+			reqLog.headers = sanitizeHeaders(reqLog.headers)
+		}
+		// Cut after escaping, which can make values longer
+		reqLog.headers = truncateHeaderValues(reqLog.headers, lrt.cfg.MaxHeaderValueLogBytes)
 
-	ctxLogger.Info("Client request sent",
-		zap.String("method", r.Method),
-		zap.String("url", r.URL.String()),
-		zap.Any("request", map[string]interface{}{
-			"headers": redactedHeaders,
-			"body":    reqBodyForLog,
-		}),
-	)
+		ctxLogger.Info(lrt.messages.ClientRequestMsg,
+			zap.String(lrt.keys.method, r.Method),
+			zap.String(lrt.keys.url, logURL),
+			bodyHashField("request_body_sha256", reqBodyBytes, lrt.cfg.HashBodies && !lrt.cfg.HashBodiesInsteadOfLog),
+			omittedBodySizeField("req_body_bytes", lrt.cfg.LogOmittedBodySize, reqLog.bodyOmitted != "" || lrt.cfg.HashBodiesInsteadOfLog, int64(len(reqBodyBytes))),
+			lrt.keys.request(reqLog),
+		)
+	}
 
 	// Perform the request
 	resp, err := lrt.next.RoundTrip(r)
-	latency := time.Since(startTime)
+	latency := lrt.clock.Since(startTime)
 
-	// If there was an error, log it and return
+	// If there was an error, log it (unless an identical one was just logged, or the host is
+	// degraded) and return
 	if err != nil {
-		ctxLogger.Error("Client request failed",
-			zap.Error(err),
-			zap.Int64("latency_ms", latency.Milliseconds()),
-		)
+		errorKind := classifyTransportError(err)
+		degraded, failures, suppress := lrt.health.failure(r.URL.Host)
+		if suppress {
+			return nil, err
+		}
+		if degraded {
+			defer logDownstreamDegraded(ctxLogger, r.URL.Host, failures)
+		}
+		if ok, suppressed := lrt.errors.allow(r.URL.Host + " " + errorKind); ok {
+			var suppressedField zap.Field
+			if suppressed > 0 {
+				suppressedField = zap.Int("suppressed_count", suppressed)
+			} else {
+				suppressedField = zap.Skip()
+			}
+			ctxLogger.Error(lrt.messages.ClientErrorMsg,
+				zap.Error(err),
+				zap.String("error_kind", errorKind),
+				zap.String("host", r.URL.Host),
+				zap.Int64("latency_ms", latency.Milliseconds()),
+				zap.String("latency_bucket", lrt.buckets.bucket(latency)),
+				suppressedField,
+			)
+		}
 		return nil, err
 	}
 
-	// Read and log response body
+	// Server errors count as failures of the host too
+	if resp.StatusCode >= 500 {
+		degraded, failures, suppress := lrt.health.failure(r.URL.Host)
+		if suppress {
+			return resp, nil
+		}
+		if degraded {
+			defer logDownstreamDegraded(ctxLogger, r.URL.Host, failures)
+		}
+	} else if recovered, failures, suppressed, degradedFor := lrt.health.success(r.URL.Host); recovered {
+		logDownstreamRecovered(ctxLogger, r.URL.Host, failures, suppressed, degradedFor)
+	}
+
+	if !debug && !boolOrDefault(lrt.cfg.ClientLogResponse, true) {
+		return resp, nil
+	}
+
+	// Read and log response body, unless its content type isn't worth logging. Such bodies
+	// are left unread, so the caller streams them as usual.
+	var respLog httpResponseLog
+	bodyLogged := contentTypeMatches(resp.Header.Get("Content-Type"), lrt.cfg.ClientLogBodyContentTypes)
 	var respBodyBytes []byte
-	if resp.Body != nil {
+	if resp.Body != nil && bodyLogged {
 		respBodyBytes, _ = io.ReadAll(resp.Body)
 		resp.Body = io.NopCloser(bytes.NewBuffer(respBodyBytes)) // Restore body
 	}
-	redactedRespBody := redactJSONBody(respBodyBytes, lrt.cfg.RedactKeys)
-	var respBodyForLog json.RawMessage
-	if len(redactedRespBody) > 0 {
-		respBodyForLog = json.RawMessage(redactedRespBody)
+	redactedRespBody, respBodyTruncated, redactionSkipped, redactionApplied := redactBodyForLog(respBodyBytes, lrt.redact, lrt.cfg.DropKeys, lrt.secrets, nestedJSONDepth(lrt.cfg), lrt.cfg.MaxBodyLogBytes)
+	if redactionSkipped && bodyLogged && !lrt.cfg.HashBodiesInsteadOfLog {
+		respLog.redactionSkipped = "parse_error"
+	}
+	if !bodyLogged {
+		respLog.bodyOmitted = "content_type"
+	} else if lrt.cfg.HashBodiesInsteadOfLog {
+		respLog.bodySHA256 = bodySHA256(respBodyBytes)
+	} else if redactionSkipped && !lrt.cfg.LogUnredactableBodies {
+		respLog.bodyOmitted = "redaction_skipped"
+	} else {
+		audit.jsonBody("response.body", respBodyBytes)
+		var rawTruncated bool
+		respLog.body, respLog.bodyRaw, rawTruncated = loggedBody(redactedRespBody, lrt.cfg.MaxBodyLogBytes)
+		respLog.bodyTruncated = respBodyTruncated
+		respLog.redactionApplied = redactionApplied
+		lrt.metrics.observeBody(respBodyBytes, redactedRespBody, respBodyTruncated || rawTruncated)
+	}
+	// Unread bodies only have their declared size
+	respBodySize := int64(len(respBodyBytes))
+	if !bodyLogged {
+		respBodySize = resp.ContentLength
+	}
+	respLog.contentType = resp.Header.Get("Content-Type")
+	if sanitize {
+		respLog.contentType = sanitizeString(respLog.contentType)
 	}
 
-	ctxLogger.Info("Client response received",
-		zap.String("method", r.Method),
-		zap.String("url", r.URL.String()),
-		zap.Int("status", resp.StatusCode),
+	respFields := []zap.Field{
+		zap.String(lrt.keys.method, r.Method),
+		zap.String(lrt.keys.url, logURL),
+		zap.Int(lrt.keys.status, resp.StatusCode),
+		optionalString("proto", resp.Proto),
 		zap.Int64("latency_ms", latency.Milliseconds()),
-		zap.Any("response", map[string]interface{}{"body": respBodyForLog}),
-	)
+		zap.String("latency_bucket", lrt.buckets.bucket(latency)),
+		bodyHashField("response_body_sha256", respBodyBytes, lrt.cfg.HashBodies && !lrt.cfg.HashBodiesInsteadOfLog),
+		omittedBodySizeField("resp_body_bytes", lrt.cfg.LogOmittedBodySize, respLog.bodyOmitted != "" || lrt.cfg.HashBodiesInsteadOfLog, respBodySize),
+	}
+
+	// Promote error envelope fields (from the redacted body) to the top level
+	respFields = append(respFields, errorEnvelopeFields(resp.StatusCode, redactedRespBody, lrt.cfg.ErrorEnvelopeFields)...)
+
+	respFields = append(respFields, lrt.keys.response(respLog))
+	ctxLogger.Info(lrt.messages.ClientResponseMsg, respFields...)
 
 	return resp, nil
 }
+
+// downstreamService returns the service name mapped to host, looked up with its port first
+// and then without it, ignoring case. An unmapped host is returned as is.
+func downstreamService(host string, services map[string]string) string {
+	lower := strings.ToLower(host)
+	hostname := lower
+	if h, _, err := net.SplitHostPort(lower); err == nil {
+		hostname = h
+	}
+	for key, service := range services {
+		if strings.ToLower(key) == lower {
+			return service
+		}
+	}
+	for key, service := range services {
+		if strings.ToLower(key) == hostname {
+			return service
+		}
+	}
+	return host
+}
+
+// contentTypeMatches reports whether the media type of contentType is in allowed, ignoring
+// parameters and case. An entry like "text/*" matches every subtype. An empty allowed list
+// matches everything.
+func contentTypeMatches(contentType string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, a := range allowed {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a == mediaType || (strings.HasSuffix(a, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(a, "*"))) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,9 +2,18 @@ package smartlog
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -20,6 +29,36 @@ func (m *mockRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
 	return m.roundTripFunc(r)
 }
 
+func TestClientLoggingMiddleware_GeneratesLogID(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+
+	var sentLogID string
+	mockTransport := &mockRoundTripper{
+		roundTripFunc: func(r *http.Request) (*http.Response, error) {
+			sentLogID = r.Header.Get(HeaderLogID)
+			return httptest.NewRecorder().Result(), nil
+		},
+	}
+
+	// No log ID in the context: one is generated for the call
+	req, err := http.NewRequest("GET", "http://downstream.example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClientLogger(mockTransport, zap.New(core), &Config{}).RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if sentLogID == "" {
+		t.Fatalf("expected a generated %s header", HeaderLogID)
+	}
+	for _, entry := range recorded.All() {
+		if entry.ContextMap()["log_id"] != sentLogID {
+			t.Errorf("expected %q to be logged with log_id %q, got %v", entry.Message, sentLogID, entry.ContextMap()["log_id"])
+		}
+	}
+}
+
 func TestClientLoggingMiddleware(t *testing.T) {
 	// Setup a mock logger to capture logs
 	core, recorded := observer.New(zapcore.InfoLevel)
@@ -96,3 +135,734 @@ func TestClientLoggingMiddleware(t *testing.T) {
 		t.Errorf("Accept header was incorrect: got '%s'", headers.Get("Accept"))
 	}
 }
+
+func TestClientLogging_CustomMessages(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	cfg := &Config{
+		ClientRequestMessage:  "http.client.request",
+		ClientResponseMessage: "http.client.response",
+	}
+
+	mockTransport := &mockRoundTripper{
+		roundTripFunc: func(r *http.Request) (*http.Response, error) {
+			return httptest.NewRecorder().Result(), nil
+		},
+	}
+	client := &http.Client{Transport: NewClientLogger(mockTransport, logger, cfg)}
+
+	req, _ := http.NewRequest("GET", "http://downstream.example.com", nil)
+	if _, err := client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if recorded.Len() != 2 {
+		t.Fatalf("expected 2 logs, but got %d", recorded.Len())
+	}
+	if msg := recorded.All()[0].Message; msg != "http.client.request" {
+		t.Errorf("unexpected request log message: got '%s'", msg)
+	}
+	if msg := recorded.All()[1].Message; msg != "http.client.response" {
+		t.Errorf("unexpected response log message: got '%s'", msg)
+	}
+}
+
+func TestClientLogging_Proto(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewClientLogger(http.DefaultTransport, zap.New(core), &Config{})}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	responses := recorded.FilterMessage(defaultClientResponseMessage).All()
+	if len(responses) != 1 {
+		t.Fatalf("expected 1 response log, got %d", len(responses))
+	}
+	if proto := responses[0].ContextMap()["proto"]; proto != "HTTP/1.1" {
+		t.Errorf("expected proto HTTP/1.1, got %v", proto)
+	}
+}
+
+func TestClientLogging_KeepsLargeIntegerIDs(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	const body = `{"id":10000000000000001,"password":"hunter2"}`
+	mockTransport := &mockRoundTripper{
+		roundTripFunc: func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+		},
+	}
+	transport := NewClientLogger(mockTransport, zap.New(core), &Config{RedactKeys: []string{"password"}})
+
+	req, _ := http.NewRequest(http.MethodPost, "http://api.example.com/orders", strings.NewReader(body))
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	const expected = `{"id":10000000000000001,"password":"[REDACTED]"}`
+	for _, entry := range recorded.All() {
+		key := "request"
+		if entry.Message == defaultClientResponseMessage {
+			key = "response"
+		}
+		logged, _ := entry.ContextMap()[key].(map[string]interface{})["body"].(json.RawMessage)
+		if string(logged) != expected {
+			t.Errorf("expected %s body %s in %q, got %s", key, expected, entry.Message, logged)
+		}
+	}
+	if n := len(recorded.All()); n != 2 {
+		t.Errorf("expected 2 logs, got %d", n)
+	}
+}
+
+func TestClientLogging_HostServiceMap(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	mockTransport := &mockRoundTripper{
+		roundTripFunc: func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+		},
+	}
+	cfg := &Config{HostServiceMap: map[string]string{"api.internal:8080": "payments", "users.internal": "users"}}
+	transport := NewClientLogger(mockTransport, zap.New(core), cfg)
+
+	for _, tc := range []struct{ url, expected string }{
+		{"http://api.internal:8080/charges", "payments"},
+		{"http://users.internal:9000/users/1", "users"},
+		{"http://unknown.internal/", "unknown.internal"},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, tc.url, nil)
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+
+		logs := recorded.TakeAll()
+		if len(logs) != 2 {
+			t.Fatalf("expected 2 logs for %s, got %d", tc.url, len(logs))
+		}
+		for _, entry := range logs {
+			if service := entry.ContextMap()["downstream_service"]; service != tc.expected {
+				t.Errorf("expected downstream_service %q in %q for %s, got %v", tc.expected, entry.Message, tc.url, service)
+			}
+		}
+	}
+}
+
+func TestWrapTransport_NilBaseUsesDefaultTransport(t *testing.T) {
+	logger := zap.NewNop()
+
+	transport := WrapTransport(nil, logger, &Config{})
+	lrt, ok := transport.(*loggingRoundTripper)
+	if !ok {
+		t.Fatalf("expected a *loggingRoundTripper, got %T", transport)
+	}
+	if lrt.next != http.DefaultTransport {
+		t.Errorf("expected nil base to default to http.DefaultTransport, got %T", lrt.next)
+	}
+
+	client := NewLoggingClient(&Config{}, logger)
+	if _, ok := client.Transport.(*loggingRoundTripper); !ok {
+		t.Errorf("expected NewLoggingClient to use the logging transport, got %T", client.Transport)
+	}
+}
+
+func TestNewClientLogger_NilNextUsesDefaultTransport(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: NewClientLogger(nil, zap.New(core), &Config{})}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected the request to go through http.DefaultTransport, got %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", resp.StatusCode)
+	}
+	if n := recorded.FilterMessage(defaultClientResponseMessage).Len(); n != 1 {
+		t.Errorf("expected a client response log, got %d", n)
+	}
+}
+
+func TestClientLogging_NoTransportReturnsError(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	lrt := &loggingRoundTripper{logger: zap.New(core), cfg: &Config{}}
+
+	_, err := lrt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://example.com", nil))
+	if err == nil || !strings.Contains(err.Error(), "no transport") {
+		t.Errorf("expected a no transport error, got %v", err)
+	}
+	if recorded.Len() != 0 {
+		t.Errorf("expected nothing to be logged, got %d entries", recorded.Len())
+	}
+}
+
+func TestClientLogging_LogBodyContentTypes(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/html" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("<html><body>Bad Gateway</body></html>"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: WrapTransport(nil, zap.New(core), &Config{ClientLogBodyContentTypes: []string{"application/json"}})}
+
+	for _, path := range []string{"/html", "/json"} {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("request to %s failed: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if len(body) == 0 {
+			t.Errorf("expected the caller to still get the %s body", path)
+		}
+	}
+
+	responses := recorded.FilterMessage(defaultClientResponseMessage).All()
+	if len(responses) != 2 {
+		t.Fatalf("expected 2 client response logs, got %d", len(responses))
+	}
+
+	html := responses[0].ContextMap()["response"].(map[string]interface{})
+	if html["body_omitted"] != "content_type" {
+		t.Errorf("expected the HTML body to be omitted for its content type, got %v", html)
+	}
+	if _, ok := html["body_raw"]; ok {
+		t.Errorf("expected no HTML body in the log, got %v", html["body_raw"])
+	}
+
+	jsonResp := responses[1].ContextMap()["response"].(map[string]interface{})
+	if body, ok := jsonResp["body"].(json.RawMessage); !ok || string(body) != `{"ok":true}` {
+		t.Errorf("expected the JSON body to be logged, got %v", jsonResp)
+	}
+}
+
+// headerTransport is a custom transport that sets a header before calling next.
+type headerTransport struct {
+	next http.RoundTripper
+}
+
+func (h *headerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r.Header.Set("X-Custom", "from-outer-transport")
+	return h.next.RoundTrip(r)
+}
+
+func TestClientLogging_LogOmittedBodySize(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	mockTransport := &mockRoundTripper{
+		roundTripFunc: func(r *http.Request) (*http.Response, error) {
+			rec := httptest.NewRecorder()
+			rec.Header().Set("Content-Type", "text/html")
+			rec.Header().Set("Content-Length", "15")
+			rec.WriteString("<html></html>\n\n")
+			return rec.Result(), nil
+		},
+	}
+	cfg := &Config{LogOmittedBodySize: true, ClientLogBodyContentTypes: []string{"application/json"}}
+	req, _ := http.NewRequest(http.MethodPost, "http://downstream.example.com", strings.NewReader(`{"q":1}`))
+	resp, err := NewClientLogger(mockTransport, zap.New(core), cfg).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	logs := recorded.All()
+	if len(logs) != 2 {
+		t.Fatalf("expected 2 logs, got %d", len(logs))
+	}
+	if _, ok := logs[0].ContextMap()["req_body_bytes"]; ok {
+		t.Error("expected no req_body_bytes for a logged request body")
+	}
+	if size := logs[1].ContextMap()["resp_body_bytes"]; size != int64(15) {
+		t.Errorf("expected resp_body_bytes 15 for a response omitted by content type, got %v", size)
+	}
+}
+
+func TestClientLogging_UnredactableBodies(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	mockTransport := &mockRoundTripper{
+		roundTripFunc: func(r *http.Request) (*http.Response, error) {
+			rec := httptest.NewRecorder()
+			rec.WriteString(`[{"token":"abc123"}`)
+			return rec.Result(), nil
+		},
+	}
+	cfg := &Config{RedactKeys: []string{"token"}}
+	req, _ := http.NewRequest(http.MethodPost, "http://downstream.example.com", strings.NewReader(`{"token":"abc123",}`))
+	resp, err := NewClientLogger(mockTransport, zap.New(core), cfg).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	logs := recorded.All()
+	if len(logs) != 2 {
+		t.Fatalf("expected 2 logs, got %d", len(logs))
+	}
+	for i, key := range []string{"request", "response"} {
+		logged := logs[i].ContextMap()[key].(map[string]interface{})
+		if logged["body_omitted"] != "redaction_skipped" || logged["redaction_skipped"] != "parse_error" {
+			t.Errorf("expected the unparseable %s body to be omitted, got %v", key, logged)
+		}
+		if _, ok := logged["body_raw"]; ok {
+			t.Errorf("expected no raw %s body in the log, got %v", key, logged["body_raw"])
+		}
+	}
+}
+
+func TestWrapTransport_Composition(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	var sawCustomHeader, sawLogID bool
+	base := &mockRoundTripper{
+		roundTripFunc: func(r *http.Request) (*http.Response, error) {
+			sawCustomHeader = r.Header.Get("X-Custom") == "from-outer-transport"
+			sawLogID = r.Header.Get(HeaderLogID) != ""
+			return httptest.NewRecorder().Result(), nil
+		},
+	}
+
+	// The custom transport wraps the logging transport, which wraps the base
+	client := &http.Client{Transport: &headerTransport{next: WrapTransport(base, logger, &Config{})}}
+	req, _ := http.NewRequest("GET", "http://downstream.example.com", nil)
+	if _, err := client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if !sawCustomHeader || !sawLogID {
+		t.Errorf("expected both transports to run, custom header: %v, log ID: %v", sawCustomHeader, sawLogID)
+	}
+	headers := recorded.All()[0].ContextMap()["request"].(map[string]interface{})["headers"].(http.Header)
+	if headers.Get("X-Custom") != "from-outer-transport" {
+		t.Errorf("expected the logged request to include headers set by the outer transport")
+	}
+}
+
+func TestClientLogging_ErrorClassification(t *testing.T) {
+	testCases := []struct {
+		name         string
+		err          error
+		expectedKind string
+	}{
+		{
+			name:         "DNS failure",
+			err:          &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "downstream.example.com", IsNotFound: true}},
+			expectedKind: "dns",
+		},
+		{
+			name:         "Connection refused",
+			err:          &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+			expectedKind: "connection_refused",
+		},
+		{
+			name:         "Timeout",
+			err:          context.DeadlineExceeded,
+			expectedKind: "timeout",
+		},
+		{
+			name:         "Canceled",
+			err:          context.Canceled,
+			expectedKind: "canceled",
+		},
+		{
+			name:         "Other",
+			err:          errors.New("something else"),
+			expectedKind: "other",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			core, recorded := observer.New(zapcore.InfoLevel)
+			logger := zap.New(core)
+
+			mockTransport := &mockRoundTripper{
+				roundTripFunc: func(r *http.Request) (*http.Response, error) {
+					return nil, tc.err
+				},
+			}
+			transport := NewClientLogger(mockTransport, logger, &Config{})
+
+			req, _ := http.NewRequest("GET", "http://downstream.example.com:8080/data", nil)
+			if _, err := transport.RoundTrip(req); err == nil {
+				t.Fatal("expected an error")
+			}
+
+			failures := recorded.FilterMessage("Client request failed").All()
+			if len(failures) != 1 {
+				t.Fatalf("expected 1 failure log, got %d", len(failures))
+			}
+			fields := failures[0].ContextMap()
+			if fields["error_kind"] != tc.expectedKind {
+				t.Errorf("expected error_kind '%s', got '%v'", tc.expectedKind, fields["error_kind"])
+			}
+			if fields["host"] != "downstream.example.com:8080" {
+				t.Errorf("unexpected host: got '%v'", fields["host"])
+			}
+		})
+	}
+}
+
+func TestClientLogging_ErrorEnvelopeFields(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	cfg := &Config{ErrorEnvelopeFields: map[string]string{"code": "error_code"}}
+	mockTransport := &mockRoundTripper{
+		roundTripFunc: func(r *http.Request) (*http.Response, error) {
+			rec := httptest.NewRecorder()
+			rec.WriteHeader(http.StatusServiceUnavailable)
+			rec.Body.WriteString(`{"code":14,"message":"unavailable"}`)
+			return rec.Result(), nil
+		},
+	}
+
+	req, _ := http.NewRequest("GET", "http://downstream.example.com", strings.NewReader(""))
+	if _, err := NewClientLogger(mockTransport, logger, cfg).RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+
+	fields := recorded.All()[1].ContextMap()
+	if fields["error_code"] != int64(14) {
+		t.Errorf("expected promoted error_code 14, got %v", fields["error_code"])
+	}
+}
+
+func TestClientLogging_LogRequestAndResponseToggles(t *testing.T) {
+	enabled, disabled := true, false
+	testCases := []struct {
+		name             string
+		logRequest       *bool
+		logResponse      *bool
+		expectedMessages []string
+	}{
+		{name: "Defaults log both", expectedMessages: []string{"Client request sent", "Client response received"}},
+		{name: "Request only", logRequest: &enabled, logResponse: &disabled, expectedMessages: []string{"Client request sent"}},
+		{name: "Response only", logRequest: &disabled, logResponse: &enabled, expectedMessages: []string{"Client response received"}},
+		{name: "Neither", logRequest: &disabled, logResponse: &disabled, expectedMessages: nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			core, recorded := observer.New(zapcore.InfoLevel)
+			logger := zap.New(core)
+			cfg := &Config{ClientLogRequest: tc.logRequest, ClientLogResponse: tc.logResponse}
+
+			mockTransport := &mockRoundTripper{
+				roundTripFunc: func(r *http.Request) (*http.Response, error) {
+					if r.Header.Get(HeaderLogID) == "" {
+						t.Error("expected the log ID header to be set")
+					}
+					return httptest.NewRecorder().Result(), nil
+				},
+			}
+			req, _ := http.NewRequest("GET", "http://downstream.example.com", nil)
+			if _, err := NewClientLogger(mockTransport, logger, cfg).RoundTrip(req); err != nil {
+				t.Fatal(err)
+			}
+
+			var messages []string
+			for _, entry := range recorded.All() {
+				messages = append(messages, entry.Message)
+			}
+			if strings.Join(messages, ",") != strings.Join(tc.expectedMessages, ",") {
+				t.Errorf("expected messages %v, got %v", tc.expectedMessages, messages)
+			}
+		})
+	}
+}
+
+func TestClientLogging_RateLimitsIdenticalFailures(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	mockTransport := &mockRoundTripper{
+		roundTripFunc: func(r *http.Request) (*http.Response, error) {
+			if r.URL.Host == "healthy.example.com" {
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+			}
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+		},
+	}
+	now := time.Unix(1700000000, 0)
+	transport := NewClientLogger(mockTransport, logger, &Config{ClientErrorLogIntervalMs: 1000},
+		WithClock(func() time.Time { return now }))
+
+	send := func(host string) {
+		req, _ := http.NewRequest("GET", "http://"+host+"/data", nil)
+		transport.RoundTrip(req)
+	}
+
+	// The first failure is logged, the next 9 within the interval are suppressed
+	for i := 0; i < 10; i++ {
+		send("flapping.example.com")
+		send("healthy.example.com")
+	}
+	// A different host is limited independently
+	send("other.example.com")
+
+	now = now.Add(time.Second)
+	send("flapping.example.com")
+
+	failures := recorded.FilterMessage("Client request failed").All()
+	if len(failures) != 3 {
+		t.Fatalf("expected 3 failure logs, got %d", len(failures))
+	}
+	if _, ok := failures[0].ContextMap()["suppressed_count"]; ok {
+		t.Errorf("expected no suppressed_count on the first failure")
+	}
+	if host := failures[1].ContextMap()["host"]; host != "other.example.com" {
+		t.Errorf("expected the second failure for other.example.com, got '%v'", host)
+	}
+	if count := failures[2].ContextMap()["suppressed_count"]; count != int64(9) {
+		t.Errorf("expected suppressed_count 9, got '%v'", count)
+	}
+
+	if responses := recorded.FilterMessage(defaultClientResponseMessage).Len(); responses != 10 {
+		t.Errorf("expected all 10 successful responses to be logged, got %d", responses)
+	}
+}
+
+func TestErrorLogLimiter_Bounded(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := newErrorLogLimiter(60000, funcClock(func() time.Time { return now }))
+
+	// Every key stays active, so none can be pruned as idle
+	for i := 0; i < maxErrorLimiterEntries+10; i++ {
+		now = now.Add(time.Millisecond)
+		limiter.allow(fmt.Sprintf("host-%d", i))
+	}
+	if n := len(limiter.entries); n != maxErrorLimiterEntries {
+		t.Fatalf("expected %d tracked keys, got %d", maxErrorLimiterEntries, n)
+	}
+	if _, ok := limiter.entries["host-0"]; ok {
+		t.Errorf("expected the oldest key to be evicted")
+	}
+	if ok, _ := limiter.allow(fmt.Sprintf("host-%d", maxErrorLimiterEntries+9)); ok {
+		t.Errorf("expected the latest key to still be limited")
+	}
+}
+
+func TestClientLogging_DownstreamDegraded(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	now := time.Unix(1700000000, 0)
+
+	// Five connection failures, a 503, then the host is back
+	calls := 0
+	mockTransport := &mockRoundTripper{
+		roundTripFunc: func(r *http.Request) (*http.Response, error) {
+			calls++
+			now = now.Add(time.Second)
+			switch {
+			case calls <= 5:
+				return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+			case calls == 6:
+				return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: io.NopCloser(strings.NewReader(""))}, nil
+			default:
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+			}
+		},
+	}
+	cfg := &Config{ClientDegradedThreshold: 3, ClientLogRequest: new(bool)}
+	transport := NewClientLogger(mockTransport, zap.New(core), cfg, WithClock(func() time.Time { return now }))
+
+	for i := 0; i < 7; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "http://payments.internal/charges", nil)
+		if resp, err := transport.RoundTrip(req); err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	var messages []string
+	for _, entry := range recorded.All() {
+		messages = append(messages, entry.Message)
+	}
+	expected := []string{
+		"Client request failed",
+		"Client request failed",
+		"Client request failed",
+		"Downstream degraded",
+		"Downstream recovered",
+		"Client response received",
+	}
+	if strings.Join(messages, ", ") != strings.Join(expected, ", ") {
+		t.Fatalf("expected messages %v, got %v", expected, messages)
+	}
+
+	degraded := recorded.FilterMessage("Downstream degraded").All()[0]
+	if degraded.Level != zapcore.WarnLevel {
+		t.Errorf("expected the degraded log at warn level, got %v", degraded.Level)
+	}
+	fields := degraded.ContextMap()
+	if fields["downstream_degraded"] != true || fields["host"] != "payments.internal" || fields["consecutive_failures"] != int64(3) {
+		t.Errorf("unexpected degraded fields: %v", fields)
+	}
+
+	fields = recorded.FilterMessage("Downstream recovered").All()[0].ContextMap()
+	if fields["downstream_recovered"] != true || fields["consecutive_failures"] != int64(6) || fields["suppressed_count"] != int64(3) {
+		t.Errorf("unexpected recovered fields: %v", fields)
+	}
+	if fields["degraded_ms"] != int64(4000) {
+		t.Errorf("expected degraded_ms 4000, got %v", fields["degraded_ms"])
+	}
+}
+
+func TestClientLogging_InheritsRequestLogger(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	cfg := &Config{}
+
+	mockTransport := &mockRoundTripper{
+		roundTripFunc: func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+		},
+	}
+	clientLogger := logger.With(zap.String("component", "client"))
+	client := &http.Client{Transport: NewClientLogger(mockTransport, clientLogger, cfg)}
+
+	handler := ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The handler enriches its logger and passes it down through the context
+		enriched := r.Context().Value(LoggerKey).(*zap.Logger).With(zap.String("user_id", "u-42"))
+		ctx := context.WithValue(r.Context(), LoggerKey, enriched)
+
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://downstream.example.com/profile", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set(HeaderLogID, "inherit-id")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	for _, msg := range []string{defaultClientRequestMessage, defaultClientResponseMessage} {
+		entries := recorded.FilterMessage(msg).All()
+		if len(entries) != 1 {
+			t.Fatalf("expected one %q log, got %d", msg, len(entries))
+		}
+		fields := entries[0].ContextMap()
+		if fields["user_id"] != "u-42" {
+			t.Errorf("expected %q to carry the handler's user_id, got '%v'", msg, fields["user_id"])
+		}
+		if fields["log_id"] != "inherit-id" {
+			t.Errorf("expected %q to carry log_id 'inherit-id', got '%v'", msg, fields["log_id"])
+		}
+		if fields["component"] != "client" {
+			t.Errorf("expected %q to be written by the client's logger, got component '%v'", msg, fields["component"])
+		}
+	}
+}
+
+func TestClientLogging_DebugHeaderMakesClientVerbose(t *testing.T) {
+	core, recorded := observer.New(zapcore.WarnLevel)
+	logger := zap.New(core)
+	cfg := &Config{AllowDebugHeader: true}
+
+	mockTransport := &mockRoundTripper{
+		roundTripFunc: func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+		},
+	}
+	client := &http.Client{Transport: NewClientLogger(mockTransport, logger, cfg)}
+
+	handler := ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, "http://downstream.example.com/profile", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	}))
+	serve := func(flagged bool) {
+		recorded.TakeAll()
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		if flagged {
+			req.Header.Set(HeaderDebugLog, "true")
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve(true)
+	for _, msg := range []string{defaultClientRequestMessage, defaultClientResponseMessage} {
+		entries := recorded.FilterMessage(msg).All()
+		if len(entries) != 1 {
+			t.Fatalf("expected one %q log for a flagged request below the logger's level, got %d", msg, len(entries))
+		}
+		if entries[0].ContextMap()["debug_log"] != true {
+			t.Errorf("expected %q to be marked debug_log, got %v", msg, entries[0].ContextMap()["debug_log"])
+		}
+	}
+
+	serve(false)
+	if n := recorded.FilterMessage(defaultClientRequestMessage).Len(); n != 0 {
+		t.Errorf("expected no client logs below the logger's level for an unflagged request, got %d", n)
+	}
+}
+
+func TestClientLogging_HashBodiesInsteadOfLog(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	mockTransport := &mockRoundTripper{
+		roundTripFunc: func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"token":"t"}`))}, nil
+		},
+	}
+	transport := NewClientLogger(mockTransport, logger, &Config{HashBodiesInsteadOfLog: true})
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodPost, "http://api.example.com/login", strings.NewReader(`{"user":"u"}`))
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	var hashes []interface{}
+	for _, entry := range recorded.All() {
+		key := "request"
+		if entry.Message == defaultClientResponseMessage {
+			key = "response"
+		}
+		logged := entry.ContextMap()[key].(map[string]interface{})
+		if _, ok := logged["body"]; ok {
+			t.Errorf("expected no %s body in %q, got '%v'", key, entry.Message, logged["body"])
+		}
+		hash, ok := logged["body_sha256"].(string)
+		if !ok || len(hash) != 64 {
+			t.Errorf("expected a %s body_sha256 in %q, got '%v'", key, entry.Message, logged["body_sha256"])
+		}
+		hashes = append(hashes, hash)
+	}
+	if len(hashes) != 4 {
+		t.Fatalf("expected 4 logs, got %d", len(hashes))
+	}
+	if hashes[0] != hashes[2] || hashes[1] != hashes[3] {
+		t.Errorf("expected identical bodies to produce identical hashes, got %v", hashes)
+	}
+	if hashes[0] == hashes[1] {
+		t.Errorf("expected different request and response hashes")
+	}
+}
@@ -1,29 +1,333 @@
 package smartlog
 
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Default log messages, used when the corresponding Config message is empty.
+const (
+	defaultRequestMessage        = "Request received"
+	defaultResponseMessage       = "Response sent"
+	defaultClientRequestMessage  = "Client request sent"
+	defaultClientResponseMessage = "Client response received"
+	defaultClientErrorMessage    = "Client request failed"
+)
+
+// defaultRedactHeaders are redacted in addition to RedactKeys unless
+// DisableDefaultHeaderRedaction is set.
+var defaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// Compression algorithms supported by timberjack for rotated log files.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// Strategies for truncating GORM results larger than GormConfig.LogResultMaxBytes.
+const (
+	TruncateStrategyFields  = "fields"
+	TruncateStrategyHead    = "head"
+	TruncateStrategySummary = "summary"
+)
+
 // TimberjackConfig holds the configuration for the timberjack logger.
 type TimberjackConfig struct {
 	Filename         string `mapstructure:"filename"`
 	MaxSize          int    `mapstructure:"max_size"`
 	MaxBackups       int    `mapstructure:"max_backups"`
 	MaxAge           int    `mapstructure:"max_age"`
-	Compression      string `mapstructure:"compression"`
+	Compression      string `mapstructure:"compression"`       // "none" (default), "gzip", or "zstd"
 	RotationInterval int    `mapstructure:"rotation_interval"` // in hours
 	Level            string `mapstructure:"level"`
+	RequireFile      bool   `mapstructure:"require_file"` // panic instead of falling back to console-only logging
 }
 
 // GormConfig holds the configuration for the GORM logger.
 type GormConfig struct {
-	Level             string `mapstructure:"level"`
-	LogQueryResult    bool   `mapstructure:"log_query_result"`
-	LogResultMaxBytes int    `mapstructure:"log_result_max_bytes"`
+	Level                string `mapstructure:"level"`
+	LogQueryResult       bool   `mapstructure:"log_query_result"`
+	LogResultMaxBytes    int    `mapstructure:"log_result_max_bytes"`
+	LogResultMaxElements int    `mapstructure:"log_result_max_elements"` // log only the first N rows of slice results, plus a total count
+	TruncateStrategy     string `mapstructure:"truncate_strategy"`       // "fields" (default), "head" or "summary"
+}
+
+// MessagesConfig overrides the messages of the request and response logs, e.g. with shorter
+// ones like "http.request" or localized ones. A field set here wins over the deprecated
+// Config field for the same message, e.g. RequestMsg over Config.RequestMessage; empty fields
+// fall back to that field, then to the default.
+type MessagesConfig struct {
+	RequestMsg        string `mapstructure:"request"`         // server request log; defaults to "Request received"
+	ResponseMsg       string `mapstructure:"response"`        // server response log; defaults to "Response sent"
+	ClientRequestMsg  string `mapstructure:"client_request"`  // client request log; defaults to "Client request sent"
+	ClientResponseMsg string `mapstructure:"client_response"` // client response log; defaults to "Client response received"
+	ClientErrorMsg    string `mapstructure:"client_error"`    // failed client request log; defaults to "Client request failed"
+}
+
+// AuditConfig holds the configuration for the audit logger.
+type AuditConfig struct {
+	Filename string `mapstructure:"filename"`
+}
+
+// RouteConfig overrides the logging behavior for requests matching a Config.RouteOverrides pattern.
+// Unset fields keep the global behavior.
+type RouteConfig struct {
+	RedactKeys      []string `mapstructure:"redact_keys"`       // redacted in addition to the global redact_keys
+	LogRequestBody  *bool    `mapstructure:"log_request_body"`  // log the request body; defaults to true
+	LogResponseBody *bool    `mapstructure:"log_response_body"` // log the response body; defaults to true
+	Skip            bool     `mapstructure:"skip"`              // skip logging entirely, like skip_paths
+	Level           string   `mapstructure:"level"`             // level of the request and response logs; defaults to "info"
 }
 
 // Config holds the configuration for the logger.
 type Config struct {
-	ServiceName string           `mapstructure:"service_name"`
-	Env         string           `mapstructure:"env"`
-	Log         TimberjackConfig `mapstructure:"log"`
-	Gorm        GormConfig       `mapstructure:"gorm"`
-	RedactKeys  []string         `mapstructure:"redact_keys"`
-	SkipPaths   []string         `mapstructure:"skip_paths"`
+	ServiceName                    string                 `mapstructure:"service_name"`
+	Env                            string                 `mapstructure:"env"`
+	Version                        string                 `mapstructure:"version"`        // build version logged as version; read from the build info when empty
+	LogBuildInfo                   bool                   `mapstructure:"log_build_info"` // log the BuildVersion, BuildCommit and BuildTime set via ldflags as version, commit and build_time
+	Log                            TimberjackConfig       `mapstructure:"log"`
+	Gorm                           GormConfig             `mapstructure:"gorm"`
+	Audit                          AuditConfig            `mapstructure:"audit"`
+	RedactKeys                     []string               `mapstructure:"redact_keys"`
+	RedactByEnv                    map[string][]string    `mapstructure:"redact_by_env"`                    // env -> keys redacted in addition to redact_keys when env matches
+	DropKeys                       []string               `mapstructure:"drop_keys"`                        // body keys and headers removed from the logs entirely instead of redacted
+	RedactNestedJSONStrings        bool                   `mapstructure:"redact_nested_json_strings"`       // also redact string values that hold JSON, e.g. double-encoded webhook payloads
+	LogFields                      []string               `mapstructure:"log_fields"`                       // standard fields logged by the server middleware, e.g. [method, path, status]; all when empty
+	DisableDefaultHeaderRedaction  bool                   `mapstructure:"disable_default_header_redaction"` // don't redact Authorization, Cookie and the like unless listed in redact_keys
+	SkipPaths                      []string               `mapstructure:"skip_paths"`
+	SkipMethods                    []string               `mapstructure:"skip_methods"`                         // HTTP methods never logged, e.g. OPTIONS and HEAD
+	FieldNaming                    string                 `mapstructure:"field_naming"`                         // "snake" (default) or "camel"; renames top-level keys only, not those inside objects such as request
+	FieldNames                     map[string]string      `mapstructure:"field_names"`                          // per-field key overrides, keyed by snake_case name
+	AsyncCore                      bool                   `mapstructure:"async_core"`                           // set when the logger's core encodes entries asynchronously
+	LatencyBuckets                 []int                  `mapstructure:"latency_buckets"`                      // bucket boundaries in milliseconds for the latency_bucket field
+	Messages                       MessagesConfig         `mapstructure:"messages"`                             // log message overrides; take precedence over the deprecated *_message settings
+	LogFullURL                     bool                   `mapstructure:"log_full_url"`                         // log a url field with the query (and scheme/host when known)
+	LogQueryParams                 bool                   `mapstructure:"log_query_params"`                     // log the parsed query string as a query object, with redact_keys values redacted
+	TrustProxyHeaders              bool                   `mapstructure:"trust_proxy_headers"`                  // trust X-Forwarded-* headers set by a reverse proxy
+	ErrorEnvelopeFields            map[string]string      `mapstructure:"error_envelope_fields"`                // body path -> field name, promoted on non-2xx responses
+	LogRequest                     *bool                  `mapstructure:"log_request"`                          // emit the server request log; defaults to true
+	LogResponse                    *bool                  `mapstructure:"log_response"`                         // emit the server response log; defaults to true
+	ClientLogRequest               *bool                  `mapstructure:"client_log_request"`                   // emit the client request log; defaults to true
+	ClientLogResponse              *bool                  `mapstructure:"client_log_response"`                  // emit the client response log; defaults to true
+	ClientLogBodyContentTypes      []string               `mapstructure:"client_log_body_content_types"`        // log client response bodies only for these media types, e.g. application/json; empty logs all
+	HostServiceMap                 map[string]string      `mapstructure:"host_service_map"`                     // client target host (with or without port) -> service name logged as downstream_service; unmapped hosts log the host
+	RedactPathSegments             []string               `mapstructure:"redact_path_segments"`                 // regex patterns for path segments to mask in the logged path
+	ConsoleColor                   bool                   `mapstructure:"console_color"`                        // colorize levels in console output; NO_COLOR overrides
+	PrettyJSON                     bool                   `mapstructure:"pretty_json"`                          // indent the JSON of the log file and console fields for local debugging; breaks most log ingesters, never use in production
+	SanitizeControlChars           *bool                  `mapstructure:"sanitize_control_chars"`               // escape control characters in logged request strings; defaults to true
+	RedactAudit                    bool                   `mapstructure:"redact_audit"`                         // log a redaction_audit entry listing which redact keys matched, without values
+	RedactHighEntropy              bool                   `mapstructure:"redact_high_entropy"`                  // redact JWTs and long high-entropy strings in bodies regardless of key
+	RedactHighEntropyMinLength     int                    `mapstructure:"redact_high_entropy_min_length"`       // shortest string checked for high entropy; defaults to 32
+	LogUnredactableBodies          bool                   `mapstructure:"log_unredactable_bodies"`              // log bodies that look like JSON but couldn't be parsed for redaction as is, marked redaction_skipped; by default they are omitted with body_omitted: redaction_skipped
+	MaxRequestBytes                int64                  `mapstructure:"max_request_bytes"`                    // reject larger request bodies with 413 before the handler runs; 0 disables
+	MaxBodyLogBytes                int                    `mapstructure:"max_body_log_bytes"`                   // truncate logged bodies to this many bytes, JSON ones to valid JSON marked body_truncated; 0 disables
+	MaxHeaderValueLogBytes         int                    `mapstructure:"max_header_value_log_bytes"`           // truncate logged header values longer than this many bytes, marked ...(truncated); the request keeps the real values; 0 disables
+	LogUploadFiles                 bool                   `mapstructure:"log_upload_files"`                     // log the files of multipart/form-data requests (field, filename, size, content type) instead of the body
+	GraphQLStripQuery              bool                   `mapstructure:"graphql_strip_query"`                  // leave the query string out of logged GraphQL request bodies; graphql_operation and the variables are still logged
+	UploadScanMaxBytes             int64                  `mapstructure:"upload_scan_max_bytes"`                // bytes of a multipart body scanned for file metadata; defaults to 10 MiB
+	LogResponseBodyOnStatusAtLeast int                    `mapstructure:"log_response_body_on_status_at_least"` // only log server response bodies at or above this status; 0 logs all
+	CaptureResponseBodyOnError     bool                   `mapstructure:"capture_response_body_on_error"`       // only buffer and log server response bodies written after the handler calls SetError; others log body_omitted: no_error
+	LogOmittedBodySize             bool                   `mapstructure:"log_omitted_body_size"`                // log req_body_bytes and resp_body_bytes with the size of bodies left out of the logs (omitted, hashed or sampled out)
+	StacktraceOn5xx                bool                   `mapstructure:"stacktrace_on_5xx"`                    // attach a stacktrace to server response logs with status >= 500 only (the panic stack when Recovery caught one), whatever their level
+	ClientErrorLogIntervalMs       int                    `mapstructure:"client_error_log_interval_ms"`         // log identical client failures (same host and error_kind) at most once per interval; 0 disables
+	ClientDegradedThreshold        int                    `mapstructure:"client_degraded_threshold"`            // consecutive failures (transport errors and 5xx) of a host after which downstream_degraded is logged once and its failure logs are suppressed until a success logs downstream_recovered; 0 disables
+	LogTLSInfo                     bool                   `mapstructure:"log_tls_info"`                         // log the negotiated TLS version, cipher and client certificate presence
+	GeoHeaders                     map[string]string      `mapstructure:"geo_headers"`                          // geo hint header -> request log field, e.g. CF-IPCountry: geo_country
+	FlattenFields                  bool                   `mapstructure:"flatten_fields"`                       // emit request_*/response_* top-level keys instead of nested request/response objects
+	SampleRate                     *float64               `mapstructure:"sample_rate"`                          // fraction of requests logged; errors and slow responses are always logged; defaults to 1
+	RouteSampleRates               map[string]float64     `mapstructure:"route_sample_rates"`                   // path pattern ("/metrics", "/internal/*"), or route returned by RouteFunc -> sample rate used instead of sample_rate; the most specific match wins
+	DetailSampleRate               *float64               `mapstructure:"detail_sample_rate"`                   // fraction of logged requests with headers and bodies; the rest log metadata only; defaults to 1
+	AllowDebugHeader               bool                   `mapstructure:"allow_debug_header"`                   // honor X-Debug-Log: true to log that request in full at debug level; only enable when clients are trusted
+	SlowRequestThresholdMs         int                    `mapstructure:"slow_request_threshold_ms"`            // mark responses at least this slow with slow: true and never sample them out; 0 disables
+	AlwaysLogSlowerThanMs          int                    `mapstructure:"always_log_slower_than_ms"`            // alias of slow_request_threshold_ms, used when that is unset
+	RequestTimeoutMs               int                    `mapstructure:"request_timeout_ms"`                   // deadline DefaultStack sets on the request context; 0 disables
+	HashBodies                     bool                   `mapstructure:"hash_bodies"`                          // log request_body_sha256/response_body_sha256 of the raw bodies
+	HashBodiesInsteadOfLog         bool                   `mapstructure:"hash_bodies_instead_of_log"`           // log body_sha256 of the raw bodies in place of the bodies
+	RouteOverrides                 map[string]RouteConfig `mapstructure:"route_overrides"`                      // path pattern ("/login", "/admin/*"), or route returned by RouteFunc -> overrides; the most specific match wins
+
+	// LogIDContextKeys are context keys checked, in order, for an existing log ID before
+	// falling back to the X-Request-ID header. Values may be strings or fmt.Stringers.
+	LogIDContextKeys []interface{} `mapstructure:"-"`
+
+	// SkipFunc, if set, is evaluated for every request in addition to SkipPaths.
+	// Returning true skips logging for that request.
+	SkipFunc func(r *http.Request) bool `mapstructure:"-"`
+
+	// RouteFunc, if set, returns the route of a request (e.g. a router's matched pattern), stored
+	// in the request context for GORM logs and matched by RouteOverrides and RouteSampleRates.
+	// It is called once per request. By default, or when it returns an empty route, the pattern
+	// matched by an enclosing http.ServeMux is used, falling back to the logged path.
+	RouteFunc func(r *http.Request) string `mapstructure:"-"`
+
+	// HandlerNameFunc, if set, returns the name of the handler serving a request, logged as
+	// the handler field of the response log. A name set with NamedHandler takes precedence.
+	HandlerNameFunc func(r *http.Request) string `mapstructure:"-"`
+
+	// RequestMessage is the message of the server request log.
+	//
+	// Deprecated: Use Messages.RequestMsg, which wins when both are set.
+	RequestMessage string `mapstructure:"request_message"`
+
+	// ResponseMessage is the message of the server response log.
+	//
+	// Deprecated: Use Messages.ResponseMsg, which wins when both are set.
+	ResponseMessage string `mapstructure:"response_message"`
+
+	// ClientRequestMessage is the message of the client request log.
+	//
+	// Deprecated: Use Messages.ClientRequestMsg, which wins when both are set.
+	ClientRequestMessage string `mapstructure:"client_request_message"`
+
+	// ClientResponseMessage is the message of the client response log.
+	//
+	// Deprecated: Use Messages.ClientResponseMsg, which wins when both are set.
+	ClientResponseMessage string `mapstructure:"client_response_message"`
+}
+
+// allRedactKeys returns RedactKeys and the RedactByEnv keys for Env, merged with the default
+// sensitive headers.
+func (c *Config) allRedactKeys() []string {
+	keys := c.RedactKeys
+	if envKeys := c.RedactByEnv[c.Env]; len(envKeys) > 0 {
+		keys = append(slices.Clip(keys), envKeys...)
+	}
+	if c.DisableDefaultHeaderRedaction {
+		return keys
+	}
+	return append(append([]string(nil), defaultRedactHeaders...), keys...)
+}
+
+// messages resolves the log messages: Messages overrides first, then the deprecated *Message
+// fields, then the defaults.
+func (c *Config) messages() MessagesConfig {
+	return MessagesConfig{
+		RequestMsg:        orDefault(c.Messages.RequestMsg, orDefault(c.RequestMessage, defaultRequestMessage)),
+		ResponseMsg:       orDefault(c.Messages.ResponseMsg, orDefault(c.ResponseMessage, defaultResponseMessage)),
+		ClientRequestMsg:  orDefault(c.Messages.ClientRequestMsg, orDefault(c.ClientRequestMessage, defaultClientRequestMessage)),
+		ClientResponseMsg: orDefault(c.Messages.ClientResponseMsg, orDefault(c.ClientResponseMessage, defaultClientResponseMessage)),
+		ClientErrorMsg:    orDefault(c.Messages.ClientErrorMsg, defaultClientErrorMessage),
+	}
+}
+
+// slowRequestThresholdMs returns the slow request threshold, taking AlwaysLogSlowerThanMs
+// as an alias when SlowRequestThresholdMs is unset.
+func (c *Config) slowRequestThresholdMs() int {
+	if c.SlowRequestThresholdMs > 0 {
+		return c.SlowRequestThresholdMs
+	}
+	return c.AlwaysLogSlowerThanMs
+}
+
+// sanitizeControlChars reports whether control characters in logged strings should be escaped.
+func (c *Config) sanitizeControlChars() bool {
+	return boolOrDefault(c.SanitizeControlChars, true)
+}
+
+// boolOrDefault returns the value of an optional boolean setting, or def if it is unset.
+func boolOrDefault(value *bool, def bool) bool {
+	if value == nil {
+		return def
+	}
+	return *value
+}
+
+// floatOrDefault returns the value of an optional number setting, or def if it is unset.
+func floatOrDefault(value *float64, def float64) float64 {
+	if value == nil {
+		return def
+	}
+	return *value
+}
+
+// orDefault returns value, or def if value is empty.
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// Validate checks the configuration for values that would otherwise be silently ignored
+// or only fail at runtime. All problems found are returned together.
+//
+// Validate doesn't create anything: it checks that NewLogger will be able to create or
+// open the log file, since NewLogger would fall back to console-only logging otherwise.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if err := checkLogFile(c.Log.Filename); err != nil {
+		errs = append(errs, fmt.Errorf("log.filename: %w", err))
+	}
+
+	if err := validateCompression(c.Log.Compression); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, name := range c.LogFields {
+		if !slices.Contains(logFieldNames, strings.ToLower(strings.TrimSpace(name))) {
+			errs = append(errs, fmt.Errorf("log_fields: unknown field %q, must be one of %s",
+				name, strings.Join(logFieldNames, ", ")))
+		}
+	}
+
+	switch strings.ToLower(c.FieldNaming) {
+	case "", FieldNamingSnake, FieldNamingCamel:
+	default:
+		errs = append(errs, fmt.Errorf("field_naming: unsupported value %q, must be %q or %q",
+			c.FieldNaming, FieldNamingSnake, FieldNamingCamel))
+	}
+
+	switch strings.ToLower(c.Gorm.TruncateStrategy) {
+	case "", TruncateStrategyFields, TruncateStrategyHead, TruncateStrategySummary:
+	default:
+		errs = append(errs, fmt.Errorf("gorm.truncate_strategy: unsupported value %q, must be one of %q, %q, %q",
+			c.Gorm.TruncateStrategy, TruncateStrategyFields, TruncateStrategyHead, TruncateStrategySummary))
+	}
+
+	if c.SampleRate != nil && (*c.SampleRate < 0 || *c.SampleRate > 1) {
+		errs = append(errs, fmt.Errorf("sample_rate: %v is out of range [0, 1]", *c.SampleRate))
+	}
+	if c.DetailSampleRate != nil && (*c.DetailSampleRate < 0 || *c.DetailSampleRate > 1) {
+		errs = append(errs, fmt.Errorf("detail_sample_rate: %v is out of range [0, 1]", *c.DetailSampleRate))
+	}
+	for pattern, rate := range c.RouteSampleRates {
+		if rate < 0 || rate > 1 {
+			errs = append(errs, fmt.Errorf("route_sample_rates[%q]: %v is out of range [0, 1]", pattern, rate))
+		}
+	}
+
+	for pattern, route := range c.RouteOverrides {
+		if route.Level == "" {
+			continue
+		}
+		if _, err := zapcore.ParseLevel(route.Level); err != nil {
+			errs = append(errs, fmt.Errorf("route_overrides[%q].level: %w", pattern, err))
+		}
+	}
+
+	for _, pattern := range c.RedactPathSegments {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("redact_path_segments: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateCompression checks a log.compression value against the algorithms timberjack supports.
+// An empty value is accepted and leaves compression off.
+func validateCompression(compression string) error {
+	switch strings.ToLower(strings.TrimSpace(compression)) {
+	case "", CompressionNone, CompressionGzip, CompressionZstd:
+		return nil
+	}
+	return fmt.Errorf("log.compression: unsupported value %q, must be one of %q, %q, %q",
+		compression, CompressionNone, CompressionGzip, CompressionZstd)
 }
@@ -0,0 +1,97 @@
+package smartlog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidate_Compression(t *testing.T) {
+	for _, compression := range []string{"", CompressionNone, CompressionGzip, CompressionZstd, "GZIP"} {
+		cfg := &Config{Log: TimberjackConfig{Compression: compression}}
+		assert.NoError(t, cfg.Validate(), "compression %q", compression)
+	}
+
+	cfg := &Config{Log: TimberjackConfig{Compression: "bzip2"}}
+	err := cfg.Validate()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `log.compression: unsupported value "bzip2"`)
+	}
+}
+
+func TestConfigValidate_ReportsAllErrors(t *testing.T) {
+	cfg := &Config{
+		Log:                TimberjackConfig{Compression: "lz4"},
+		FieldNaming:        "kebab",
+		LogFields:          []string{"method", "body"},
+		RedactPathSegments: []string{"[0-9"},
+		SampleRate:         new(float64),
+		DetailSampleRate:   new(float64),
+		RouteSampleRates:   map[string]float64{"/metrics": 2},
+		Gorm:               GormConfig{TruncateStrategy: "middle"},
+	}
+	*cfg.SampleRate = 1.5
+	*cfg.DetailSampleRate = -0.5
+	err := cfg.Validate()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "log.compression")
+		assert.Contains(t, err.Error(), "field_naming")
+		assert.Contains(t, err.Error(), `log_fields: unknown field "body"`)
+		assert.Contains(t, err.Error(), "redact_path_segments")
+		assert.Contains(t, err.Error(), "sample_rate")
+		assert.Contains(t, err.Error(), "detail_sample_rate")
+		assert.Contains(t, err.Error(), `route_sample_rates["/metrics"]`)
+		assert.Contains(t, err.Error(), "gorm.truncate_strategy")
+	}
+}
+
+func TestConfigValidate_UnwritableLogFile(t *testing.T) {
+	// A regular file can't act as the parent directory of the log file
+	blocker := filepath.Join(t.TempDir(), "not-a-dir")
+	require.NoError(t, os.WriteFile(blocker, nil, 0o644))
+
+	cfg := &Config{Log: TimberjackConfig{Filename: filepath.Join(blocker, "app.log")}}
+	err := cfg.Validate()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "log.filename")
+	}
+
+	dir := t.TempDir()
+	cfg.Log.Filename = filepath.Join(dir, "logs", "app.log")
+	assert.NoError(t, cfg.Validate())
+
+	// Validate only checks: it leaves no directory, log file or probe file behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	cfg.Log.Filename = dir
+	err = cfg.Validate()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is a directory")
+	}
+}
+
+func TestConfigMessages_Precedence(t *testing.T) {
+	cfg := &Config{
+		RequestMessage:       "legacy.request",
+		ResponseMessage:      "legacy.response",
+		ClientRequestMessage: "legacy.client_request",
+		Messages: MessagesConfig{
+			RequestMsg:        "http.request",
+			ClientResponseMsg: "http.client.response",
+		},
+	}
+	assert.Equal(t, MessagesConfig{
+		RequestMsg:        "http.request",          // Messages wins over the deprecated field
+		ResponseMsg:       "legacy.response",       // the deprecated field is used when Messages is empty
+		ClientRequestMsg:  "legacy.client_request", // the deprecated field is used when Messages is empty
+		ClientResponseMsg: "http.client.response",  // Messages alone
+		ClientErrorMsg:    defaultClientErrorMessage,
+	}, cfg.messages())
+
+	assert.Equal(t, defaultRequestMessage, (&Config{}).messages().RequestMsg)
+}
@@ -0,0 +1,295 @@
+package smartlog
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// LogIDFromContext returns the log ID stored in the context by the middleware,
+// or an empty string if there is none.
+func LogIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	logID, _ := ctx.Value(LogIDKey).(string)
+	return logID
+}
+
+// Tagged returns logger tagged with the log ID from the context, so components that only
+// have a base logger and a context can still correlate their logs with the request.
+// The logger is returned unchanged if the context carries no log ID.
+func Tagged(ctx context.Context, logger *zap.Logger) *zap.Logger {
+	logID := LogIDFromContext(ctx)
+	if logID == "" {
+		return logger
+	}
+	return logger.With(zap.String("log_id", logID))
+}
+
+// DetachedLogger returns the request's logger from ctx, with its log_id and every field the
+// handler added to it, for fire-and-forget work that outlives the request, such as a goroutine
+// spawned by the handler. The logger holds no reference to ctx and no longer feeds the
+// request's warn_count/error_count, so it's safe to use after the handler returns. Pair it
+// with context.WithoutCancel(ctx) when the work needs the context's values.
+//
+// If ctx carries no logger, the global zap logger is returned.
+func DetachedLogger(ctx context.Context) *zap.Logger {
+	if ctx == nil {
+		return zap.L()
+	}
+	logger, ok := ctx.Value(LoggerKey).(*zap.Logger)
+	if !ok || logger == nil {
+		return zap.L()
+	}
+	return logger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		if counting, ok := c.(*levelCountingCore); ok {
+			return counting.Core
+		}
+		return c
+	}))
+}
+
+// DebugFromContext reports whether the request was flagged for full debug logging with the
+// X-Debug-Log header. The context's logger then emits every level; loggers that don't come
+// from the context, like the GORM logger's level, use it to become verbose too.
+func DebugFromContext(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	debug, _ := ctx.Value(debugKey).(bool)
+	return debug
+}
+
+// WithRoute returns a copy of ctx carrying route. The server middleware stores the route of
+// each request this way; handlers can call it to refine the route for code further down.
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, RouteKey, route)
+}
+
+// RouteFromContext returns the route stored in the context, or an empty string if there is none.
+func RouteFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	route, _ := ctx.Value(RouteKey).(string)
+	return route
+}
+
+// ResponseStatus returns the status code written by the handler of the request, or 0 if the
+// context doesn't belong to a request served by the middleware or nothing has been written yet.
+// It's meant for deferred code that runs once the handler has written its response.
+func ResponseStatus(ctx context.Context) int {
+	rw := responseWriterFromContext(ctx)
+	if rw == nil || !rw.wroteHeader {
+		return 0
+	}
+	return rw.statusCode
+}
+
+// ResponseBytes returns the number of response body bytes written so far by the handler of the
+// request, or 0 if the context doesn't belong to a request served by the middleware.
+func ResponseBytes(ctx context.Context) int {
+	rw := responseWriterFromContext(ctx)
+	if rw == nil {
+		return 0
+	}
+	return rw.bytes
+}
+
+func responseWriterFromContext(ctx context.Context) *responseWriter {
+	if ctx == nil {
+		return nil
+	}
+	rw, _ := ctx.Value(responseKey).(*responseWriter)
+	return rw
+}
+
+// requestState holds values a handler reports during the request for the response log.
+type requestState struct {
+	mu        sync.Mutex
+	operation string
+	handler   string
+
+	// Binding and validation errors reported with LogValidationError
+	validationErrors validationErrors
+
+	// Warnings and errors logged through the handler's logger
+	warnCount  int
+	errorCount int
+
+	// Stack of a panic caught by Recovery inside the middleware
+	panicStack []byte
+
+	// Error reported with SetError
+	err error
+}
+
+func requestStateFromContext(ctx context.Context) *requestState {
+	if ctx == nil {
+		return nil
+	}
+	state, _ := ctx.Value(stateKey).(*requestState)
+	return state
+}
+
+// SetOperation records the semantic operation a handler performs (e.g. "CreateUser"),
+// logged as the operation field of the response log. It does nothing if the context
+// doesn't belong to a request served by the middleware.
+func SetOperation(ctx context.Context, name string) {
+	state := requestStateFromContext(ctx)
+	if state == nil {
+		return
+	}
+	state.mu.Lock()
+	state.operation = name
+	state.mu.Unlock()
+}
+
+// getOperation returns the operation set by the handler, or an empty string.
+func (s *requestState) getOperation() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.operation
+}
+
+// NamedHandler wraps h so the response log of its requests carries a handler field with
+// name. It is meant for routers without route patterns, such as a plain http.ServeMux
+// registered with paths, to attribute requests to the code that served them. The
+// middleware must wrap the router for the name to be logged.
+func NamedHandler(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if state := requestStateFromContext(r.Context()); state != nil {
+			state.mu.Lock()
+			state.handler = name
+			state.mu.Unlock()
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// getHandler returns the name set by NamedHandler, or an empty string.
+func (s *requestState) getHandler() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.handler
+}
+
+// countLevel records an entry logged through the handler's logger.
+func (s *requestState) countLevel(level zapcore.Level) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case level >= zapcore.ErrorLevel:
+		s.errorCount++
+	case level == zapcore.WarnLevel:
+		s.warnCount++
+	}
+}
+
+// levelCounts returns the number of warnings and errors the handler logged.
+func (s *requestState) levelCounts() (warnCount, errorCount int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.warnCount, s.errorCount
+}
+
+// SetError records the error a handler failed with, logged as the error field of the
+// response log. With Config.CaptureResponseBodyOnError, the response body is only captured
+// once an error is set, so call it before writing the error response. It does nothing if
+// err is nil or the context doesn't belong to a request served by the middleware.
+func SetError(ctx context.Context, err error) {
+	state := requestStateFromContext(ctx)
+	if state == nil || err == nil {
+		return
+	}
+	state.mu.Lock()
+	state.err = err
+	state.mu.Unlock()
+}
+
+// getError returns the error set by the handler, or nil.
+func (s *requestState) getError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// setPanicStack records the stack of a panic recovered while serving the request.
+func (s *requestState) setPanicStack(stack []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.panicStack = stack
+}
+
+// getPanicStack returns the stack recorded by Recovery, or nil.
+func (s *requestState) getPanicStack() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.panicStack
+}
+
+// levelCountingCore counts the warnings and errors logged through the logger the
+// middleware hands to the request, so the response log can be escalated. It also keeps
+// the fields the handler adds to that logger, which the client logger copies.
+type levelCountingCore struct {
+	zapcore.Core
+	state  *requestState
+	fields []zapcore.Field
+}
+
+// With keeps counting into the same request state.
+func (c *levelCountingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCountingCore{
+		Core:   c.Core.With(fields),
+		state:  c.state,
+		fields: append(slices.Clip(c.fields), fields...),
+	}
+}
+
+// scopedFields returns the fields the handler added to the request's logger in ctx, on top
+// of the log ID and baggage the middleware tagged it with.
+func scopedFields(ctx context.Context) []zapcore.Field {
+	logger, ok := ctx.Value(LoggerKey).(*zap.Logger)
+	if !ok || logger == nil {
+		return nil
+	}
+	if counting, ok := logger.Core().(*levelCountingCore); ok {
+		return counting.fields
+	}
+	return nil
+}
+
+// Check leaves the decision to write the entry to the wrapped core, and counts the entry
+// only if it is written, so entries filtered out by level or sampling aren't counted.
+func (c *levelCountingCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	checked := c.Core.Check(ent, ce)
+	if checked != nil && ent.Level >= zapcore.WarnLevel {
+		c.state.countLevel(ent.Level)
+	}
+	return checked
+}
+
+// debugCore enables every level for a request flagged for debug logging, whatever the level
+// of the wrapped core. Entries are written to the wrapped core directly, bypassing its own
+// level check.
+type debugCore struct {
+	zapcore.Core
+}
+
+// Enabled enables every level.
+func (c *debugCore) Enabled(zapcore.Level) bool { return true }
+
+// With keeps the debug override on the child core.
+func (c *debugCore) With(fields []zapcore.Field) zapcore.Core {
+	return &debugCore{Core: c.Core.With(fields)}
+}
+
+// Check adds the core to the checked entry regardless of its level.
+func (c *debugCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return ce.AddCore(ent, c)
+}
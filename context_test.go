@@ -0,0 +1,193 @@
+package smartlog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestTagged(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	baseLogger := zap.New(core)
+
+	t.Run("Tags lines with the context log ID", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), LogIDKey, "tagged-log-id")
+		Tagged(ctx, baseLogger).Info("deep in the stack")
+
+		assert.Equal(t, "tagged-log-id", recorded.All()[0].ContextMap()["log_id"])
+		recorded.TakeAll()
+	})
+
+	t.Run("Leaves the logger unchanged without a log ID", func(t *testing.T) {
+		Tagged(context.Background(), baseLogger).Info("no request")
+
+		assert.NotContains(t, recorded.All()[0].ContextMap(), "log_id")
+		recorded.TakeAll()
+	})
+}
+
+func TestResponseStatusAndBytes(t *testing.T) {
+	var status, size int
+	handler := ServerLogging(zap.NewNop(), &Config{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			status = ResponseStatus(r.Context())
+			size = ResponseBytes(r.Context())
+		}()
+
+		assert.Equal(t, 0, ResponseStatus(r.Context()), "nothing written yet")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":1}`))
+		// The wrapper still lets streaming handlers flush
+		if flusher, ok := w.(http.Flusher); assert.True(t, ok) {
+			flusher.Flush()
+		}
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/items", nil))
+	assert.True(t, rec.Flushed)
+
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, 8, size)
+	assert.Equal(t, 0, ResponseStatus(context.Background()))
+	assert.Equal(t, 0, ResponseBytes(context.Background()))
+}
+
+func TestSetOperation(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	handler := ServerLogging(zap.New(core), &Config{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users" {
+			SetOperation(r.Context(), "CreateUser")
+		}
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/users", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	responses := recorded.FilterMessage(defaultResponseMessage).All()
+	assert.Equal(t, "CreateUser", responses[0].ContextMap()["operation"])
+	assert.NotContains(t, responses[1].ContextMap(), "operation")
+
+	// Outside the middleware it's a no-op
+	assert.NotPanics(t, func() {
+		SetOperation(context.Background(), "Orphan")
+		SetOperation(nil, "Orphan")
+	})
+}
+
+func TestNamedHandler(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	mux := http.NewServeMux()
+	mux.Handle("/users", NamedHandler("listUsers", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
+
+	cfg := &Config{}
+	handler := ServerLogging(zap.New(core), cfg)(mux)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	responses := recorded.FilterMessage(defaultResponseMessage).All()
+	require.Len(t, responses, 2)
+	assert.Equal(t, "listUsers", responses[0].ContextMap()["handler"])
+	assert.NotContains(t, responses[1].ContextMap(), "handler")
+	recorded.TakeAll()
+
+	// HandlerNameFunc covers the handlers that aren't named
+	cfg.HandlerNameFunc = func(r *http.Request) string { return "fallback" }
+	handler = ServerLogging(zap.New(core), cfg)(mux)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	responses = recorded.FilterMessage(defaultResponseMessage).All()
+	require.Len(t, responses, 2)
+	assert.Equal(t, "listUsers", responses[0].ContextMap()["handler"])
+	assert.Equal(t, "fallback", responses[1].ContextMap()["handler"])
+}
+
+func TestServerLogging_EscalatesResponseLevel(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	handler := ServerLogging(zap.New(core), &Config{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(LoggerKey).(*zap.Logger).With(zap.String("step", "lookup"))
+		switch r.URL.Path {
+		case "/warn":
+			logger.Warn("cache miss")
+			logger.Warn("falling back to the database")
+		case "/error":
+			logger.Warn("retrying")
+			logger.Error("upstream failed")
+		}
+	}))
+
+	for _, path := range []string{"/ok", "/warn", "/error"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	responses := recorded.FilterMessage(defaultResponseMessage).All()
+	require.Len(t, responses, 3)
+
+	assert.Equal(t, zapcore.InfoLevel, responses[0].Level)
+	assert.NotContains(t, responses[0].ContextMap(), "warn_count")
+
+	assert.Equal(t, zapcore.WarnLevel, responses[1].Level)
+	assert.Equal(t, int64(2), responses[1].ContextMap()["warn_count"])
+	assert.NotContains(t, responses[1].ContextMap(), "error_count")
+
+	assert.Equal(t, zapcore.ErrorLevel, responses[2].Level)
+	assert.Equal(t, int64(1), responses[2].ContextMap()["warn_count"])
+	assert.Equal(t, int64(1), responses[2].ContextMap()["error_count"])
+}
+
+func TestServerLogging_CountsOnlyWrittenEntries(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	// The sampler drops repeats of a message after the first one
+	sampled := zapcore.NewSamplerWithOptions(core, time.Hour, 1, 0)
+	handler := ServerLogging(zap.New(sampled), &Config{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := r.Context().Value(LoggerKey).(*zap.Logger)
+		for i := 0; i < 3; i++ {
+			logger.Warn("cache miss")
+		}
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/warn", nil))
+
+	require.Len(t, recorded.FilterMessage("cache miss").All(), 1)
+	responses := recorded.FilterMessage(defaultResponseMessage).All()
+	require.Len(t, responses, 1)
+	assert.Equal(t, int64(1), responses[0].ContextMap()["warn_count"], "the sampled out warnings aren't counted")
+}
+
+func TestDetachedLogger(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+
+	var detached *zap.Logger
+	var reqCtx context.Context
+	handler := ServerLogging(zap.New(core), &Config{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enriched := r.Context().Value(LoggerKey).(*zap.Logger).With(zap.String("job", "thumbnail"))
+		reqCtx = context.WithValue(r.Context(), LoggerKey, enriched)
+		detached = DetachedLogger(reqCtx)
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodPost, "/upload", nil).WithContext(ctx)
+	req.Header.Set(HeaderLogID, "detached-id")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	cancel()
+	require.Error(t, reqCtx.Err(), "the request context should be canceled")
+	recorded.TakeAll()
+
+	// After the handler returned and its context was canceled
+	detached.Warn("thumbnail failed")
+
+	logs := recorded.All()
+	require.Len(t, logs, 1)
+	assert.Equal(t, "detached-id", logs[0].ContextMap()["log_id"])
+	assert.Equal(t, "thumbnail", logs[0].ContextMap()["job"])
+
+	// Without a request logger it falls back to the global logger
+	assert.Equal(t, zap.L(), DetachedLogger(context.Background()))
+}
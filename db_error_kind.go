@@ -0,0 +1,91 @@
+package smartlog
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of database errors, logged as the db_error_kind field.
+const (
+	dbErrorKindUniqueViolation     = "unique_violation"
+	dbErrorKindForeignKeyViolation = "foreign_key_violation"
+	dbErrorKindDeadlock            = "deadlock"
+	dbErrorKindConnection          = "connection"
+	dbErrorKindTimeout             = "timeout"
+	dbErrorKindOther               = "other"
+)
+
+// sqlStateError is implemented by drivers reporting SQLSTATE codes, such as pgx's *pgconn.PgError.
+type sqlStateError interface {
+	SQLState() string
+}
+
+// classifyDBError classifies an error returned by GORM. Drivers are recognized through
+// GORM's translated errors, SQLSTATE codes, and their error messages (MySQL error numbers,
+// SQLite messages), so no driver package has to be imported.
+func classifyDBError(err error) string {
+	var (
+		stateErr sqlStateError
+		netErr   net.Error
+	)
+
+	switch {
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return dbErrorKindUniqueViolation
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		return dbErrorKindForeignKeyViolation
+	case errors.Is(err, context.DeadlineExceeded):
+		return dbErrorKindTimeout
+	case errors.Is(err, driver.ErrBadConn):
+		return dbErrorKindConnection
+	case errors.As(err, &stateErr):
+		if kind := classifySQLState(stateErr.SQLState()); kind != "" {
+			return kind
+		}
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return dbErrorKindTimeout
+		}
+		return dbErrorKindConnection
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	// SQLite, MySQL 1062
+	case strings.Contains(msg, "unique constraint failed"), strings.Contains(msg, "error 1062"),
+		strings.Contains(msg, "duplicate key"), strings.Contains(msg, "duplicate entry"):
+		return dbErrorKindUniqueViolation
+	// SQLite, MySQL 1451/1452
+	case strings.Contains(msg, "foreign key constraint"), strings.Contains(msg, "error 1451"), strings.Contains(msg, "error 1452"):
+		return dbErrorKindForeignKeyViolation
+	// MySQL 1213, Postgres
+	case strings.Contains(msg, "deadlock"), strings.Contains(msg, "error 1213"):
+		return dbErrorKindDeadlock
+	case strings.Contains(msg, "connection refused"), strings.Contains(msg, "bad connection"),
+		strings.Contains(msg, "unable to open database file"):
+		return dbErrorKindConnection
+	}
+	return dbErrorKindOther
+}
+
+// classifySQLState maps an SQLSTATE code to an error kind, or returns "" if it isn't one we classify.
+func classifySQLState(state string) string {
+	switch {
+	case state == "23505":
+		return dbErrorKindUniqueViolation
+	case state == "23503":
+		return dbErrorKindForeignKeyViolation
+	case state == "40P01":
+		return dbErrorKindDeadlock
+	case state == "57014":
+		return dbErrorKindTimeout
+	case strings.HasPrefix(state, "08"):
+		return dbErrorKindConnection
+	}
+	return ""
+}
@@ -0,0 +1,91 @@
+package smartlog
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// defaultLogger is the logger installed with SetDefault, or nil.
+	defaultLogger atomic.Pointer[skippedLogger]
+	// globalLogger caches the skipped copy of the global zap logger, which can be replaced
+	// at any time with zap.ReplaceGlobals.
+	globalLogger atomic.Pointer[skippedLogger]
+)
+
+// skippedLogger pairs a logger with its copy reporting the caller one frame up, so the
+// package-level functions don't derive a new logger on every call.
+type skippedLogger struct {
+	logger  *zap.Logger
+	skipped *zap.Logger
+}
+
+func newSkippedLogger(logger *zap.Logger) *skippedLogger {
+	return &skippedLogger{logger: logger, skipped: logger.WithOptions(zap.AddCallerSkip(1))}
+}
+
+// SetDefault installs logger as the default used by the package-level logging functions
+// when the context carries no request logger, like slog.SetDefault. Passing nil restores
+// the fallback to the global zap logger.
+func SetDefault(logger *zap.Logger) {
+	if logger == nil {
+		defaultLogger.Store(nil)
+		return
+	}
+	defaultLogger.Store(newSkippedLogger(logger))
+}
+
+// Default returns the logger installed with SetDefault, or the global zap logger if none is.
+func Default() *zap.Logger {
+	if def := defaultLogger.Load(); def != nil {
+		return def.logger
+	}
+	return zap.L()
+}
+
+// Debug logs msg at debug level with the request logger from ctx, or the default logger.
+func Debug(ctx context.Context, msg string, fields ...zap.Field) {
+	loggerFor(ctx).Debug(msg, fields...)
+}
+
+// Info logs msg at info level with the request logger from ctx, or the default logger.
+func Info(ctx context.Context, msg string, fields ...zap.Field) {
+	loggerFor(ctx).Info(msg, fields...)
+}
+
+// Warn logs msg at warn level with the request logger from ctx, or the default logger.
+func Warn(ctx context.Context, msg string, fields ...zap.Field) {
+	loggerFor(ctx).Warn(msg, fields...)
+}
+
+// Error logs msg at error level with the request logger from ctx, or the default logger.
+func Error(ctx context.Context, msg string, fields ...zap.Field) {
+	loggerFor(ctx).Error(msg, fields...)
+}
+
+// loggerFor returns the logger the package-level functions log with, reporting their
+// caller rather than themselves. The skipped copies of the middleware's request logger and
+// of the default logger are cached; only a logger the handler stored in the context itself
+// is copied on every call.
+func loggerFor(ctx context.Context) *zap.Logger {
+	if ctx != nil {
+		if ctxLogger, ok := ctx.Value(LoggerKey).(*zap.Logger); ok && ctxLogger != nil {
+			if cached, ok := ctx.Value(skippedLoggerKey).(*skippedLogger); ok && cached.logger == ctxLogger {
+				return cached.skipped
+			}
+			return ctxLogger.WithOptions(zap.AddCallerSkip(1))
+		}
+	}
+	if def := defaultLogger.Load(); def != nil {
+		return def.skipped
+	}
+	global := zap.L()
+	cached := globalLogger.Load()
+	if cached == nil || cached.logger != global {
+		cached = newSkippedLogger(global)
+		globalLogger.Store(cached)
+	}
+	return cached.skipped
+}
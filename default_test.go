@@ -0,0 +1,61 @@
+package smartlog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestPackageLevelLogging(t *testing.T) {
+	core, recorded := observer.New(zapcore.DebugLevel)
+	SetDefault(zap.New(core, zap.AddCaller()).With(zap.String("logger", "default")))
+	t.Cleanup(func() { SetDefault(nil) })
+
+	t.Run("Falls back to the default logger", func(t *testing.T) {
+		Debug(context.Background(), "debug")
+		Info(context.Background(), "info", zap.Int("n", 1))
+		Warn(nil, "warn")
+		Error(context.Background(), "error")
+
+		logs := recorded.TakeAll()
+		require.Len(t, logs, 4)
+		for i, level := range []zapcore.Level{zapcore.DebugLevel, zapcore.InfoLevel, zapcore.WarnLevel, zapcore.ErrorLevel} {
+			assert.Equal(t, level, logs[i].Level)
+			assert.Equal(t, "default", logs[i].ContextMap()["logger"])
+		}
+		assert.Equal(t, int64(1), logs[1].ContextMap()["n"])
+		assert.Equal(t, "default_test.go", filepath.Base(logs[1].Caller.File), "The caller is the code calling Info")
+		assert.Same(t, loggerFor(context.Background()), loggerFor(nil), "The skipped default logger is cached")
+	})
+
+	t.Run("Uses the request logger from the context", func(t *testing.T) {
+		reqCore, reqRecorded := observer.New(zapcore.InfoLevel)
+		handler := ServerLogging(zap.New(reqCore), &Config{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Warn(r.Context(), "cache miss")
+			assert.Same(t, loggerFor(r.Context()), loggerFor(r.Context()), "The skipped request logger is cached")
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set(HeaderLogID, "pkg-log-id")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Zero(t, recorded.Len(), "The default logger isn't used")
+		warnings := reqRecorded.FilterMessage("cache miss").All()
+		require.Len(t, warnings, 1)
+		assert.Equal(t, "pkg-log-id", warnings[0].ContextMap()["log_id"])
+		response := reqRecorded.FilterMessage(defaultResponseMessage).All()
+		require.Len(t, response, 1)
+		assert.Equal(t, int64(1), response[0].ContextMap()["warn_count"])
+	})
+
+	SetDefault(nil)
+	assert.Equal(t, zap.L(), Default())
+	assert.Same(t, loggerFor(nil), loggerFor(context.Background()), "The skipped global logger is cached")
+}
@@ -0,0 +1,100 @@
+package smartlog
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// downstreamTracker counts the consecutive failures of client calls per host. Once a host
+// reaches the threshold it is degraded: the failure logs of its calls are suppressed until
+// the next success recovers it. A nil tracker tracks nothing.
+type downstreamTracker struct {
+	threshold int
+	clock     clock
+
+	mu    sync.Mutex
+	hosts map[string]*downstreamHealth
+}
+
+type downstreamHealth struct {
+	failures      int       // consecutive failures
+	degradedSince time.Time // zero while the host isn't degraded
+	suppressed    int       // failure logs suppressed while degraded
+}
+
+// newDownstreamTracker returns a tracker for the failure threshold, or nil if it is not positive.
+func newDownstreamTracker(threshold int, clock clock) *downstreamTracker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &downstreamTracker{threshold: threshold, clock: clock, hosts: make(map[string]*downstreamHealth)}
+}
+
+// failure records a failed call to host. degraded is set for the failure that reaches the
+// threshold, and suppress for the failures after it, whose logs are left out.
+func (t *downstreamTracker) failure(host string) (degraded bool, failures int, suppress bool) {
+	if t == nil {
+		return false, 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	health, ok := t.hosts[host]
+	if !ok {
+		health = &downstreamHealth{}
+		t.hosts[host] = health
+	}
+	health.failures++
+	if !health.degradedSince.IsZero() {
+		health.suppressed++
+		return false, health.failures, true
+	}
+	if health.failures >= t.threshold {
+		health.degradedSince = t.clock.Now()
+		return true, health.failures, false
+	}
+	return false, health.failures, false
+}
+
+// success records a successful call to host. recovered is set when host was degraded, along
+// with the failures it had, the failure logs suppressed and how long it was degraded.
+func (t *downstreamTracker) success(host string) (recovered bool, failures, suppressed int, degradedFor time.Duration) {
+	if t == nil {
+		return false, 0, 0, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	health, ok := t.hosts[host]
+	if !ok {
+		return false, 0, 0, 0
+	}
+	// Healthy hosts aren't tracked, so only failing ones take up memory
+	delete(t.hosts, host)
+	if health.degradedSince.IsZero() {
+		return false, 0, 0, 0
+	}
+	return true, health.failures, health.suppressed, t.clock.Since(health.degradedSince)
+}
+
+// logDownstreamDegraded warns that host reached the failure threshold.
+func logDownstreamDegraded(logger *zap.Logger, host string, failures int) {
+	logger.Warn("Downstream degraded",
+		zap.Bool("downstream_degraded", true),
+		zap.String("host", host),
+		zap.Int("consecutive_failures", failures),
+	)
+}
+
+// logDownstreamRecovered reports the first success of a degraded host.
+func logDownstreamRecovered(logger *zap.Logger, host string, failures, suppressed int, degradedFor time.Duration) {
+	logger.Info("Downstream recovered",
+		zap.Bool("downstream_recovered", true),
+		zap.String("host", host),
+		zap.Int("consecutive_failures", failures),
+		zap.Int("suppressed_count", suppressed),
+		zap.Int64("degraded_ms", degradedFor.Milliseconds()),
+	)
+}
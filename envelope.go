@@ -0,0 +1,66 @@
+package smartlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// errorEnvelopeFields extracts values from a JSON error response body and returns them as
+// top-level log fields. The mapping is from a dot-separated body path (e.g. "error.message")
+// to the log field name. Paths that don't resolve to a value are skipped.
+func errorEnvelopeFields(status int, body []byte, mapping map[string]string) []zap.Field {
+	if len(mapping) == 0 || (status >= 200 && status <= 299) || len(body) == 0 {
+		return nil
+	}
+
+	var data interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&data); err != nil {
+		return nil
+	}
+
+	// Sort the paths so fields are emitted in a stable order
+	paths := make([]string, 0, len(mapping))
+	for path := range mapping {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var fields []zap.Field
+	for _, path := range paths {
+		value, ok := lookupJSONPath(data, path)
+		if !ok {
+			continue
+		}
+		// Log numbers as numbers rather than through json.Number's Stringer
+		if number, isNumber := value.(json.Number); isNumber {
+			if i, err := number.Int64(); err == nil {
+				value = i
+			} else if f, err := number.Float64(); err == nil {
+				value = f
+			}
+		}
+		fields = append(fields, zap.Any(mapping[path], value))
+	}
+	return fields
+}
+
+// lookupJSONPath walks a decoded JSON value along a dot-separated path of object keys.
+func lookupJSONPath(data interface{}, path string) (interface{}, bool) {
+	current := data
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
@@ -0,0 +1,50 @@
+package smartlog
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// Kinds of client transport errors, logged as the error_kind field.
+const (
+	errorKindDNS               = "dns"
+	errorKindConnectionRefused = "connection_refused"
+	errorKindTimeout           = "timeout"
+	errorKindTLS               = "tls"
+	errorKindCanceled          = "canceled"
+	errorKindOther             = "other"
+)
+
+// classifyTransportError classifies an error returned by an http.RoundTripper.
+func classifyTransportError(err error) string {
+	var (
+		dnsErr       *net.DNSError
+		netErr       net.Error
+		recordErr    tls.RecordHeaderError
+		certErr      *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+		alertErr     tls.AlertError
+	)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return errorKindCanceled
+	case errors.As(err, &dnsErr):
+		return errorKindDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return errorKindConnectionRefused
+	case errors.As(err, &recordErr), errors.As(err, &certErr), errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidErr), errors.As(err, &alertErr):
+		return errorKindTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return errorKindTimeout
+	default:
+		return errorKindOther
+	}
+}
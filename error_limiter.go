@@ -0,0 +1,91 @@
+package smartlog
+
+import (
+	"sync"
+	"time"
+)
+
+// maxErrorLimiterEntries bounds the number of tracked keys. Idle ones are pruned first, then
+// the least recently logged one is evicted.
+const maxErrorLimiterEntries = 1024
+
+// errorLogLimiter rate limits repeated error logs per key to one per interval, counting the
+// suppressed ones so the next emitted line can report them. A nil limiter allows everything.
+type errorLogLimiter struct {
+	interval time.Duration
+	clock    clock
+
+	mu      sync.Mutex
+	entries map[string]*errorLogEntry
+}
+
+type errorLogEntry struct {
+	last       time.Time
+	suppressed int
+}
+
+// newErrorLogLimiter returns a limiter for the interval in milliseconds, or nil if it is not positive.
+func newErrorLogLimiter(intervalMs int, clock clock) *errorLogLimiter {
+	if intervalMs <= 0 {
+		return nil
+	}
+	return &errorLogLimiter{
+		interval: time.Duration(intervalMs) * time.Millisecond,
+		clock:    clock,
+		entries:  make(map[string]*errorLogEntry),
+	}
+}
+
+// allow reports whether an error log for key may be emitted now. When it may, suppressed is
+// the number of logs for key dropped since the last emitted one.
+func (l *errorLogLimiter) allow(key string) (ok bool, suppressed int) {
+	if l == nil {
+		return true, 0
+	}
+	now := l.clock.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, exists := l.entries[key]
+	if !exists {
+		if len(l.entries) >= maxErrorLimiterEntries {
+			l.prune(now)
+		}
+		if len(l.entries) >= maxErrorLimiterEntries {
+			l.evictOldest()
+		}
+		l.entries[key] = &errorLogEntry{last: now}
+		return true, 0
+	}
+	if now.Sub(entry.last) < l.interval {
+		entry.suppressed++
+		return false, 0
+	}
+	suppressed = entry.suppressed
+	entry.last = now
+	entry.suppressed = 0
+	return true, suppressed
+}
+
+// prune drops idle entries that have no pending suppressed count. Callers must hold l.mu.
+func (l *errorLogLimiter) prune(now time.Time) {
+	for key, entry := range l.entries {
+		if entry.suppressed == 0 && now.Sub(entry.last) >= l.interval {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// evictOldest drops the entry logged least recently, with its pending suppressed count, when
+// every entry is still active. Callers must hold l.mu.
+func (l *errorLogLimiter) evictOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range l.entries {
+		if oldestKey == "" || entry.last.Before(oldest) {
+			oldestKey, oldest = key, entry.last
+		}
+	}
+	delete(l.entries, oldestKey)
+}
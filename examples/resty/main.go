@@ -46,20 +46,15 @@ func main() {
 	}()
 	time.Sleep(100 * time.Millisecond)
 
-	// --- 4. Create an HTTP Client with smartlog Transport ---
-	httpClient := &http.Client{
-		Transport: smartlog.NewClientLogger(http.DefaultTransport, logger, &cfg),
-	}
-
-	// --- 5. Create a Resty Client with the smartlog-enabled HTTP Client ---
+	// --- 4. Create a Resty Client with the smartlog Transport ---
 	client := resty.New().
-		SetTransport(httpClient.Transport).
+		SetTransport(smartlog.WrapTransport(nil, logger, &cfg)).
 		SetTimeout(10 * time.Second)
 
 	fmt.Println("Sending request with Resty client...")
 	fmt.Println("Check the console output and 'resty_app.log' for logs.")
 
-	// --- 6. Make a Request ---
+	// --- 5. Make a Request ---
 	// The X-Request-ID will be injected automatically if the context contains it.
 	// For a standalone client, you can create a log ID manually.
 	resp, err := client.R().
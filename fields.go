@@ -0,0 +1,466 @@
+package smartlog
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// optionalString returns a string field, or a no-op field if the value is empty.
+func optionalString(key, value string) zap.Field {
+	if value == "" {
+		return zap.Skip()
+	}
+	return zap.String(key, value)
+}
+
+// logKeys are the keys of the request and response log fields, which depend on Config.FlattenFields.
+type logKeys struct {
+	method, path, url, status string
+	flat                      bool
+}
+
+func newLogKeys(flat bool) logKeys {
+	if flat {
+		return logKeys{method: "request_method", path: "request_path", url: "request_url", status: "response_status", flat: true}
+	}
+	return logKeys{method: "method", path: "path", url: "url", status: "status"}
+}
+
+// request returns the field for the request object: nested under "request", or inlined as
+// request_* keys when flat. It is left out when there's nothing to log.
+func (k logKeys) request(l httpRequestLog) zap.Field {
+	if l.skipHeaders && l.skipBody && l.accept == "" && l.acceptLanguage == "" {
+		return zap.Skip()
+	}
+	if k.flat {
+		return zap.Inline(flatHTTPRequestLog(l))
+	}
+	return zap.Object("request", l)
+}
+
+// query returns the field for the parsed query parameters: nested under "query", or inlined
+// as query_* keys when flat. Without parameters the field is skipped.
+func (k logKeys) query(q queryParams) zap.Field {
+	switch {
+	case len(q) == 0:
+		return zap.Skip()
+	case k.flat:
+		return zap.Inline(flatQueryParams(q))
+	}
+	return zap.Object("query", q)
+}
+
+// trailers returns the field for the response trailers: nested under "response_trailers",
+// or inlined as response_trailer_* keys when flat.
+func (k logKeys) trailers(trailers http.Header) zap.Field {
+	if k.flat {
+		return zap.Inline(flatHeaders{prefix: "response_trailer_", headers: trailers})
+	}
+	return zap.Any("response_trailers", trailers)
+}
+
+// baggage returns the baggage as a log field, or zap.Skip() if there is none: nested under
+// "baggage", or inlined as baggage_* keys when flat. The keys to redact and drop are matched
+// like headers.
+func (k logKeys) baggage(baggage Baggage, keysToRedact, keysToDrop []string, sanitize bool) zap.Field {
+	if len(baggage) == 0 {
+		return zap.Skip()
+	}
+	baggage = redactBaggage(baggage, keysToRedact, keysToDrop)
+	if sanitize {
+		baggage = sanitizeBaggage(baggage)
+	}
+	if k.flat {
+		return zap.Inline(flatBaggage(baggage))
+	}
+	return zap.Object("baggage", baggage)
+}
+
+// flatBaggage encodes baggage as baggage_<key> keys.
+type flatBaggage Baggage
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (b flatBaggage) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, key := range Baggage(b).keys() {
+		enc.AddString("baggage_"+flatKey(key), b[key])
+	}
+	return nil
+}
+
+// response returns the field for the response object: nested under "response", or inlined as
+// response_* keys when flat. It is left out when there's nothing to log.
+func (k logKeys) response(l httpResponseLog) zap.Field {
+	if l.skipBody && l.contentType == "" {
+		return zap.Skip()
+	}
+	if k.flat {
+		return zap.Inline(flatHTTPResponseLog(l))
+	}
+	return zap.Object("response", l)
+}
+
+// httpRequestLog is the "request" object of a request log entry. It implements
+// zapcore.ObjectMarshaler so the fields are encoded lazily, without building an
+// intermediate map for every request.
+type httpRequestLog struct {
+	// accept is the Accept header, logged on its own to show the negotiated representation.
+	accept string
+	// acceptLanguage is the Accept-Language header, logged on its own to debug localization.
+	acceptLanguage string
+	headers        http.Header
+	body           json.RawMessage
+	// bodyRaw is a body that isn't valid JSON, logged as a string in place of body.
+	bodyRaw string
+	// bodySHA256 is the hash of the raw body, logged in place of body when hashing instead of logging.
+	bodySHA256 string
+	// bodyOmitted, if set, is the reason the body isn't logged and replaces it.
+	bodyOmitted string
+	// bodyTruncated marks a JSON body cut at Config.MaxBodyLogBytes, which is still valid JSON.
+	bodyTruncated bool
+	// redactionSkipped, if set, is the reason the body couldn't be redacted, e.g. "parse_error".
+	redactionSkipped string
+	// redactionApplied marks a body the redactor ran over.
+	redactionApplied bool
+	// skipHeaders and skipBody leave the headers and body out entirely, as Config.LogFields
+	// doesn't select them.
+	skipHeaders, skipBody bool
+}
+
+// MarshalLogObject encodes the request in the same shape as the equivalent map:
+// keys in alphabetical order and a null body when there is none.
+func (l httpRequestLog) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if l.accept != "" {
+		enc.AddString("accept", l.accept)
+	}
+	if l.acceptLanguage != "" {
+		enc.AddString("accept_language", l.acceptLanguage)
+	}
+	switch {
+	case l.skipBody:
+	case l.bodyOmitted != "":
+		enc.AddString("body_omitted", l.bodyOmitted)
+	case l.bodySHA256 != "":
+		enc.AddString("body_sha256", l.bodySHA256)
+	case l.bodyRaw != "":
+		enc.AddString("body_raw", l.bodyRaw)
+	default:
+		if err := enc.AddReflected("body", l.body); err != nil {
+			return err
+		}
+		if l.bodyTruncated {
+			enc.AddBool("body_truncated", true)
+		}
+	}
+	if !l.skipBody {
+		addRedactionStatus(enc, "", l.body != nil && l.redactionApplied, l.redactionSkipped)
+	}
+	if l.skipHeaders {
+		return nil
+	}
+	return enc.AddReflected("headers", l.headers)
+}
+
+// flatHTTPRequestLog encodes a request as flat keys: the body as a request_body string and
+// each header as request_header_<name>, e.g. request_header_content_type.
+type flatHTTPRequestLog httpRequestLog
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (l flatHTTPRequestLog) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if l.accept != "" {
+		enc.AddString("request_accept", l.accept)
+	}
+	if l.acceptLanguage != "" {
+		enc.AddString("request_accept_language", l.acceptLanguage)
+	}
+	switch {
+	case l.skipBody:
+	case l.bodyOmitted != "":
+		enc.AddString("request_body_omitted", l.bodyOmitted)
+	case l.bodySHA256 != "":
+		enc.AddString("request_body_sha256", l.bodySHA256)
+	case l.bodyRaw != "":
+		enc.AddString("request_body_raw", l.bodyRaw)
+	case l.body != nil:
+		enc.AddString("request_body", string(l.body))
+		if l.bodyTruncated {
+			enc.AddBool("request_body_truncated", true)
+		}
+	}
+	if !l.skipBody {
+		addRedactionStatus(enc, "request_", l.body != nil && l.redactionApplied, l.redactionSkipped)
+	}
+	if l.skipHeaders {
+		return nil
+	}
+	return flatHeaders{prefix: "request_header_", headers: l.headers}.MarshalLogObject(enc)
+}
+
+// flatHeaders encodes headers as <prefix><name> keys, e.g. request_header_content_type,
+// joining multiple values.
+type flatHeaders struct {
+	prefix  string
+	headers http.Header
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (h flatHeaders) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	names := make([]string, 0, len(h.headers))
+	for name := range h.headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		enc.AddString(h.prefix+flatKey(name), strings.Join(h.headers[name], ", "))
+	}
+	return nil
+}
+
+// flatKey turns a header or baggage name into a key suffix: lowercase, with dashes as
+// underscores.
+func flatKey(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "-", "_")
+}
+
+// httpResponseLog is the "response" object of a response log entry.
+type httpResponseLog struct {
+	body json.RawMessage
+	// bodyRaw is a body that isn't valid JSON, logged as a string in place of body.
+	bodyRaw string
+	// bodySHA256 is the hash of the raw body, logged in place of body when hashing instead of logging.
+	bodySHA256 string
+	// bodyOmitted, if set, is the reason the body isn't logged and replaces it.
+	bodyOmitted string
+	// bodyTruncated marks a JSON body cut at Config.MaxBodyLogBytes, which is still valid JSON.
+	bodyTruncated bool
+	// redactionSkipped, if set, is the reason the body couldn't be redacted, e.g. "parse_error".
+	redactionSkipped string
+	// redactionApplied marks a body the redactor ran over.
+	redactionApplied bool
+	// contentType is the Content-Type header, logged to show the negotiated representation.
+	contentType string
+	// skipBody leaves the body out entirely, as Config.LogFields doesn't select it.
+	skipBody bool
+}
+
+// MarshalLogObject encodes the response body, which is null when there is none, and the
+// content type if known.
+func (l httpResponseLog) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	switch {
+	case l.skipBody:
+	case l.bodyOmitted != "":
+		enc.AddString("body_omitted", l.bodyOmitted)
+	case l.bodySHA256 != "":
+		enc.AddString("body_sha256", l.bodySHA256)
+	case l.bodyRaw != "":
+		enc.AddString("body_raw", l.bodyRaw)
+	default:
+		if err := enc.AddReflected("body", l.body); err != nil {
+			return err
+		}
+		if l.bodyTruncated {
+			enc.AddBool("body_truncated", true)
+		}
+	}
+	if !l.skipBody {
+		addRedactionStatus(enc, "", l.body != nil && l.redactionApplied, l.redactionSkipped)
+	}
+	if l.contentType != "" {
+		enc.AddString("content_type", l.contentType)
+	}
+	return nil
+}
+
+// flatHTTPResponseLog encodes a response as flat keys, with the body as a response_body string.
+type flatHTTPResponseLog httpResponseLog
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (l flatHTTPResponseLog) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	switch {
+	case l.skipBody:
+	case l.bodyOmitted != "":
+		enc.AddString("response_body_omitted", l.bodyOmitted)
+	case l.bodySHA256 != "":
+		enc.AddString("response_body_sha256", l.bodySHA256)
+	case l.bodyRaw != "":
+		enc.AddString("response_body_raw", l.bodyRaw)
+	case l.body != nil:
+		enc.AddString("response_body", string(l.body))
+		if l.bodyTruncated {
+			enc.AddBool("response_body_truncated", true)
+		}
+	}
+	if !l.skipBody {
+		addRedactionStatus(enc, "response_", l.body != nil && l.redactionApplied, l.redactionSkipped)
+	}
+	if l.contentType != "" {
+		enc.AddString("response_content_type", l.contentType)
+	}
+	return nil
+}
+
+// addRedactionStatus reports under keys starting with prefix whether a body was redacted:
+// redaction_applied is true for a JSON body logged once the redactor ran over it, and false along with
+// redaction_skipped for a body that couldn't be redacted for skippedReason, so operators
+// know it may hold secrets or why it was left out.
+func addRedactionStatus(enc zapcore.ObjectEncoder, prefix string, redacted bool, skippedReason string) {
+	switch {
+	case skippedReason != "":
+		enc.AddBool(prefix+"redaction_applied", false)
+		enc.AddString(prefix+"redaction_skipped", skippedReason)
+	case redacted:
+		enc.AddBool(prefix+"redaction_applied", true)
+	}
+}
+
+const (
+	// truncatedSuffix marks a raw body cut at Config.MaxBodyLogBytes.
+	truncatedSuffix = "...[truncated]"
+	// truncatedHeaderSuffix marks a header value cut at Config.MaxHeaderValueLogBytes.
+	truncatedHeaderSuffix = "...(truncated)"
+)
+
+// loggedBody prepares a redacted body for logging. Valid JSON is embedded as is; anything
+// else is returned as raw, a string truncated to maxRawBytes (0 means no limit), because
+// embedding it would make the log line invalid JSON. truncated reports whether raw was cut.
+func loggedBody(body []byte, maxRawBytes int) (embedded json.RawMessage, raw string, truncated bool) {
+	if len(body) == 0 {
+		return nil, "", false
+	}
+	if json.Valid(body) {
+		return json.RawMessage(body), "", false
+	}
+	if maxRawBytes > 0 && len(body) > maxRawBytes {
+		// Don't split a multi-byte character
+		return nil, string(body[:utf8Cut(body, maxRawBytes)]) + truncatedSuffix, true
+	}
+	return nil, string(body), false
+}
+
+// utf8Cut returns the largest length up to n that doesn't split a multi-byte character of s,
+// which is longer than n.
+func utf8Cut[T string | []byte](s T, n int) int {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return n
+}
+
+// truncateHeaderValues returns the headers with the values longer than maxBytes (0 means no
+// limit) cut and marked with truncatedHeaderSuffix. The headers are returned as is when no value
+// is too long, and copied otherwise, so the request keeps its real values.
+func truncateHeaderValues(headers http.Header, maxBytes int) http.Header {
+	if maxBytes <= 0 {
+		return headers
+	}
+	tooLong := false
+	for _, values := range headers {
+		for _, value := range values {
+			if len(value) > maxBytes {
+				tooLong = true
+			}
+		}
+	}
+	if !tooLong {
+		return headers
+	}
+
+	truncated := make(http.Header, len(headers))
+	for key, values := range headers {
+		cutValues := make([]string, len(values))
+		for i, value := range values {
+			if len(value) > maxBytes {
+				value = value[:utf8Cut(value, maxBytes)] + truncatedHeaderSuffix
+			}
+			cutValues[i] = value
+		}
+		truncated[key] = cutValues
+	}
+	return truncated
+}
+
+// omittedBodySizeField returns the size of a body left out of its log entry as key, e.g.
+// req_body_bytes, for Config.LogOmittedBodySize. Bodies that are logged, or whose size isn't
+// known (negative), give zap.Skip().
+func omittedBodySizeField(key string, enabled, omitted bool, size int64) zap.Field {
+	if !enabled || !omitted || size < 0 {
+		return zap.Skip()
+	}
+	return zap.Int64(key, size)
+}
+
+// requestBodySize returns the size of a request body: the length of read when it was read,
+// else the declared Content-Length, which is -1 when unknown.
+func requestBodySize(r *http.Request, read []byte) int64 {
+	if read != nil {
+		return int64(len(read))
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return 0
+	}
+	return r.ContentLength
+}
+
+// tlsFields describes the negotiated TLS parameters of a connection, or returns nil for plain HTTP.
+func tlsFields(state *tls.ConnectionState) []zap.Field {
+	if state == nil {
+		return nil
+	}
+	return []zap.Field{
+		zap.String("tls_version", tls.VersionName(state.Version)),
+		zap.String("tls_cipher", tls.CipherSuiteName(state.CipherSuite)),
+		zap.Bool("tls_client_cert", len(state.PeerCertificates) > 0),
+	}
+}
+
+// geoFields returns the geo hint headers present in headers as fields, keyed by the field
+// names geoHeaders maps the header names to, e.g. CF-IPCountry -> geo_country.
+func geoFields(headers http.Header, geoHeaders map[string]string, sanitize bool) []zap.Field {
+	if len(geoHeaders) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(geoHeaders))
+	for name := range geoHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var fields []zap.Field
+	for _, name := range names {
+		value := headers.Get(name)
+		if value == "" {
+			continue
+		}
+		if sanitize {
+			value = sanitizeString(value)
+		}
+		fields = append(fields, zap.String(geoHeaders[name], value))
+	}
+	return fields
+}
+
+// bodyHashField returns the hex SHA-256 of a raw body under key, or zap.Skip() if hashing
+// is disabled or the body is empty.
+func bodyHashField(key string, body []byte, enabled bool) zap.Field {
+	if !enabled || len(body) == 0 {
+		return zap.Skip()
+	}
+	return zap.String(key, bodySHA256(body))
+}
+
+// bodySHA256 returns the hex encoded SHA-256 of body, or an empty string if it is empty.
+func bodySHA256(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
@@ -14,10 +14,11 @@ import (
 type GormLogger struct {
 	ZapLogger *zap.Logger
 	LogLevel  logger.LogLevel
+	clock     clock
 }
 
 // NewGormLogger creates a new GormLogger.
-func NewGormLogger(zapLogger *zap.Logger, cfg GormConfig) *GormLogger {
+func NewGormLogger(zapLogger *zap.Logger, cfg GormConfig, opts ...Option) *GormLogger {
 	logLevel := logger.Info
 	switch cfg.Level {
 	case "silent":
@@ -31,6 +32,7 @@ func NewGormLogger(zapLogger *zap.Logger, cfg GormConfig) *GormLogger {
 	return &GormLogger{
 		ZapLogger: zapLogger,
 		LogLevel:  logLevel,
+		clock:     newOptions(opts).clock,
 	}
 }
 
@@ -41,45 +43,61 @@ func (l *GormLogger) LogMode(level logger.LogLevel) logger.Interface {
 	return &newLogger
 }
 
+// level returns the log level for ctx: Info for requests flagged for debug logging,
+// so that all their queries are logged, else LogLevel.
+func (l *GormLogger) level(ctx context.Context) logger.LogLevel {
+	if DebugFromContext(ctx) {
+		return logger.Info
+	}
+	return l.LogLevel
+}
+
 // Info logs informational messages.
 func (l *GormLogger) Info(ctx context.Context, msg string, data ...interface{}) {
-	if l.LogLevel >= logger.Info {
+	if l.level(ctx) >= logger.Info {
 		l.getLogger(ctx).Info(msg, zap.Any("data", data))
 	}
 }
 
 // Warn logs warning messages.
 func (l *GormLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
-	if l.LogLevel >= logger.Warn {
+	if l.level(ctx) >= logger.Warn {
 		l.getLogger(ctx).Warn(msg, zap.Any("data", data))
 	}
 }
 
 // Error logs error messages.
 func (l *GormLogger) Error(ctx context.Context, msg string, data ...interface{}) {
-	if l.LogLevel >= logger.Error {
+	if l.level(ctx) >= logger.Error {
 		l.getLogger(ctx).Error(msg, zap.Any("data", data))
 	}
 }
 
 // Trace logs SQL queries.
 func (l *GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
-	if l.LogLevel <= logger.Silent {
+	if l.level(ctx) <= logger.Silent {
 		return
 	}
 
-	elapsed := time.Since(begin)
+	// GormLogger may be built as a struct literal, without a clock
+	var elapsed time.Duration
+	if l.clock != nil {
+		elapsed = l.clock.Since(begin)
+	} else {
+		elapsed = time.Since(begin)
+	}
 	sql, rows := fc()
 	fields := []zap.Field{
 		zap.Duration("latency", elapsed),
 		zap.Int64("rows", rows),
 		zap.String("sql", sql),
+		optionalString("route", RouteFromContext(ctx)),
 	}
 
 	logger := l.getLogger(ctx)
 
 	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-		logger.Error("GORM Trace", append(fields, zap.Error(err))...)
+		logger.Error("GORM Trace", append(fields, zap.Error(err), zap.String("db_error_kind", classifyDBError(err)))...)
 	} else if elapsed > 200*time.Millisecond {
 		logger.Warn("GORM Trace (Slow Query)", fields...)
 	} else {
@@ -0,0 +1,65 @@
+package smartlog
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// fakePgError mimics pgconn.PgError, which reports its SQLSTATE code.
+type fakePgError struct{ code string }
+
+func (e *fakePgError) Error() string    { return "ERROR (SQLSTATE " + e.code + ")" }
+func (e *fakePgError) SQLState() string { return e.code }
+
+func TestClassifyDBError(t *testing.T) {
+	testCases := []struct {
+		err  error
+		kind string
+	}{
+		{gorm.ErrDuplicatedKey, "unique_violation"},
+		{errors.New("UNIQUE constraint failed: users.email"), "unique_violation"},
+		{errors.New("Error 1062 (23000): Duplicate entry 'a' for key 'email'"), "unique_violation"},
+		{fmt.Errorf("insert: %w", &fakePgError{code: "23505"}), "unique_violation"},
+		{errors.New("FOREIGN KEY constraint failed"), "foreign_key_violation"},
+		{&fakePgError{code: "23503"}, "foreign_key_violation"},
+		{errors.New("Error 1213 (40001): Deadlock found when trying to get lock"), "deadlock"},
+		{&fakePgError{code: "40P01"}, "deadlock"},
+		{driver.ErrBadConn, "connection"},
+		{&fakePgError{code: "08006"}, "connection"},
+		{errors.New("syntax error"), "other"},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.kind, classifyDBError(tc.err), tc.err.Error())
+	}
+}
+
+func TestGormLogger_DBErrorKind(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: NewGormLogger(zap.New(core), GormConfig{}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&TestUser{}))
+
+	require.NoError(t, db.Create(&TestUser{Model: gorm.Model{ID: 1}, Name: "first"}).Error)
+	require.Error(t, db.Create(&TestUser{Model: gorm.Model{ID: 1}, Name: "duplicate"}).Error)
+
+	var missing TestUser
+	require.ErrorIs(t, db.First(&missing, 42).Error, gorm.ErrRecordNotFound)
+
+	errorLogs := recorded.FilterLevelExact(zapcore.ErrorLevel).All()
+	require.Len(t, errorLogs, 1, "record not found isn't logged as an error")
+	assert.Equal(t, "unique_violation", errorLogs[0].ContextMap()["db_error_kind"])
+}
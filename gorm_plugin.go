@@ -1,8 +1,11 @@
 package smartlog
 
 import (
+	"context"
 	"encoding/json"
+	"reflect"
 	"sort"
+	"strings"
 
 	"go.uber.org/zap"
 	"gorm.io/gorm"
@@ -34,15 +37,25 @@ func (p *GormResultLogPlugin) Initialize(db *gorm.DB) error {
 }
 
 func (p *GormResultLogPlugin) logResult(db *gorm.DB) {
+	// Every log below, including the failure warnings, goes through the request's logger
 	ctx := db.Statement.Context
-	logger := p.logger
-	if ctx != nil {
-		if ctxLogger, ok := ctx.Value(LoggerKey).(*zap.Logger); ok {
-			logger = ctxLogger
+	logger := p.getLogger(ctx)
+
+	// Cap slice results to the first LogResultMaxElements rows, logging the total row count
+	result := db.Statement.Dest
+	var fields []zap.Field
+	rows := 1
+	if dest := reflect.Indirect(reflect.ValueOf(result)); dest.Kind() == reflect.Slice {
+		rows = dest.Len()
+		if p.cfg.LogResultMaxElements > 0 {
+			if rows > p.cfg.LogResultMaxElements {
+				result = dest.Slice(0, p.cfg.LogResultMaxElements).Interface()
+			}
+			fields = append(fields, zap.Int("total", rows))
 		}
 	}
 
-	resultJSON, err := json.Marshal(db.Statement.Dest)
+	resultJSON, err := json.Marshal(result)
 	if err != nil {
 		logger.Warn("Failed to marshal GORM query result", zap.Error(err))
 		return
@@ -50,45 +63,105 @@ func (p *GormResultLogPlugin) logResult(db *gorm.DB) {
 
 	// Truncate if the result is larger than the max bytes
 	if p.cfg.LogResultMaxBytes > 0 && len(resultJSON) > p.cfg.LogResultMaxBytes {
+		resultJSON = p.truncateResult(resultJSON, rows, logger)
+	}
+
+	fields = append(fields, zap.ByteString("result", resultJSON), optionalString("route", RouteFromContext(ctx)))
+	logger.Debug("GORM Query Result", fields...)
+}
+
+// getLogger retrieves the logger from the context or returns the base logger.
+func (p *GormResultLogPlugin) getLogger(ctx context.Context) *zap.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(LoggerKey).(*zap.Logger); ok {
+			return logger
+		}
+	}
+	return p.logger
+}
+
+// truncateResult shrinks a result larger than LogResultMaxBytes according to the
+// configured strategy. rows is the number of rows the query returned.
+func (p *GormResultLogPlugin) truncateResult(resultJSON []byte, rows int, logger *zap.Logger) []byte {
+	switch strings.ToLower(p.cfg.TruncateStrategy) {
+	case TruncateStrategySummary:
+		// A compact marker says more than half an object
+		summary, _ := json.Marshal(map[string]interface{}{
+			"_truncated":   true,
+			"_total_bytes": len(resultJSON),
+			"_rows":        rows,
+		})
+		return summary
+	case TruncateStrategyHead:
+		return resultJSON[:p.cfg.LogResultMaxBytes]
+	default:
+		// Keep the ID and leading fields of objects, or the leading rows of slices
 		var data interface{}
 		if err := json.Unmarshal(resultJSON, &data); err != nil {
 			logger.Warn("Failed to unmarshal GORM query result for truncation", zap.Error(err))
 			// Fallback to simple truncation if unmarshaling fails
-			resultJSON = resultJSON[:p.cfg.LogResultMaxBytes]
-		} else {
-			// Create a new map to hold the truncated result
-			truncatedMap := make(map[string]interface{})
-			keys := make([]string, 0)
-			if m, ok := data.(map[string]interface{}); ok {
-				for key := range m {
-					keys = append(keys, key)
-				}
-				sort.Strings(keys) // Sort the keys
-			}
+			return resultJSON[:p.cfg.LogResultMaxBytes]
+		}
+		switch v := data.(type) {
+		case []interface{}:
+			return truncateResultRows(v, p.cfg.LogResultMaxBytes)
+		case map[string]interface{}:
+			return truncateResultFields(v, p.cfg.LogResultMaxBytes)
+		}
+		return resultJSON[:p.cfg.LogResultMaxBytes]
+	}
+}
 
-			// Add fields one by one and check the size
-			currentSize := 2 // for '{}'
-			if id, ok := data.(map[string]interface{})["ID"]; ok {
-				truncatedMap["ID"] = id
-				fieldJSON, _ := json.Marshal(map[string]interface{}{"ID": id})
-				currentSize += len(fieldJSON) - 1
-			}
+// truncateResultFields keeps the ID and then as many fields, in key order, as fit in maxBytes.
+func truncateResultFields(data map[string]interface{}, maxBytes int) []byte {
+	// Create a new map to hold the truncated result
+	truncatedMap := make(map[string]interface{})
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // Sort the keys
 
-			for _, key := range keys {
-				if key == "ID" {
-					continue // Already added
-				}
-				value := data.(map[string]interface{})[key]
-				fieldJSON, _ := json.Marshal(map[string]interface{}{key: value})
-				if currentSize+len(fieldJSON)-1 > p.cfg.LogResultMaxBytes {
-					break
-				}
-				truncatedMap[key] = value
-				currentSize += len(fieldJSON) - 1
-			}
-			resultJSON, _ = json.Marshal(truncatedMap)
+	// Add fields one by one and check the size
+	currentSize := 2 // for '{}'
+	if id, ok := data["ID"]; ok {
+		truncatedMap["ID"] = id
+		fieldJSON, _ := json.Marshal(map[string]interface{}{"ID": id})
+		currentSize += len(fieldJSON) - 1
+	}
+
+	for _, key := range keys {
+		if key == "ID" {
+			continue // Already added
+		}
+		value := data[key]
+		fieldJSON, _ := json.Marshal(map[string]interface{}{key: value})
+		if currentSize+len(fieldJSON)-1 > maxBytes {
+			break
 		}
+		truncatedMap[key] = value
+		currentSize += len(fieldJSON) - 1
 	}
+	truncatedJSON, _ := json.Marshal(truncatedMap)
+	return truncatedJSON
+}
 
-	logger.Debug("GORM Query Result", zap.ByteString("result", resultJSON))
+// truncateResultRows keeps as many leading rows as fit in maxBytes.
+func truncateResultRows(rows []interface{}, maxBytes int) []byte {
+	currentSize := 2 // for '[]'
+	kept := 0
+	for _, row := range rows {
+		rowJSON, _ := json.Marshal(row)
+		size := len(rowJSON)
+		if kept > 0 {
+			size++ // for ','
+		}
+		if currentSize+size > maxBytes {
+			break
+		}
+		currentSize += size
+		kept++
+	}
+	truncatedJSON, _ := json.Marshal(rows[:kept])
+	return truncatedJSON
 }
@@ -3,9 +3,13 @@ package smartlog
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"go.uber.org/zap/zaptest/observer"
@@ -136,4 +140,166 @@ func TestGormResultLogPlugin(t *testing.T) {
 		assert.True(t, logFound, "Expected to find GORM Query Result log with log_id")
 		recorded.TakeAll()
 	})
+
+	t.Run("Includes the route from the context", func(t *testing.T) {
+		cfg := GormConfig{LogQueryResult: true}
+		db := setupGormWithPlugin(t, logger, cfg)
+		ctx := WithRoute(context.Background(), "GET /users/{id}")
+		recorded.TakeAll() // Drop the migration logs
+
+		var foundUser TestUser
+		db.WithContext(ctx).First(&foundUser)
+
+		traceFound := false
+		for _, log := range recorded.All() {
+			switch log.Message {
+			case "GORM Trace", "GORM Query Result":
+				traceFound = traceFound || log.Message == "GORM Trace"
+				assert.Equal(t, "GET /users/{id}", log.ContextMap()["route"], log.Message)
+			}
+		}
+		assert.True(t, traceFound, "Expected to find GORM Trace log")
+		recorded.TakeAll()
+	})
+
+	t.Run("Caps slice results to max elements", func(t *testing.T) {
+		cfg := GormConfig{LogQueryResult: true, LogResultMaxElements: 3, LogResultMaxBytes: 4096}
+		db := setupGormWithPlugin(t, logger, cfg)
+		for i := 0; i < 8; i++ {
+			db.Create(&TestUser{Name: "bulk-user"})
+		}
+		recorded.TakeAll()
+
+		var users []TestUser
+		db.Where("name = ?", "bulk-user").Find(&users)
+		require.Len(t, users, 8)
+
+		results := recorded.FilterMessage("GORM Query Result").All()
+		require.Len(t, results, 1)
+		var logged []TestUser
+		require.NoError(t, json.Unmarshal([]byte(results[0].ContextMap()["result"].(string)), &logged))
+		assert.Len(t, logged, 3)
+		assert.Equal(t, users[0].ID, logged[0].ID)
+		assert.Equal(t, int64(8), results[0].ContextMap()["total"])
+		recorded.TakeAll()
+	})
+
+	t.Run("Truncates slice results to max bytes", func(t *testing.T) {
+		cfg := GormConfig{LogQueryResult: true, LogResultMaxBytes: 300}
+		db := setupGormWithPlugin(t, logger, cfg)
+		recorded.TakeAll()
+
+		var users []TestUser
+		db.Where("name = ?", "bulk-user").Find(&users)
+
+		results := recorded.FilterMessage("GORM Query Result").All()
+		require.Len(t, results, 1)
+		resultField := results[0].ContextMap()["result"].(string)
+		assert.LessOrEqual(t, len(resultField), 300)
+		var logged []TestUser
+		require.NoError(t, json.Unmarshal([]byte(resultField), &logged), "truncated result should be valid JSON")
+		assert.NotEmpty(t, logged)
+		assert.Less(t, len(logged), len(users))
+		recorded.TakeAll()
+	})
+	t.Run("Summary strategy replaces oversized results with a marker", func(t *testing.T) {
+		cfg := GormConfig{LogQueryResult: true, LogResultMaxBytes: 300, TruncateStrategy: TruncateStrategySummary}
+		db := setupGormWithPlugin(t, logger, cfg)
+		recorded.TakeAll()
+
+		var users []TestUser
+		db.Where("name = ?", "bulk-user").Find(&users)
+		fullJSON, _ := json.Marshal(users)
+
+		results := recorded.FilterMessage("GORM Query Result").All()
+		require.Len(t, results, 1)
+		assert.JSONEq(t, fmt.Sprintf(`{"_truncated":true,"_total_bytes":%d,"_rows":%d}`, len(fullJSON), len(users)),
+			results[0].ContextMap()["result"].(string))
+		recorded.TakeAll()
+	})
+
+	t.Run("Head strategy cuts the raw result", func(t *testing.T) {
+		cfg := GormConfig{LogQueryResult: true, LogResultMaxBytes: 50, TruncateStrategy: TruncateStrategyHead}
+		db := setupGormWithPlugin(t, logger, cfg)
+		recorded.TakeAll()
+
+		var users []TestUser
+		db.Where("name = ?", "bulk-user").Find(&users)
+		fullJSON, _ := json.Marshal(users)
+
+		results := recorded.FilterMessage("GORM Query Result").All()
+		require.Len(t, results, 1)
+		assert.Equal(t, string(fullJSON[:50]), results[0].ContextMap()["result"])
+		recorded.TakeAll()
+	})
+}
+
+func TestGormResultLogPlugin_FailureWarningsCarryLogID(t *testing.T) {
+	core, recorded := observer.New(zapcore.DebugLevel)
+	plugin := NewGormResultLogPlugin(zap.New(core), GormConfig{LogQueryResult: true})
+
+	// A destination that can't be marshaled to JSON
+	ctx := context.WithValue(context.Background(), LoggerKey, zap.New(core).With(zap.String("log_id", "plugin-log-id")))
+	plugin.logResult(&gorm.DB{Statement: &gorm.Statement{Context: ctx, Dest: make(chan int)}})
+
+	warnings := recorded.FilterMessage("Failed to marshal GORM query result").All()
+	require.Len(t, warnings, 1)
+	assert.Equal(t, zapcore.WarnLevel, warnings[0].Level)
+	assert.Equal(t, "plugin-log-id", warnings[0].ContextMap()["log_id"])
+}
+
+func TestServerLogging_DebugHeaderMakesGormVerbose(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	db := setupGormWithPlugin(t, logger, GormConfig{Level: "silent", LogQueryResult: true})
+	db.Create(&TestUser{Name: "debug-user"})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var user TestUser
+		db.WithContext(r.Context()).Where("name = ?", "debug-user").First(&user)
+	})
+	serve := func(cfg *Config, flagged bool) []observer.LoggedEntry {
+		recorded.TakeAll()
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		if flagged {
+			req.Header.Set(HeaderDebugLog, "true")
+		}
+		ServerLogging(logger, cfg)(handler).ServeHTTP(httptest.NewRecorder(), req)
+		return recorded.TakeAll()
+	}
+	gormLogs := func(logs []observer.LoggedEntry) (traces, results []observer.LoggedEntry) {
+		for _, log := range logs {
+			switch log.Message {
+			case "GORM Trace":
+				traces = append(traces, log)
+			case "GORM Query Result":
+				results = append(results, log)
+			}
+		}
+		return traces, results
+	}
+
+	t.Run("Flagged request", func(t *testing.T) {
+		traces, results := gormLogs(serve(&Config{AllowDebugHeader: true}, true))
+		require.Len(t, traces, 1)
+		require.Len(t, results, 1)
+		assert.Equal(t, zapcore.DebugLevel, results[0].Level)
+		assert.Equal(t, true, results[0].ContextMap()["debug_log"])
+		assert.Contains(t, traces[0].ContextMap()["sql"], "debug-user")
+	})
+
+	t.Run("Unflagged request", func(t *testing.T) {
+		traces, results := gormLogs(serve(&Config{AllowDebugHeader: true}, false))
+		assert.Empty(t, traces)
+		assert.Empty(t, results)
+	})
+
+	t.Run("Header not allowed", func(t *testing.T) {
+		logs := serve(&Config{}, true)
+		traces, results := gormLogs(logs)
+		assert.Empty(t, traces)
+		assert.Empty(t, results)
+		require.NotEmpty(t, logs)
+		assert.NotContains(t, logs[0].ContextMap(), "debug_log")
+	})
 }
@@ -0,0 +1,138 @@
+package smartlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// graphQLContentTypes are the media types of GraphQL-over-HTTP POST requests.
+var graphQLContentTypes = []string{"application/json", "application/graphql+json"}
+
+var graphQLName = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+// graphQLOperation is the operation a GraphQL request executes.
+type graphQLOperation struct {
+	opType string // query, mutation or subscription
+	name   string // empty for anonymous operations
+}
+
+// parseGraphQLRequest recognizes a GraphQL request body: a JSON object with a query string,
+// and optionally operationName and variables. It returns the operation the request executes,
+// the one named by operationName when the document holds several.
+func parseGraphQLRequest(contentType string, body []byte) (graphQLOperation, bool) {
+	if !bytes.Contains(body, []byte(`"query"`)) || !contentTypeMatches(contentType, graphQLContentTypes) {
+		return graphQLOperation{}, false
+	}
+	var request struct {
+		Query         *string `json:"query"`
+		OperationName string  `json:"operationName"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil || request.Query == nil {
+		return graphQLOperation{}, false
+	}
+
+	name := request.OperationName
+	if !graphQLName.MatchString(name) {
+		name = ""
+	}
+	for _, op := range graphQLOperations(*request.Query) {
+		if name == "" || op.name == name {
+			return op, true
+		}
+	}
+	// The query shorthand, { ... }, is an anonymous query
+	return graphQLOperation{opType: "query", name: name}, true
+}
+
+// graphQLOperations lists the operations defined in a GraphQL document. Only names at the
+// top level count: selections, arguments, strings and # comments are skipped, so a field
+// named subscription isn't taken for an operation.
+func graphQLOperations(doc string) []graphQLOperation {
+	var ops []graphQLOperation
+	var prev string // the previous top-level name
+	depth := 0      // nesting of braces, parentheses and brackets
+	for i := 0; i < len(doc); {
+		c := doc[i]
+		switch {
+		case c == '#':
+			for i < len(doc) && doc[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(doc[i:], `"""`):
+			end := strings.Index(doc[i+3:], `"""`)
+			if end < 0 {
+				return ops
+			}
+			i += 3 + end + 3
+		case c == '"':
+			for i++; i < len(doc) && doc[i] != '"'; i++ {
+				if doc[i] == '\\' {
+					i++
+				}
+			}
+			i++
+		case c == '{' || c == '(' || c == '[':
+			depth++
+			prev = ""
+			i++
+		case c == '}' || c == ')' || c == ']':
+			depth = max(depth-1, 0)
+			prev = ""
+			i++
+		case c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			start := i
+			for i < len(doc) && (doc[i] == '_' || doc[i] >= '0' && doc[i] <= '9' || doc[i] >= 'A' && doc[i] <= 'Z' || doc[i] >= 'a' && doc[i] <= 'z') {
+				i++
+			}
+			if depth > 0 {
+				continue
+			}
+			word := doc[start:i]
+			switch {
+			case len(ops) > 0 && ops[len(ops)-1].name == "" && isGraphQLOperationType(prev):
+				// The name right after the operation type names the operation
+				ops[len(ops)-1].name = word
+			case isGraphQLOperationType(word) && prev != "fragment" && prev != "on":
+				ops = append(ops, graphQLOperation{opType: word})
+			}
+			prev = word
+		default:
+			if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ',' {
+				prev = "" // e.g. the @ of a directive
+			}
+			i++
+		}
+	}
+	return ops
+}
+
+func isGraphQLOperationType(word string) bool {
+	return word == "query" || word == "mutation" || word == "subscription"
+}
+
+// graphQLFields returns the fields describing the operation of a GraphQL request.
+func graphQLFields(op graphQLOperation) []zap.Field {
+	return []zap.Field{
+		zap.String("graphql_operation_type", op.opType),
+		optionalString("graphql_operation", op.name),
+	}
+}
+
+// stripGraphQLQuery removes the query string from a GraphQL request body, for
+// Config.GraphQLStripQuery. The operation name and variables are left in place.
+func stripGraphQLQuery(body []byte) []byte {
+	var request map[string]interface{}
+	if err := decodeJSONNumbers(body, &request); err != nil {
+		return body
+	}
+	delete(request, "query")
+	stripped, err := json.Marshal(request)
+	if err != nil {
+		return body
+	}
+	return stripped
+}
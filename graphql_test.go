@@ -0,0 +1,37 @@
+package smartlog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGraphQLRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		expected    graphQLOperation
+		ok          bool
+	}{
+		{"Named mutation", "application/json", `{"query":"mutation Login($password: String!) { login(password: $password) { token } }"}`, graphQLOperation{"mutation", "Login"}, true},
+		{"Anonymous query", "application/json", `{"query":"query { me { id } }"}`, graphQLOperation{"query", ""}, true},
+		{"Shorthand query", "application/json; charset=utf-8", `{"query":"{ me { id } }"}`, graphQLOperation{"query", ""}, true},
+		{"Operation picked by name", "application/graphql+json", `{"query":"query A { a } mutation B { b }","operationName":"B"}`, graphQLOperation{"mutation", "B"}, true},
+		{"Comments ignored", "application/json", `{"query":"# mutation Old\nsubscription OnOrder { order { id } }"}`, graphQLOperation{"subscription", "OnOrder"}, true},
+		{"Nested field named like an operation", "application/json", `{"query":"{ user { subscription { id } } }"}`, graphQLOperation{"query", ""}, true},
+		{"Keyword inside an argument string", "application/json", `{"query":"query Search { search(term: \"mutation Evil\") { id } }"}`, graphQLOperation{"query", "Search"}, true},
+		{"Anonymous operation before a named one", "application/json", `{"query":"query { a } mutation B { b }","operationName":"B"}`, graphQLOperation{"mutation", "B"}, true},
+		{"Fragment named like an operation", "application/json", `{"query":"fragment query on User { id } mutation M { m }"}`, graphQLOperation{"mutation", "M"}, true},
+		{"Not a GraphQL body", "application/json", `{"search":"query"}`, graphQLOperation{}, false},
+		{"Query that isn't a string", "application/json", `{"query":{"term":"shoes"}}`, graphQLOperation{}, false},
+		{"Other content type", "text/plain", `{"query":"query { me { id } }"}`, graphQLOperation{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op, ok := parseGraphQLRequest(tt.contentType, []byte(tt.body))
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.expected, op)
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package smartlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+)
+
+// maxDecodedBodyBytes caps how much of a compressed body is inflated for logging,
+// guarding against decompression bombs.
+const maxDecodedBodyBytes = 10 << 20
+
+// decodeBodyForLog returns a decompressed copy of a gzip-encoded body for logging.
+// Bodies with any other encoding are returned unchanged. If the body can't be decoded
+// or inflates past maxDecodedBodyBytes, nil is returned so that compressed or partial
+// payloads never reach the log unredacted.
+func decodeBodyForLog(body []byte, contentEncoding string) []byte {
+	encoding := strings.ToLower(strings.TrimSpace(contentEncoding))
+	if len(body) == 0 || (encoding != "gzip" && encoding != "x-gzip") {
+		return body
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	defer zr.Close()
+
+	decoded, err := io.ReadAll(io.LimitReader(zr, maxDecodedBodyBytes+1))
+	if err != nil || len(decoded) > maxDecodedBodyBytes {
+		return nil
+	}
+	return decoded
+}
@@ -0,0 +1,72 @@
+package smartlog
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// defaultLatencyBuckets are the bucket boundaries, in milliseconds, used when none are configured.
+var defaultLatencyBuckets = []int{10, 50, 200, 1000}
+
+// latencyBuckets maps a latency onto a human-readable bucket label such as "10-50ms".
+type latencyBuckets struct {
+	bounds []time.Duration
+	labels []string
+}
+
+// newLatencyBuckets builds the bucket labels for the given boundaries in milliseconds.
+func newLatencyBuckets(boundsMs []int) *latencyBuckets {
+	if len(boundsMs) == 0 {
+		boundsMs = defaultLatencyBuckets
+	}
+	sorted := append([]int(nil), boundsMs...)
+	sort.Ints(sorted)
+
+	lb := &latencyBuckets{}
+	for i, ms := range sorted {
+		lb.bounds = append(lb.bounds, time.Duration(ms)*time.Millisecond)
+		if i == 0 {
+			lb.labels = append(lb.labels, "<"+formatBucketBound(ms))
+		} else {
+			lb.labels = append(lb.labels, formatBucketRange(sorted[i-1], ms))
+		}
+	}
+	lb.labels = append(lb.labels, ">"+formatBucketBound(sorted[len(sorted)-1]))
+	return lb
+}
+
+// bucket returns the label of the bucket the latency falls into.
+func (lb *latencyBuckets) bucket(latency time.Duration) string {
+	for i, bound := range lb.bounds {
+		if latency < bound {
+			return lb.labels[i]
+		}
+	}
+	return lb.labels[len(lb.labels)-1]
+}
+
+// formatBucketBound formats a boundary in milliseconds, using seconds for whole seconds.
+func formatBucketBound(ms int) string {
+	if ms >= 1000 && ms%1000 == 0 {
+		return fmt.Sprintf("%ds", ms/1000)
+	}
+	return fmt.Sprintf("%dms", ms)
+}
+
+// formatBucketRange formats a range such as "10-50ms", or "200ms-1s" when the units differ.
+func formatBucketRange(lowMs, highMs int) string {
+	low, high := formatBucketBound(lowMs), formatBucketBound(highMs)
+	lowUnit, highUnit := bucketUnit(low), bucketUnit(high)
+	if lowUnit == highUnit {
+		return low[:len(low)-len(lowUnit)] + "-" + high
+	}
+	return low + "-" + high
+}
+
+func bucketUnit(bound string) string {
+	if len(bound) > 2 && bound[len(bound)-2:] == "ms" {
+		return "ms"
+	}
+	return "s"
+}
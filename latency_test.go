@@ -0,0 +1,31 @@
+package smartlog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyBuckets(t *testing.T) {
+	testCases := []struct {
+		name     string
+		bounds   []int
+		latency  time.Duration
+		expected string
+	}{
+		{name: "Below first bound", latency: 5 * time.Millisecond, expected: "<10ms"},
+		{name: "Lower bound is inclusive", latency: 10 * time.Millisecond, expected: "10-50ms"},
+		{name: "Middle bucket", latency: 120 * time.Millisecond, expected: "50-200ms"},
+		{name: "Mixed units", latency: 500 * time.Millisecond, expected: "200ms-1s"},
+		{name: "Above last bound", latency: 3 * time.Second, expected: ">1s"},
+		{name: "Custom bounds", bounds: []int{500, 100}, latency: 120 * time.Millisecond, expected: "100-500ms"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := newLatencyBuckets(tc.bounds).bucket(tc.latency)
+			if got != tc.expected {
+				t.Errorf("Expected bucket '%s', but got '%s'", tc.expected, got)
+			}
+		})
+	}
+}
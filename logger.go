@@ -1,7 +1,14 @@
 package smartlog
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/DeRuina/timberjack"
@@ -9,55 +16,279 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
+// Build metadata baked into the binary with ldflags, logged by NewLogger when
+// Config.LogBuildInfo is set:
+//
+//	go build -ldflags "-X smartlog.BuildCommit=$(git rev-parse HEAD) -X smartlog.BuildTime=$(date -u +%FT%TZ)"
+var (
+	BuildVersion string // logged as version unless Config.Version is set
+	BuildCommit  string // logged as commit
+	BuildTime    string // logged as build_time
+)
+
 // NewLogger creates a new Zap logger with Timberjack for log rotation.
-func NewLogger(cfg *Config) *zap.Logger {
-	// Timberjack hook for rotating log files
-	timberjackHook := &timberjack.Logger{
-		Filename:         cfg.Log.Filename,
-		MaxSize:          cfg.Log.MaxSize,
-		MaxBackups:       cfg.Log.MaxBackups,
-		MaxAge:           cfg.Log.MaxAge,
-		Compression:      cfg.Log.Compression,
-		RotationInterval: time.Duration(cfg.Log.RotationInterval) * time.Hour,
+//
+// If the log file can't be opened, the logger falls back to console-only output and
+// emits a warning, unless cfg.Log.RequireFile is set, in which case NewLogger panics.
+func NewLogger(cfg *Config, opts ...Option) *zap.Logger {
+	o := newOptions(opts)
+
+	// Make sure the log file can be written before handing it to timberjack,
+	// which would otherwise only fail on the first write.
+	fileErr := prepareLogFile(cfg.Log.Filename)
+	if fileErr != nil && cfg.Log.RequireFile {
+		panic(fmt.Sprintf("smartlog: cannot open log file %q: %v", cfg.Log.Filename, fileErr))
 	}
 
 	// Zap core configuration
-	encoderConfig := zap.NewProductionEncoderConfig()
-	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-	encoderConfig.TimeKey = "timestamp"
-	encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
-	encoderConfig.MessageKey = "message"
+	encoderConfig := newEncoderConfig()
+
+	// Create a core that writes to the console. Colors only ever apply to the console.
+	consoleEncoderConfig := encoderConfig
+	consoleEncoderConfig.EncodeLevel = consoleLevelEncoder(cfg)
+	consoleWriter := countWrites(zapcore.AddSync(os.Stdout), o.metrics)
+	consoleEncoder := zapcore.NewConsoleEncoder(consoleEncoderConfig)
+	if cfg.PrettyJSON {
+		consoleEncoder = prettyJSONEncoder{Encoder: consoleEncoder, console: true}
+	}
+	consoleCore := zapcore.NewCore(consoleEncoder, consoleWriter, zap.DebugLevel)
+	cores := []zapcore.Core{consoleCore}
 
-	// Create a core that writes to the timberjack hook
-	fileWriter := zapcore.AddSync(timberjackHook)
-	// Also create a core that writes to the console
-	consoleWriter := zapcore.AddSync(os.Stdout)
+	// Fall back to no compression rather than leaving it to timberjack to complain on stderr
+	compression := strings.ToLower(strings.TrimSpace(cfg.Log.Compression))
+	compressionErr := validateCompression(compression)
+	if compressionErr != nil {
+		compression = CompressionNone
+	}
 
-	// Determine the log level for the file writer
-	fileLogLevel := zap.InfoLevel
-	if cfg.Log.Level != "" {
-		switch cfg.Log.Level {
-		case "debug":
-			fileLogLevel = zap.DebugLevel
-		case "warn":
-			fileLogLevel = zap.WarnLevel
-		case "error":
-			fileLogLevel = zap.ErrorLevel
+	var timberjackHook *timberjack.Logger
+	if fileErr == nil {
+		// Timberjack hook for rotating log files
+		timberjackHook = &timberjack.Logger{
+			Filename:         cfg.Log.Filename,
+			MaxSize:          cfg.Log.MaxSize,
+			MaxBackups:       cfg.Log.MaxBackups,
+			MaxAge:           cfg.Log.MaxAge,
+			Compression:      compression,
+			RotationInterval: time.Duration(cfg.Log.RotationInterval) * time.Hour,
+		}
+
+		// Determine the log level for the file writer
+		fileLogLevel := zap.InfoLevel
+		if cfg.Log.Level != "" {
+			switch cfg.Log.Level {
+			case "debug":
+				fileLogLevel = zap.DebugLevel
+			case "warn":
+				fileLogLevel = zap.WarnLevel
+			case "error":
+				fileLogLevel = zap.ErrorLevel
+			}
+		}
+
+		// Create a core that writes to the timberjack hook. Each entry is encoded in full and
+		// handed over in a single Write, which timberjack serializes, so concurrent entries
+		// never interleave.
+		fileWriter := countWrites(zapcore.AddSync(timberjackHook), o.metrics)
+		fileEncoder := zapcore.NewJSONEncoder(encoderConfig)
+		if cfg.PrettyJSON {
+			fileEncoder = prettyJSONEncoder{Encoder: fileEncoder}
+		}
+		fileCore := zapcore.NewCore(fileEncoder, fileWriter, fileLogLevel)
+		cores = append([]zapcore.Core{fileCore}, cores...)
+	}
+
+	// Extra cores, such as a remote collector, receive the same entries
+	cores = append(cores, o.extraCores...)
+
+	// Rename field keys if a naming scheme or overrides are configured.
+	// Each core is wrapped individually so that their levels are respected.
+	if namer := newFieldNamer(cfg); namer != nil {
+		for i, c := range cores {
+			cores[i] = &fieldNamingCore{Core: c, namer: namer}
 		}
 	}
 
 	// Combine writers to log to both file and console
-	core := zapcore.NewTee(
-		zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), fileWriter, fileLogLevel),
-		zapcore.NewCore(zapcore.NewConsoleEncoder(encoderConfig), consoleWriter, zap.DebugLevel),
-	)
+	core := zapcore.NewTee(cores...)
+	if o.metrics != nil {
+		core = &metricsCore{Core: core, metrics: o.metrics}
+	}
 
-	// Create the logger with the service and env fields
+	// Create the logger with the service, env and version fields, and the build metadata
+	// set via ldflags when asked for
+	version := cfg.Version
+	if version == "" && cfg.LogBuildInfo {
+		version = BuildVersion
+	}
+	if version == "" {
+		version = buildVersion()
+	}
+	var commit, buildTime string
+	if cfg.LogBuildInfo {
+		commit, buildTime = BuildCommit, BuildTime
+	}
 	logger := zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel)).
 		With(
 			zap.String("service", cfg.ServiceName),
 			zap.String("env", cfg.Env),
+			optionalString("version", version),
+			optionalString("commit", commit),
+			optionalString("build_time", buildTime),
 		)
 
+	if o.rotator != nil && timberjackHook != nil {
+		o.rotator.attach(timberjackHook, logger)
+	}
+
+	if fileErr != nil {
+		logger.Warn("Log file unavailable, logging to console only",
+			zap.String("filename", cfg.Log.Filename),
+			zap.Error(fileErr),
+		)
+	}
+	if compressionErr != nil {
+		logger.Warn("Invalid log compression, rotated files won't be compressed", zap.Error(compressionErr))
+	}
+	if cfg.PrettyJSON {
+		logger.Warn("pretty_json is set: entries span several lines, which most log ingesters can't parse. Only use it for local debugging")
+	}
+
 	return logger
 }
+
+// SyncWithTimeout flushes the logger like logger.Sync, but gives up after d so that a
+// stalled sink can't hang a graceful shutdown. It returns context.DeadlineExceeded on
+// timeout; the underlying Sync keeps running in the background.
+//
+// Call it from your shutdown path, e.g. after receiving SIGTERM:
+//
+//	<-sigCh
+//	server.Shutdown(ctx)
+//	_ = smartlog.SyncWithTimeout(logger, 5*time.Second)
+func SyncWithTimeout(logger *zap.Logger, d time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- logger.Sync()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(d):
+		return context.DeadlineExceeded
+	}
+}
+
+// consoleLevelEncoder returns the level encoder for the console output. Colors are used
+// when cfg.ConsoleColor is set, unless the NO_COLOR environment variable is present and
+// not empty.
+func consoleLevelEncoder(cfg *Config) zapcore.LevelEncoder {
+	if cfg.ConsoleColor && os.Getenv("NO_COLOR") == "" {
+		return zapcore.CapitalColorLevelEncoder
+	}
+	return zapcore.CapitalLevelEncoder
+}
+
+// prepareLogFile creates the parent directory of the log file and checks that the
+// file can be opened for writing. An empty filename is left to timberjack's default.
+func prepareLogFile(filename string) error {
+	if filename == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// checkLogFile reports whether prepareLogFile would succeed for filename, without creating
+// the file or its directories. An existing file is opened for appending, and otherwise a
+// temporary file is created and removed in the nearest existing parent directory.
+func checkLogFile(filename string) error {
+	if filename == "" {
+		return nil
+	}
+	if info, err := os.Stat(filename); err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory", filename)
+		}
+		f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	dir := filepath.Dir(filename)
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+	probe, err := os.CreateTemp(dir, ".smartlog-probe-*")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// newEncoderConfig returns the encoder configuration shared by the file and remote cores.
+func newEncoderConfig() zapcore.EncoderConfig {
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	encoderConfig.TimeKey = "timestamp"
+	encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+	encoderConfig.MessageKey = "message"
+	return encoderConfig
+}
+
+// readBuildInfo is debug.ReadBuildInfo, replaced in tests.
+var readBuildInfo = debug.ReadBuildInfo
+
+// buildVersion derives a version from the binary's build info: the main module version
+// when built from a tagged module, else the VCS revision stamped by go build, suffixed
+// with "-dirty" for uncommitted changes. It returns an empty string if neither is known.
+func buildVersion() string {
+	info, ok := readBuildInfo()
+	if !ok {
+		return ""
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+
+	var revision string
+	var modified bool
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision != "" && modified {
+		revision += "-dirty"
+	}
+	return revision
+}
@@ -0,0 +1,347 @@
+package smartlog
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNewLogger_CamelCaseFieldNaming(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "camel.log")
+	cfg := &Config{
+		ServiceName: "test-service",
+		Log: TimberjackConfig{
+			Filename: logPath,
+		},
+		FieldNaming: FieldNamingCamel,
+		FieldNames:  map[string]string{"status": "statusCode"},
+	}
+	logger := NewLogger(cfg)
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	wrappedHandler := ServerLogging(logger, cfg)(testHandler)
+
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set(HeaderLogID, "camel-id")
+	wrappedHandler.ServeHTTP(httptest.NewRecorder(), req)
+	logger.Sync()
+
+	logContent, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	logString := string(logContent)
+
+	assert.Contains(t, logString, `"logId":"camel-id"`)
+	assert.Contains(t, logString, `"latencyMs":`)
+	assert.Contains(t, logString, `"statusCode":200`)
+	assert.NotContains(t, logString, `"log_id"`)
+	assert.NotContains(t, logString, `"latency_ms"`)
+}
+
+func TestSnakeToCamel(t *testing.T) {
+	assert.Equal(t, "logId", snakeToCamel("log_id"))
+	assert.Equal(t, "latencyMs", snakeToCamel("latency_ms"))
+	assert.Equal(t, "status", snakeToCamel("status"))
+}
+
+func TestFieldNamingCore_RenamesTopLevelKeysOnly(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(&fieldNamingCore{Core: core, namer: &fieldNamer{camel: true}})
+
+	logger.Info("renamed",
+		zap.String("log_id", "naming-id"),
+		zap.Object("request", httpRequestLog{bodyRaw: "hello", skipHeaders: true}),
+		zap.Namespace("db_error"),
+		zap.String("error_kind", "timeout"),
+	)
+
+	fields := recorded.All()[0].ContextMap()
+	assert.Equal(t, "naming-id", fields["logId"])
+	assert.Equal(t, "timeout", fields["dbError"].(map[string]interface{})["errorKind"], "Fields after a namespace are top-level fields")
+	// Keys written by object marshalers keep their names
+	assert.Equal(t, "hello", fields["request"].(map[string]interface{})["body_raw"])
+}
+
+func TestNewLogger_FallsBackToConsoleWhenFileUnavailable(t *testing.T) {
+	// A regular file can't act as the parent directory of the log file
+	tempDir := t.TempDir()
+	blocker := filepath.Join(tempDir, "not-a-dir")
+	require.NoError(t, os.WriteFile(blocker, nil, 0o644))
+	logPath := filepath.Join(blocker, "app.log")
+
+	// Capture the console output
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	logger := NewLogger(&Config{Log: TimberjackConfig{Filename: logPath}})
+	logger.Info("still logging")
+	logger.Sync()
+	w.Close()
+	os.Stdout = stdout
+
+	output, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Contains(t, string(output), "Log file unavailable, logging to console only")
+	assert.Contains(t, string(output), "still logging")
+
+	_, err = os.Stat(logPath)
+	assert.Error(t, err, "Log file should not be created")
+}
+
+func TestNewLogger_RequireFilePanics(t *testing.T) {
+	blocker := filepath.Join(t.TempDir(), "not-a-dir")
+	require.NoError(t, os.WriteFile(blocker, nil, 0o644))
+
+	cfg := &Config{Log: TimberjackConfig{
+		Filename:    filepath.Join(blocker, "app.log"),
+		RequireFile: true,
+	}}
+	assert.Panics(t, func() { NewLogger(cfg) })
+}
+
+func TestNewLogger_CreatesLogDirectory(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "nested", "dir", "app.log")
+	logger := NewLogger(&Config{Log: TimberjackConfig{Filename: logPath}})
+	logger.Info("hello")
+	logger.Sync()
+
+	_, err := os.Stat(logPath)
+	assert.NoError(t, err, "Log file should be created in a new directory")
+}
+
+func TestNewLogger_WritesNDJSON(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "app.log")
+	logger := NewLogger(&Config{Log: TimberjackConfig{Filename: logPath}})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				logger.Info("concurrent\nentry", zap.Int("goroutine", g), zap.String("payload", strings.Repeat("x", 512)))
+			}
+		}()
+	}
+	wg.Wait()
+	logger.Sync()
+
+	logContent, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(string(logContent), "\n"), "the last entry ends in a newline")
+
+	lines := strings.Split(strings.TrimSuffix(string(logContent), "\n"), "\n")
+	require.Len(t, lines, 400)
+	for _, line := range lines {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), "every line is a JSON object: %s", line)
+		assert.Equal(t, "concurrent\nentry", entry["message"])
+	}
+}
+
+func TestNewLogger_PrettyJSON(t *testing.T) {
+	logEntry := func(t *testing.T, pretty bool) string {
+		logPath := filepath.Join(t.TempDir(), "app.log")
+		logger := NewLogger(&Config{PrettyJSON: pretty, Log: TimberjackConfig{Filename: logPath}})
+		logger.Info("hello", zap.String("user", "jules"))
+		logger.Sync()
+
+		logContent, err := os.ReadFile(logPath)
+		require.NoError(t, err)
+		return string(logContent)
+	}
+
+	t.Run("Compact by default", func(t *testing.T) {
+		lines := strings.Split(strings.TrimSuffix(logEntry(t, false), "\n"), "\n")
+		require.Len(t, lines, 1)
+		assert.Contains(t, lines[0], `"user":"jules"`)
+	})
+
+	t.Run("Indented when enabled", func(t *testing.T) {
+		logContent := logEntry(t, true)
+		assert.Contains(t, logContent, "\n  \"user\": \"jules\"")
+		assert.Contains(t, logContent, "pretty_json is set", "The logger warns it breaks log ingesters")
+
+		// Each entry is still a complete JSON object, followed by a newline
+		dec := json.NewDecoder(strings.NewReader(logContent))
+		var messages []string
+		for dec.More() {
+			var entry map[string]interface{}
+			require.NoError(t, dec.Decode(&entry))
+			messages = append(messages, entry["message"].(string))
+		}
+		assert.Len(t, messages, 2)
+		assert.Equal(t, "hello", messages[1])
+		assert.True(t, strings.HasSuffix(logContent, "}\n"))
+	})
+
+	t.Run("Console fields indented", func(t *testing.T) {
+		encoder := prettyJSONEncoder{Encoder: zapcore.NewConsoleEncoder(newEncoderConfig()), console: true}
+		buf, err := encoder.EncodeEntry(zapcore.Entry{Message: "hello\t{not json}"}, []zapcore.Field{zap.String("user", "jules")})
+		require.NoError(t, err)
+		defer buf.Free()
+		assert.Contains(t, buf.String(), "hello\t{not json}\t{\n  \"user\": \"jules\"\n}\n")
+	})
+}
+
+func TestNewLogger_Version(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "app.log")
+	logger := NewLogger(&Config{ServiceName: "test-service", Version: "v1.4.2", Log: TimberjackConfig{Filename: logPath}})
+	logger.Info("hello")
+	logger.Sync()
+
+	logContent, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(logContent), `"version":"v1.4.2"`)
+}
+
+func TestNewLogger_BuildInfo(t *testing.T) {
+	defer func(version, commit, buildTime string) {
+		BuildVersion, BuildCommit, BuildTime = version, commit, buildTime
+	}(BuildVersion, BuildCommit, BuildTime)
+	BuildVersion, BuildCommit, BuildTime = "v2.0.1", "9c1e4f2", "2024-05-01T12:00:00Z"
+
+	logLine := func(t *testing.T, cfg *Config) map[string]interface{} {
+		cfg.Log.Filename = filepath.Join(t.TempDir(), "app.log")
+		logger := NewLogger(cfg)
+		logger.Info("hello")
+		logger.Sync()
+
+		logContent, err := os.ReadFile(cfg.Log.Filename)
+		require.NoError(t, err)
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(logContent, &entry))
+		return entry
+	}
+
+	t.Run("Logged when enabled", func(t *testing.T) {
+		entry := logLine(t, &Config{LogBuildInfo: true})
+		assert.Equal(t, "v2.0.1", entry["version"])
+		assert.Equal(t, "9c1e4f2", entry["commit"])
+		assert.Equal(t, "2024-05-01T12:00:00Z", entry["build_time"])
+	})
+
+	t.Run("Config version wins", func(t *testing.T) {
+		entry := logLine(t, &Config{LogBuildInfo: true, Version: "v2.0.2"})
+		assert.Equal(t, "v2.0.2", entry["version"])
+		assert.Equal(t, "9c1e4f2", entry["commit"])
+	})
+
+	t.Run("Empty values left out", func(t *testing.T) {
+		BuildTime = ""
+		defer func() { BuildTime = "2024-05-01T12:00:00Z" }()
+		entry := logLine(t, &Config{LogBuildInfo: true})
+		assert.Equal(t, "9c1e4f2", entry["commit"])
+		assert.NotContains(t, entry, "build_time")
+	})
+
+	t.Run("Not logged by default", func(t *testing.T) {
+		entry := logLine(t, &Config{})
+		assert.NotContains(t, entry, "commit")
+		assert.NotContains(t, entry, "build_time")
+		assert.NotEqual(t, "v2.0.1", entry["version"])
+	})
+}
+
+func TestBuildVersion(t *testing.T) {
+	defer func(read func() (*debug.BuildInfo, bool)) { readBuildInfo = read }(readBuildInfo)
+
+	testCases := []struct {
+		name     string
+		info     *debug.BuildInfo
+		expected string
+	}{
+		{"No build info", nil, ""},
+		{"Tagged module", &debug.BuildInfo{Main: debug.Module{Version: "v1.2.3"}}, "v1.2.3"},
+		{"VCS revision", &debug.BuildInfo{Main: debug.Module{Version: "(devel)"}, Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "4f2a9c1"},
+			{Key: "vcs.modified", Value: "false"},
+		}}, "4f2a9c1"},
+		{"Uncommitted changes", &debug.BuildInfo{Main: debug.Module{Version: "(devel)"}, Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "4f2a9c1"},
+			{Key: "vcs.modified", Value: "true"},
+		}}, "4f2a9c1-dirty"},
+		{"Neither", &debug.BuildInfo{Main: debug.Module{Version: "(devel)"}}, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			readBuildInfo = func() (*debug.BuildInfo, bool) { return tc.info, tc.info != nil }
+			assert.Equal(t, tc.expected, buildVersion())
+		})
+	}
+}
+
+func TestConsoleLevelEncoder(t *testing.T) {
+	encodeLevel := func(cfg *Config) string {
+		encoderConfig := zap.NewProductionEncoderConfig()
+		encoderConfig.EncodeLevel = consoleLevelEncoder(cfg)
+		buf, err := zapcore.NewConsoleEncoder(encoderConfig).EncodeEntry(zapcore.Entry{Level: zapcore.WarnLevel}, nil)
+		require.NoError(t, err)
+		defer buf.Free()
+		return buf.String()
+	}
+
+	t.Run("Colors disabled by default", func(t *testing.T) {
+		assert.False(t, strings.Contains(encodeLevel(&Config{}), "\x1b["))
+	})
+
+	t.Run("Colors enabled", func(t *testing.T) {
+		// An empty NO_COLOR counts as unset
+		t.Setenv("NO_COLOR", "")
+		assert.True(t, strings.Contains(encodeLevel(&Config{ConsoleColor: true}), "\x1b["))
+	})
+
+	t.Run("NO_COLOR overrides the config", func(t *testing.T) {
+		t.Setenv("NO_COLOR", "1")
+		assert.False(t, strings.Contains(encodeLevel(&Config{ConsoleColor: true}), "\x1b["))
+	})
+}
+
+// blockingSyncer is a zapcore.WriteSyncer whose Sync blocks until released.
+type blockingSyncer struct {
+	release chan struct{}
+}
+
+func (b *blockingSyncer) Write(p []byte) (int, error) { return len(p), nil }
+
+func (b *blockingSyncer) Sync() error {
+	<-b.release
+	return nil
+}
+
+func TestSyncWithTimeout(t *testing.T) {
+	t.Run("Times out on a blocking sink", func(t *testing.T) {
+		sink := &blockingSyncer{release: make(chan struct{})}
+		defer close(sink.release)
+		logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), sink, zapcore.InfoLevel))
+
+		start := time.Now()
+		err := SyncWithTimeout(logger, 50*time.Millisecond)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), time.Second, "Should return shortly after the timeout")
+	})
+
+	t.Run("Returns the Sync result when it completes", func(t *testing.T) {
+		logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(io.Discard), zapcore.InfoLevel))
+		assert.NoError(t, SyncWithTimeout(logger, time.Second))
+	})
+}
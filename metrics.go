@@ -0,0 +1,152 @@
+package smartlog
+
+import (
+	"bytes"
+	"net/http"
+	"sync/atomic"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Metrics counts what the loggers and middleware of this package log, for capacity planning
+// and to spot log volume regressions. Pass the same Metrics to NewLogger, ServerLogging and
+// NewClientLogger with WithMetrics, and read the counts with Stats:
+//
+//	metrics := smartlog.NewMetrics()
+//	logger := smartlog.NewLogger(cfg, smartlog.WithMetrics(metrics))
+//	mw := smartlog.ServerLogging(logger, cfg, smartlog.WithMetrics(metrics))
+//	...
+//	stats := metrics.Stats()
+//
+// A nil *Metrics counts nothing.
+type Metrics struct {
+	debugEntries, infoEntries, warnEntries, errorEntries atomic.Uint64
+	bytesWritten                                         atomic.Uint64
+	redactions                                           atomic.Uint64
+	truncatedBodies                                      atomic.Uint64
+}
+
+// LogStats is a snapshot of the counts of a Metrics.
+type LogStats struct {
+	DebugEntries uint64 // entries logged at debug level
+	InfoEntries  uint64 // entries logged at info level
+	WarnEntries  uint64 // entries logged at warn level
+	ErrorEntries uint64 // entries logged at error level or above
+	// BytesWritten is the size of the encoded entries written to the log file and console.
+	BytesWritten uint64
+	// Redactions is the number of header and body values replaced with [REDACTED].
+	Redactions uint64
+	// TruncatedBodies is the number of bodies cut at max_body_log_bytes.
+	TruncatedBodies uint64
+}
+
+// NewMetrics returns a Metrics with every count at zero.
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+// Stats returns the current counts.
+func (m *Metrics) Stats() LogStats {
+	if m == nil {
+		return LogStats{}
+	}
+	return LogStats{
+		DebugEntries:    m.debugEntries.Load(),
+		InfoEntries:     m.infoEntries.Load(),
+		WarnEntries:     m.warnEntries.Load(),
+		ErrorEntries:    m.errorEntries.Load(),
+		BytesWritten:    m.bytesWritten.Load(),
+		Redactions:      m.redactions.Load(),
+		TruncatedBodies: m.truncatedBodies.Load(),
+	}
+}
+
+// countEntry counts an entry logged at level.
+func (m *Metrics) countEntry(level zapcore.Level) {
+	if m == nil {
+		return
+	}
+	switch {
+	case level >= zapcore.ErrorLevel:
+		m.errorEntries.Add(1)
+	case level == zapcore.WarnLevel:
+		m.warnEntries.Add(1)
+	case level == zapcore.InfoLevel:
+		m.infoEntries.Add(1)
+	default:
+		m.debugEntries.Add(1)
+	}
+}
+
+// observeBody counts the values redacted from a body, and whether the body logged for it
+// was truncated.
+func (m *Metrics) observeBody(original, redacted []byte, truncated bool) {
+	if m == nil {
+		return
+	}
+	placeholder := []byte(redactionPlaceholder)
+	if n := bytes.Count(redacted, placeholder) - bytes.Count(original, placeholder); n > 0 {
+		m.redactions.Add(uint64(n))
+	}
+	if truncated {
+		m.truncatedBodies.Add(1)
+	}
+}
+
+// observeHeaders counts the headers redacted from original.
+func (m *Metrics) observeHeaders(original, redacted http.Header) {
+	if m == nil {
+		return
+	}
+	for key, values := range redacted {
+		if len(values) == 1 && values[0] == redactionPlaceholder && original.Get(key) != redactionPlaceholder {
+			m.redactions.Add(1)
+		}
+	}
+}
+
+// countingWriteSyncer counts the bytes written to a log sink.
+type countingWriteSyncer struct {
+	zapcore.WriteSyncer
+	metrics *Metrics
+}
+
+func (w countingWriteSyncer) Write(p []byte) (int, error) {
+	n, err := w.WriteSyncer.Write(p)
+	w.metrics.bytesWritten.Add(uint64(n))
+	return n, err
+}
+
+// countWrites returns ws counting its bytes into m, or ws itself if m is nil.
+func countWrites(ws zapcore.WriteSyncer, m *Metrics) zapcore.WriteSyncer {
+	if m == nil {
+		return ws
+	}
+	return countingWriteSyncer{WriteSyncer: ws, metrics: m}
+}
+
+// metricsCore counts the entries written through the wrapped core, once per entry whatever
+// the number of sinks it fans out to.
+type metricsCore struct {
+	zapcore.Core
+	metrics *Metrics
+}
+
+// With keeps counting into the same Metrics.
+func (c *metricsCore) With(fields []zapcore.Field) zapcore.Core {
+	return &metricsCore{Core: c.Core.With(fields), metrics: c.metrics}
+}
+
+// Check counts the entry if any sink accepts it, and lets the sinks register themselves.
+func (c *metricsCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		c.metrics.countEntry(ent.Level)
+	}
+	return c.Core.Check(ent, ce)
+}
+
+// Write counts entries written without a Check, like those of debugCore.
+func (c *metricsCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	c.metrics.countEntry(ent.Level)
+	return c.Core.Write(ent, fields)
+}
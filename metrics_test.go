@@ -0,0 +1,60 @@
+package smartlog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMetrics_EntriesAndBytes(t *testing.T) {
+	metrics := NewMetrics()
+	logPath := filepath.Join(t.TempDir(), "app.log")
+	logger := NewLogger(&Config{Log: TimberjackConfig{Filename: logPath}}, WithMetrics(metrics))
+
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Info("info again")
+	logger.Warn("warn")
+	logger.Error("error")
+	logger.Sync()
+
+	stats := metrics.Stats()
+	assert.Equal(t, uint64(1), stats.DebugEntries)
+	assert.Equal(t, uint64(2), stats.InfoEntries, "Entries are counted once, not once per sink")
+	assert.Equal(t, uint64(1), stats.WarnEntries)
+	assert.Equal(t, uint64(1), stats.ErrorEntries)
+
+	logContent, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Greater(t, stats.BytesWritten, uint64(len(logContent)), "Bytes cover the file and the console")
+}
+
+func TestMetrics_RedactionsAndTruncation(t *testing.T) {
+	metrics := NewMetrics()
+	core, _ := observer.New(zapcore.InfoLevel)
+	cfg := &Config{RedactKeys: []string{"password"}, MaxBodyLogBytes: 30}
+	handler := ServerLogging(zap.New(core), cfg, WithMetrics(metrics))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("plain text response"))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"password":"hunter2","user":"jules"}`))
+	req.Header.Set("Authorization", "Bearer token")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	stats := metrics.Stats()
+	assert.Equal(t, uint64(2), stats.Redactions, "The password and the Authorization header")
+	assert.Equal(t, uint64(1), stats.TruncatedBodies, "The request body is cut after the password")
+
+	// Without metrics nothing is counted
+	var none *Metrics
+	assert.Equal(t, LogStats{}, none.Stats())
+}
@@ -0,0 +1,147 @@
+package smartlog
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Chain composes middlewares into one. The first middleware is the outermost: it sees the
+// request first and the response last, so Chain(a, b)(h) is equivalent to a(b(h)).
+func Chain(mw ...func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		for i := len(mw) - 1; i >= 0; i-- {
+			next = mw[i](next)
+		}
+		return next
+	}
+}
+
+// DefaultStack is the recommended composition of the server middlewares: ServerLogging,
+// then Recovery, then WithTimeout with cfg.RequestTimeoutMs. Recovery runs inside the
+// logging so the panic log carries the request's log_id and the 500 it answers gets a
+// response log, and the timeout comes last so the logged latency includes the time spent
+// waiting for it.
+func DefaultStack(logger *zap.Logger, cfg *Config, opts ...Option) func(http.Handler) http.Handler {
+	return Chain(
+		ServerLogging(logger, cfg, opts...),
+		Recovery(logger),
+		WithTimeout(time.Duration(cfg.RequestTimeoutMs)*time.Millisecond),
+	)
+}
+
+// Recovery is a middleware that recovers from panics in the handler, logs them with the
+// stack trace and answers 500 Internal Server Error if nothing was written yet. The panic
+// is logged through the request's context logger when there is one, so it carries the
+// log_id. http.ErrAbortHandler is re-panicked, as net/http uses it to abort a response.
+//
+// Inside ServerLogging, the panic stack is also attached to the 500 response log when
+// Config.StacktraceOn5xx is set.
+func Recovery(logger *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Inside ServerLogging, its writer in the context already tracks what was written
+			var started func() bool
+			if rw := responseWriterFromContext(r.Context()); rw != nil {
+				started = func() bool { return rw.wroteHeader || rw.hijacked }
+			} else {
+				tw := &writeTracker{ResponseWriter: w}
+				started = func() bool { return tw.wrote }
+				w = tw
+			}
+
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+
+				panicLogger := logger
+				if ctxLogger, ok := r.Context().Value(LoggerKey).(*zap.Logger); ok {
+					panicLogger = ctxLogger
+				}
+				stack := debug.Stack()
+				if state := requestStateFromContext(r.Context()); state != nil {
+					state.setPanicStack(stack)
+				}
+				panicLogger.Error("Panic recovered",
+					zap.String("method", r.Method),
+					zap.String("path", r.URL.Path),
+					zap.String("panic", fmt.Sprint(v)),
+					zap.ByteString("stack", stack),
+				)
+				// A status can't be sent once the response has started
+				if !started() {
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeTracker records whether the handler started the response, for Recovery used
+// outside ServerLogging.
+type writeTracker struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+// WriteHeader marks the response as started once a final (non-1xx) status is written.
+func (w *writeTracker) WriteHeader(code int) {
+	w.wrote = w.wrote || code >= 200
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *writeTracker) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *writeTracker) Flush() {
+	w.wrote = true
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *writeTracker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.wrote = true
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the original ResponseWriter.
+func (w *writeTracker) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// WithTimeout is a middleware that gives the request context a deadline of d. Handlers
+// are expected to honour the context; unlike http.TimeoutHandler, the response isn't
+// buffered, so streaming and hijacking keep working. Inside ServerLogging, a request whose
+// deadline passed before the handler returned is logged with timeout: true and the status
+// the handler sent. A d of 0 or less disables it.
+func WithTimeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+			if rw := responseWriterFromContext(ctx); rw != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				rw.deadlineExceeded = true
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package smartlog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	record := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name+" in")
+				next.ServeHTTP(w, r)
+				calls = append(calls, name+" out")
+			})
+		}
+	}
+
+	handler := Chain(record("first"), record("second"), record("third"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, []string{"first in", "second in", "third in", "handler", "third out", "second out", "first out"}, calls)
+}
+
+func TestDefaultStack(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	stack := DefaultStack(logger, &Config{RequestTimeoutMs: 50})
+
+	t.Run("Logs and bounds the request", func(t *testing.T) {
+		var deadline time.Time
+		var hasLogger bool
+		handler := stack(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deadline, _ = r.Context().Deadline()
+			_, hasLogger = r.Context().Value(LoggerKey).(*zap.Logger)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+
+		assert.False(t, deadline.IsZero(), "the timeout applies inside the logging middleware")
+		assert.True(t, hasLogger)
+		assert.Len(t, recorded.FilterMessage(defaultRequestMessage).All(), 1)
+		assert.Len(t, recorded.FilterMessage(defaultResponseMessage).All(), 1)
+		recorded.TakeAll()
+	})
+
+	t.Run("Recovers from panics", func(t *testing.T) {
+		handler := stack(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}))
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/panic", nil)
+		req.Header.Set(HeaderLogID, "panic-id")
+		require.NotPanics(t, func() {
+			handler.ServeHTTP(rec, req)
+		})
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		panics := recorded.FilterMessage("Panic recovered").All()
+		require.Len(t, panics, 1)
+		assert.Equal(t, "boom", panics[0].ContextMap()["panic"])
+		assert.Equal(t, "/panic", panics[0].ContextMap()["path"])
+		assert.Equal(t, "panic-id", panics[0].ContextMap()["log_id"])
+		assert.NotEmpty(t, panics[0].ContextMap()["stack"])
+
+		// The 500 is logged like any other response
+		responses := recorded.FilterMessage(defaultResponseMessage).All()
+		require.Len(t, responses, 1)
+		assert.EqualValues(t, http.StatusInternalServerError, responses[0].ContextMap()["status"])
+		recorded.TakeAll()
+	})
+}
+
+func TestRecovery_KeepsStartedResponse(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("partial"))
+		panic("boom")
+	})
+
+	for name, handler := range map[string]http.Handler{
+		"Standalone":           Recovery(logger)(panicking),
+		"Inside ServerLogging": DefaultStack(logger, &Config{})(panicking),
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			require.NotPanics(t, func() {
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/partial", nil))
+			})
+
+			// No 500 status or error text is written over the response already sent
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			assert.Equal(t, "partial", rec.Body.String())
+			assert.Len(t, recorded.FilterMessage("Panic recovered").All(), 1)
+			recorded.TakeAll()
+		})
+	}
+}
+
+func TestRecovery_RepanicsAbortHandler(t *testing.T) {
+	handler := Recovery(zap.NewNop())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
@@ -0,0 +1,95 @@
+package smartlog
+
+import (
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// FieldNamingSnake emits field keys in snake_case (e.g. "log_id"). This is the default.
+	FieldNamingSnake = "snake"
+	// FieldNamingCamel emits field keys in camelCase (e.g. "logId").
+	FieldNamingCamel = "camel"
+)
+
+// fieldNamer resolves the final key of a log field from its snake_case name.
+type fieldNamer struct {
+	camel     bool
+	overrides map[string]string
+}
+
+// newFieldNamer returns a fieldNamer for the configuration, or nil if keys are logged unchanged.
+func newFieldNamer(cfg *Config) *fieldNamer {
+	camel := strings.EqualFold(cfg.FieldNaming, FieldNamingCamel)
+	if !camel && len(cfg.FieldNames) == 0 {
+		return nil
+	}
+	return &fieldNamer{camel: camel, overrides: cfg.FieldNames}
+}
+
+// name returns the key to log for the given snake_case key.
+// Explicit overrides take precedence over the naming scheme.
+func (n *fieldNamer) name(key string) string {
+	if override, ok := n.overrides[key]; ok {
+		return override
+	}
+	if n.camel {
+		return snakeToCamel(key)
+	}
+	return key
+}
+
+// snakeToCamel converts a snake_case key such as "latency_ms" into "latencyMs".
+func snakeToCamel(key string) string {
+	if !strings.Contains(key, "_") {
+		return key
+	}
+	parts := strings.Split(key, "_")
+	var b strings.Builder
+	b.WriteString(parts[0])
+	for _, part := range parts[1:] {
+		if part == "" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]))
+		b.WriteString(part[1:])
+	}
+	return b.String()
+}
+
+// fieldNamingCore is a zapcore.Core that renames top-level field keys before
+// passing them to the wrapped core. Fields after a zap.Namespace are top-level fields too,
+// but the keys that zap.Object, zap.Inline and zap.Array marshalers write, like those of
+// the request and response objects, keep the names they are written with.
+type fieldNamingCore struct {
+	zapcore.Core
+	namer *fieldNamer
+}
+
+// With renames the fields before adding them to the wrapped core's context.
+func (c *fieldNamingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &fieldNamingCore{Core: c.Core.With(c.rename(fields)), namer: c.namer}
+}
+
+// Check adds this core (rather than the wrapped one) so that Write renames the fields.
+func (c *fieldNamingCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+// Write renames the fields before writing the entry to the wrapped core.
+func (c *fieldNamingCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(ent, c.rename(fields))
+}
+
+func (c *fieldNamingCore) rename(fields []zapcore.Field) []zapcore.Field {
+	renamed := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		field.Key = c.namer.name(field.Key)
+		renamed[i] = field
+	}
+	return renamed
+}
@@ -0,0 +1,93 @@
+package smartlog
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Option customizes the loggers and middleware created by this package. Options cover
+// programmatic settings that don't belong in the YAML configuration.
+type Option func(*options)
+
+type options struct {
+	clock      clock
+	random     func() float64
+	extraCores []zapcore.Core
+	metrics    *Metrics
+	rotator    *Rotator
+}
+
+// newOptions applies opts over the defaults.
+func newOptions(opts []Option) options {
+	o := options{clock: realClock{}, random: rand.Float64}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithClock makes latencies and rate limits use now instead of the system clock.
+// It is meant for tests that need exact latency values.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		if now != nil {
+			o.clock = funcClock(now)
+		}
+	}
+}
+
+// WithRandom makes sampling decisions use random, which returns values in [0, 1), instead
+// of math/rand. It is meant for tests that need deterministic sampling.
+func WithRandom(random func() float64) Option {
+	return func(o *options) {
+		if random != nil {
+			o.random = random
+		}
+	}
+}
+
+// WithExtraCore makes NewLogger also write every entry to core, for example one created
+// by NewRemoteCore. Field naming settings apply to it like to the built-in cores.
+func WithExtraCore(core zapcore.Core) Option {
+	return func(o *options) {
+		if core != nil {
+			o.extraCores = append(o.extraCores, core)
+		}
+	}
+}
+
+// WithMetrics makes the loggers and middleware count what they log into m. Pass it to
+// NewLogger for the entry and byte counts, and to ServerLogging and NewClientLogger for
+// the redaction and truncation counts.
+func WithMetrics(m *Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// WithRotator makes NewLogger hand its log file to r, so it can be rotated on demand.
+func WithRotator(r *Rotator) Option {
+	return func(o *options) {
+		o.rotator = r
+	}
+}
+
+// clock is the source of time for latency measurements.
+type clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+}
+
+// realClock is the system clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time                  { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+// funcClock is a clock backed by a function returning the current time.
+type funcClock func() time.Time
+
+func (c funcClock) Now() time.Time                  { return c() }
+func (c funcClock) Since(t time.Time) time.Duration { return c().Sub(t) }
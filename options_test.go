@@ -0,0 +1,70 @@
+package smartlog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// fakeClock is a manually advanced clock for WithClock.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestWithClock_ServerLatency(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+
+	handler := ServerLogging(zap.New(core), &Config{}, WithClock(clock.Now))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clock.Advance(250 * time.Millisecond)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+	responses := recorded.FilterMessage(defaultResponseMessage).All()
+	require.Len(t, responses, 1)
+	assert.Equal(t, int64(250), responses[0].ContextMap()["latency_ms"])
+	assert.Equal(t, "200ms-1s", responses[0].ContextMap()["latency_bucket"])
+}
+
+func TestWithClock_ClientLatency(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+
+	next := &mockRoundTripper{roundTripFunc: func(r *http.Request) (*http.Response, error) {
+		clock.Advance(42 * time.Millisecond)
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}}
+	req, _ := http.NewRequest(http.MethodGet, "http://downstream.example.com/data", nil)
+	_, err := NewClientLogger(next, zap.New(core), &Config{}, WithClock(clock.Now)).RoundTrip(req)
+	require.NoError(t, err)
+
+	responses := recorded.FilterMessage(defaultClientResponseMessage).All()
+	require.Len(t, responses, 1)
+	assert.Equal(t, int64(42), responses[0].ContextMap()["latency_ms"])
+}
+
+func TestWithClock_GormSlowQuery(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	gormLogger := NewGormLogger(zap.New(core), GormConfig{}, WithClock(clock.Now))
+
+	begin := clock.Now()
+	clock.Advance(201 * time.Millisecond)
+	gormLogger.Trace(context.Background(), begin, func() (string, int64) { return "SELECT 1", 1 }, nil)
+
+	logs := recorded.All()
+	require.Len(t, logs, 1)
+	assert.Equal(t, "GORM Trace (Slow Query)", logs[0].Message)
+	assert.Equal(t, 201*time.Millisecond, logs[0].ContextMap()["latency"])
+}
@@ -0,0 +1,335 @@
+// Package otel provides a zapcore.Core that exports smartlog entries as OpenTelemetry
+// log records to an OTLP/HTTP collector endpoint.
+//
+// It speaks the OTLP/HTTP JSON protocol directly, so using it doesn't pull the
+// OpenTelemetry SDK into the smartlog module.
+package otel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// defaultBatchSize is the number of records buffered before they are exported.
+	defaultBatchSize = 100
+	// defaultQueueSize is the number of full batches waiting for export.
+	defaultQueueSize = 10
+	// defaultTimeout bounds a single export request.
+	defaultTimeout = 5 * time.Second
+	// defaultFlushInterval is how long a partial batch waits before it is exported.
+	defaultFlushInterval = time.Second
+)
+
+// Config configures the OTLP exporter core.
+type Config struct {
+	Endpoint      string               // collector logs endpoint, e.g. "http://localhost:4318/v1/logs"
+	Headers       map[string]string    // extra headers sent with every export, e.g. authentication
+	ServiceName   string               // exported as the service.name resource attribute
+	BatchSize     int                  // records buffered before an export; defaults to 100
+	QueueSize     int                  // full batches waiting for export before new ones are dropped; defaults to 10
+	Timeout       time.Duration        // timeout of a single export request; defaults to 5s
+	FlushInterval time.Duration        // maximum time a partial batch waits; defaults to 1s
+	Level         zapcore.LevelEnabler // minimum level exported; defaults to Info
+	Client        *http.Client         // HTTP client used for exports; defaults to one using Timeout
+}
+
+// NewCore creates a zapcore.Core that exports entries as OTLP log records. Records are
+// buffered, and exported from a background goroutine once BatchSize records are pending
+// or FlushInterval has passed, so logging never waits on the collector. While it is slow or down, up to QueueSize batches wait and
+// further ones are dropped; the next Sync reports the dropped records and failed exports,
+// and exports all remaining records. Call Close on shutdown to export the remaining
+// records and stop the goroutine.
+//
+// Fields named "trace_id" and "span_id" are mapped onto the record's trace context;
+// all other fields become attributes.
+func NewCore(cfg Config) *Core {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultFlushInterval
+	}
+	if cfg.Level == nil {
+		cfg.Level = zapcore.InfoLevel
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: cfg.Timeout}
+	}
+	e := &exporter{
+		cfg:     cfg,
+		batches: make(chan []logRecord, cfg.QueueSize),
+		flushes: make(chan chan error),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go e.run()
+	return &Core{
+		LevelEnabler: cfg.Level,
+		exporter:     e,
+	}
+}
+
+// Core encodes entries into OTLP log records and hands them to the shared exporter.
+type Core struct {
+	zapcore.LevelEnabler
+	exporter *exporter
+	fields   []zapcore.Field
+}
+
+// With returns a copy of the core carrying the additional fields.
+func (c *Core) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(append([]zapcore.Field(nil), c.fields...), fields...)
+	return &clone
+}
+
+// Check adds the core to the checked entry if the level is enabled.
+func (c *Core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+// Write converts the entry to a log record and buffers it for export.
+func (c *Core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range c.fields {
+		field.AddTo(enc)
+	}
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+
+	rec := logRecord{
+		TimeUnixNano:   strconv.FormatInt(ent.Time.UnixNano(), 10),
+		SeverityNumber: severityNumber(ent.Level),
+		SeverityText:   ent.Level.CapitalString(),
+		Body:           anyValue{StringValue: &ent.Message},
+	}
+	if traceID, ok := enc.Fields["trace_id"].(string); ok {
+		rec.TraceID = traceID
+		delete(enc.Fields, "trace_id")
+	}
+	if spanID, ok := enc.Fields["span_id"].(string); ok {
+		rec.SpanID = spanID
+		delete(enc.Fields, "span_id")
+	}
+	rec.Attributes = toKeyValues(enc.Fields)
+
+	c.exporter.add(rec)
+	return nil
+}
+
+// Sync exports all buffered records, and reports the records dropped and the exports
+// that failed in the background since the last Sync.
+func (c *Core) Sync() error {
+	return c.exporter.flush()
+}
+
+// Close exports all buffered records like Sync, then stops the background goroutine. The
+// core must not be used afterwards.
+func (c *Core) Close() error {
+	return c.exporter.close()
+}
+
+// exporter batches log records and posts them to the collector. A single goroutine does
+// the exporting, so a slow collector fills the queue instead of blocking the callers.
+type exporter struct {
+	cfg       Config
+	mu        sync.Mutex
+	pending   []logRecord
+	batches   chan []logRecord
+	flushes   chan chan error
+	stop      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+	dropped   atomic.Int64
+
+	// failures counts the background exports that failed since the last flush, and
+	// lastErr is the latest of them.
+	failMu   sync.Mutex
+	failures int
+	lastErr  error
+}
+
+// add buffers a record, queueing the batch for export once it is full. A full batch is
+// dropped if the queue is full too.
+func (e *exporter) add(rec logRecord) {
+	e.mu.Lock()
+	e.pending = append(e.pending, rec)
+	var batch []logRecord
+	if len(e.pending) >= e.cfg.BatchSize {
+		batch = e.pending
+		e.pending = nil
+	}
+	e.mu.Unlock()
+
+	if batch == nil {
+		return
+	}
+	select {
+	case e.batches <- batch:
+	default:
+		e.dropped.Add(int64(len(batch)))
+	}
+}
+
+// flush waits until the queued batches and the pending records have been exported.
+func (e *exporter) flush() error {
+	done := make(chan error)
+	select {
+	case e.flushes <- done:
+	case <-e.stopped:
+		return errors.New("otel: core is closed")
+	}
+	err := <-done
+
+	if dropped := e.dropped.Swap(0); dropped > 0 {
+		err = errors.Join(err, fmt.Errorf("otel: export queue full, dropped %d records", dropped))
+	}
+	e.failMu.Lock()
+	if e.failures > 0 {
+		err = errors.Join(err, fmt.Errorf("otel: %d background exports failed, last: %w", e.failures, e.lastErr))
+		e.failures, e.lastErr = 0, nil
+	}
+	e.failMu.Unlock()
+	return err
+}
+
+func (e *exporter) close() error {
+	err := errors.New("otel: core is closed")
+	e.closeOnce.Do(func() {
+		err = e.flush()
+		close(e.stop)
+		<-e.stopped
+	})
+	return err
+}
+
+// exportInBackground exports a batch outside of a flush, recording a failure for the next
+// flush.
+func (e *exporter) exportInBackground(records []logRecord) {
+	if err := e.export(records); err != nil {
+		e.failMu.Lock()
+		e.failures++
+		e.lastErr = err
+		e.failMu.Unlock()
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(e.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case batch := <-e.batches:
+			e.exportInBackground(batch)
+		case <-ticker.C:
+			e.mu.Lock()
+			records := e.pending
+			e.pending = nil
+			e.mu.Unlock()
+			if len(records) > 0 {
+				e.exportInBackground(records)
+			}
+		case done := <-e.flushes:
+			var errs []error
+			for drained := false; !drained; {
+				select {
+				case batch := <-e.batches:
+					errs = append(errs, e.export(batch))
+				default:
+					drained = true
+				}
+			}
+			e.mu.Lock()
+			records := e.pending
+			e.pending = nil
+			e.mu.Unlock()
+			if len(records) > 0 {
+				errs = append(errs, e.export(records))
+			}
+			done <- errors.Join(errs...)
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+func (e *exporter) export(records []logRecord) error {
+	var resourceAttrs []keyValue
+	if e.cfg.ServiceName != "" {
+		name := e.cfg.ServiceName
+		resourceAttrs = append(resourceAttrs, keyValue{Key: "service.name", Value: anyValue{StringValue: &name}})
+	}
+
+	payload, err := json.Marshal(exportRequest{
+		ResourceLogs: []resourceLogs{{
+			Resource: resource{Attributes: resourceAttrs},
+			ScopeLogs: []scopeLogs{{
+				Scope:      scope{Name: "smartlog"},
+				LogRecords: records,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.cfg.Endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.cfg.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("otel: collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// severityNumber maps a zap level onto the OpenTelemetry severity number range.
+func severityNumber(level zapcore.Level) int {
+	switch level {
+	case zapcore.DebugLevel:
+		return 5 // DEBUG
+	case zapcore.InfoLevel:
+		return 9 // INFO
+	case zapcore.WarnLevel:
+		return 13 // WARN
+	case zapcore.ErrorLevel:
+		return 17 // ERROR
+	case zapcore.DPanicLevel:
+		return 19 // ERROR3
+	default:
+		return 21 // FATAL
+	}
+}
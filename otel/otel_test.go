@@ -0,0 +1,167 @@
+package otel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// stubReceiver is a minimal OTLP/HTTP logs receiver that records export requests.
+type stubReceiver struct {
+	mu       sync.Mutex
+	requests []exportRequest
+	headers  []http.Header
+}
+
+func (s *stubReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req exportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.headers = append(s.headers, r.Header.Clone())
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestCore_ExportsLogRecords(t *testing.T) {
+	receiver := &stubReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	core := NewCore(Config{
+		Endpoint:    server.URL + "/v1/logs",
+		Headers:     map[string]string{"Authorization": "Bearer collector-token"},
+		ServiceName: "test-service",
+	})
+	defer core.Close()
+	logger := zap.New(core).With(zap.String("log_id", "otel-log-id"))
+
+	logger.Debug("below the export level")
+	logger.Warn("downstream slow",
+		zap.String("trace_id", "4bf92f3577b34da6a3ce929d0e0e4736"),
+		zap.String("span_id", "00f067aa0ba902b7"),
+		zap.Int("status", 504),
+	)
+	require.Empty(t, receiver.requests, "Records should be buffered until Sync")
+	require.NoError(t, logger.Sync())
+
+	require.Len(t, receiver.requests, 1)
+	assert.Equal(t, "Bearer collector-token", receiver.headers[0].Get("Authorization"))
+
+	resourceLogs := receiver.requests[0].ResourceLogs
+	require.Len(t, resourceLogs, 1)
+	require.Len(t, resourceLogs[0].Resource.Attributes, 1)
+	assert.Equal(t, "service.name", resourceLogs[0].Resource.Attributes[0].Key)
+	assert.Equal(t, "test-service", *resourceLogs[0].Resource.Attributes[0].Value.StringValue)
+
+	records := resourceLogs[0].ScopeLogs[0].LogRecords
+	require.Len(t, records, 1, "Debug entry should not be exported")
+	record := records[0]
+	assert.Equal(t, 13, record.SeverityNumber)
+	assert.Equal(t, "WARN", record.SeverityText)
+	assert.Equal(t, "downstream slow", *record.Body.StringValue)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", record.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", record.SpanID)
+
+	attributes := make(map[string]anyValue)
+	for _, kv := range record.Attributes {
+		attributes[kv.Key] = kv.Value
+	}
+	assert.Equal(t, "otel-log-id", *attributes["log_id"].StringValue)
+	assert.Equal(t, "504", *attributes["status"].IntValue)
+	assert.NotContains(t, attributes, "trace_id")
+}
+
+func TestCore_ExportsWhenBatchIsFull(t *testing.T) {
+	receiver := &stubReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	core := NewCore(Config{Endpoint: server.URL, BatchSize: 2})
+	defer core.Close()
+	logger := zap.New(core)
+	logger.Info("first")
+	logger.Error("second")
+
+	// The full batch is exported in the background
+	assert.Eventually(t, func() bool {
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		return len(receiver.requests) == 1
+	}, time.Second, 5*time.Millisecond)
+	records := receiver.requests[0].ResourceLogs[0].ScopeLogs[0].LogRecords
+	require.Len(t, records, 2)
+	assert.Equal(t, 9, records[0].SeverityNumber)
+	assert.Equal(t, 17, records[1].SeverityNumber)
+}
+
+func TestCore_ExportsPartialBatchAfterFlushInterval(t *testing.T) {
+	receiver := &stubReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	core := NewCore(Config{Endpoint: server.URL, BatchSize: 100, FlushInterval: 20 * time.Millisecond})
+	defer core.Close()
+	zap.New(core).Info("quiet service")
+
+	// The partial batch is exported without a Sync
+	assert.Eventually(t, func() bool {
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		return len(receiver.requests) == 1
+	}, time.Second, 5*time.Millisecond)
+	records := receiver.requests[0].ResourceLogs[0].ScopeLogs[0].LogRecords
+	require.Len(t, records, 1)
+	assert.Equal(t, "quiet service", *records[0].Body.StringValue)
+}
+
+func TestCore_DoesNotBlockOnSlowCollector(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	core := NewCore(Config{Endpoint: server.URL, BatchSize: 1, QueueSize: 1})
+	defer core.Close()
+	logger := zap.New(core)
+
+	// The first batch is being exported, the second waits in the queue and the rest are dropped
+	start := time.Now()
+	for range 5 {
+		logger.Info("entry")
+	}
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "logging must not wait on the collector")
+
+	close(release)
+	err := logger.Sync()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "dropped")
+	assert.Contains(t, err.Error(), "status 503")
+	assert.NoError(t, logger.Sync(), "failures are only reported once")
+}
+
+func TestCore_Close(t *testing.T) {
+	receiver := &stubReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	core := NewCore(Config{Endpoint: server.URL})
+	zap.New(core).Info("buffered")
+
+	require.NoError(t, core.Close())
+	require.Len(t, receiver.requests, 1, "Close exports the buffered records")
+	assert.Error(t, core.Close())
+	assert.Error(t, core.Sync(), "a closed core can't export")
+}
@@ -0,0 +1,137 @@
+package otel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// The types below mirror the OTLP/HTTP JSON encoding of the logs export request.
+
+type exportRequest struct {
+	ResourceLogs []resourceLogs `json:"resourceLogs"`
+}
+
+type resourceLogs struct {
+	Resource  resource    `json:"resource"`
+	ScopeLogs []scopeLogs `json:"scopeLogs"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes,omitempty"`
+}
+
+type scopeLogs struct {
+	Scope      scope       `json:"scope"`
+	LogRecords []logRecord `json:"logRecords"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type logRecord struct {
+	TimeUnixNano   string     `json:"timeUnixNano"`
+	SeverityNumber int        `json:"severityNumber"`
+	SeverityText   string     `json:"severityText"`
+	Body           anyValue   `json:"body"`
+	Attributes     []keyValue `json:"attributes,omitempty"`
+	TraceID        string     `json:"traceId,omitempty"`
+	SpanID         string     `json:"spanId,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string       `json:"stringValue,omitempty"`
+	BoolValue   *bool         `json:"boolValue,omitempty"`
+	IntValue    *string       `json:"intValue,omitempty"` // int64 is a string in proto3 JSON
+	DoubleValue *float64      `json:"doubleValue,omitempty"`
+	ArrayValue  *arrayValue   `json:"arrayValue,omitempty"`
+	KvlistValue *keyValueList `json:"kvlistValue,omitempty"`
+}
+
+type arrayValue struct {
+	Values []anyValue `json:"values"`
+}
+
+type keyValueList struct {
+	Values []keyValue `json:"values"`
+}
+
+// toKeyValues converts encoded zap fields into OTLP attributes, sorted by key.
+func toKeyValues(fields map[string]interface{}) []keyValue {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	kvs := make([]keyValue, 0, len(keys))
+	for _, key := range keys {
+		kvs = append(kvs, keyValue{Key: key, Value: toAnyValue(fields[key])})
+	}
+	return kvs
+}
+
+// toAnyValue converts a value produced by zapcore.MapObjectEncoder into an OTLP value.
+// Reflected values (e.g. http.Header or json.RawMessage) are converted via their JSON form.
+func toAnyValue(v interface{}) anyValue {
+	switch val := v.(type) {
+	case nil:
+		return anyValue{}
+	case string:
+		return anyValue{StringValue: &val}
+	case bool:
+		return anyValue{BoolValue: &val}
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		s := fmt.Sprint(val)
+		return anyValue{IntValue: &s}
+	case float32:
+		f := float64(val)
+		return anyValue{DoubleValue: &f}
+	case float64:
+		return anyValue{DoubleValue: &val}
+	case []byte:
+		s := string(val)
+		return anyValue{StringValue: &s}
+	case map[string]interface{}:
+		return anyValue{KvlistValue: &keyValueList{Values: toKeyValues(val)}}
+	case []interface{}:
+		values := make([]anyValue, 0, len(val))
+		for _, item := range val {
+			values = append(values, toAnyValue(item))
+		}
+		return anyValue{ArrayValue: &arrayValue{Values: values}}
+	case json.Number:
+		if _, err := strconv.ParseInt(string(val), 10, 64); err == nil {
+			s := string(val)
+			return anyValue{IntValue: &s}
+		}
+		f, _ := val.Float64()
+		return anyValue{DoubleValue: &f}
+	case fmt.Stringer:
+		s := val.String()
+		return anyValue{StringValue: &s}
+	}
+
+	// Fall back to the JSON representation of anything else
+	raw, err := json.Marshal(v)
+	if err != nil {
+		s := fmt.Sprint(v)
+		return anyValue{StringValue: &s}
+	}
+	var decoded interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&decoded); err != nil {
+		s := string(raw)
+		return anyValue{StringValue: &s}
+	}
+	return toAnyValue(decoded)
+}
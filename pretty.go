@@ -0,0 +1,59 @@
+package smartlog
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// prettyBufferPool holds the buffers of the entries indented by prettyJSONEncoder.
+var prettyBufferPool = buffer.NewPool()
+
+// prettyJSONEncoder indents the JSON written by the wrapped encoder, for Config.PrettyJSON.
+// With console set, only the fields object after the message is indented. A stack trace
+// following the entry is left as it is.
+type prettyJSONEncoder struct {
+	zapcore.Encoder
+	console bool
+}
+
+// Clone keeps indenting the entries of the clone.
+func (e prettyJSONEncoder) Clone() zapcore.Encoder {
+	return prettyJSONEncoder{Encoder: e.Encoder.Clone(), console: e.console}
+}
+
+// EncodeEntry encodes the entry with the wrapped encoder and indents its JSON. Entries the
+// indentation fails on are returned as encoded.
+func (e prettyJSONEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	buf, err := e.Encoder.EncodeEntry(ent, fields)
+	if err != nil {
+		return buf, err
+	}
+	line := buf.Bytes()
+
+	// The JSON ends with the first line; JSON strings never hold a raw newline or tab
+	end := bytes.IndexByte(line, '\n')
+	if end < 0 {
+		end = len(line)
+	}
+	start := 0
+	if e.console {
+		start = bytes.LastIndex(line[:end], []byte("\t{")) + 1
+		if start == 0 {
+			return buf, nil
+		}
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, line[start:end], "", "  "); err != nil {
+		return buf, nil
+	}
+	out := prettyBufferPool.Get()
+	out.Write(line[:start])
+	out.Write(indented.Bytes())
+	out.Write(line[end:])
+	buf.Free()
+	return out, nil
+}
@@ -0,0 +1,59 @@
+package smartlog
+
+import (
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Standard fields of the server's request and response logs that Config.LogFields can select.
+const (
+	LogFieldMethod   = "method"
+	LogFieldProto    = "proto"
+	LogFieldPath     = "path"
+	LogFieldStatus   = "status"
+	LogFieldLatency  = "latency" // latency_ms and latency_bucket
+	LogFieldHeaders  = "headers" // headers, and the fields read from them: accept, content_type and geo_*
+	LogFieldReqBody  = "req_body"
+	LogFieldRespBody = "resp_body"
+	LogFieldLogID    = "log_id"
+)
+
+// logFieldNames lists the valid values of Config.LogFields.
+var logFieldNames = []string{
+	LogFieldMethod, LogFieldProto, LogFieldPath, LogFieldStatus, LogFieldLatency,
+	LogFieldHeaders, LogFieldReqBody, LogFieldRespBody, LogFieldLogID,
+}
+
+// fieldProjection is the set of standard fields logged by the server middleware. A nil
+// projection includes every field.
+type fieldProjection map[string]struct{}
+
+// newFieldProjection returns the projection for Config.LogFields, or nil when it's empty.
+func newFieldProjection(fields []string) fieldProjection {
+	if len(fields) == 0 {
+		return nil
+	}
+	p := make(fieldProjection, len(fields))
+	for _, name := range fields {
+		p[strings.ToLower(strings.TrimSpace(name))] = struct{}{}
+	}
+	return p
+}
+
+// includes reports whether the standard field name is logged.
+func (p fieldProjection) includes(name string) bool {
+	if p == nil {
+		return true
+	}
+	_, ok := p[name]
+	return ok
+}
+
+// field returns f if the standard field name is logged, or zap.Skip() otherwise.
+func (p fieldProjection) field(name string, f zap.Field) zap.Field {
+	if !p.includes(name) {
+		return zap.Skip()
+	}
+	return f
+}
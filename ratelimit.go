@@ -0,0 +1,170 @@
+package smartlog
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultRateLimitMaxClients is the default of RateLimitConfig.MaxClients.
+const defaultRateLimitMaxClients = 10000
+
+// RateLimitConfig configures the RateLimit middleware.
+type RateLimitConfig struct {
+	RequestsPerSecond float64     // sustained requests per second allowed per client IP
+	Burst             int         // requests a client may make at once; defaults to RequestsPerSecond rounded up
+	TrustProxyHeaders bool        // take the client IP from the last X-Forwarded-For entry, or X-Real-IP, set by a reverse proxy
+	MaxClients        int         // client IPs tracked before idle ones, then the least recently seen, are evicted; defaults to 10000
+	Logger            *zap.Logger // logs rejected requests when the context carries no request logger; defaults to zap.L()
+}
+
+// RateLimit is a middleware that limits the request rate of each client IP with a token
+// bucket. Requests over the limit are answered with 429 Too Many Requests and logged as a
+// warning with rate_limited: true, the client IP and its current rate. It is independent
+// of ServerLogging; place it inside ServerLogging for rejected requests to carry the
+// log_id and get a response log, or outside to shed load before anything is logged. A
+// RequestsPerSecond of 0 or less disables it.
+func RateLimit(cfg RateLimitConfig, opts ...Option) func(http.Handler) http.Handler {
+	o := newOptions(opts)
+	if cfg.Burst <= 0 {
+		cfg.Burst = int(math.Ceil(cfg.RequestsPerSecond))
+	}
+	if cfg.MaxClients <= 0 {
+		cfg.MaxClients = defaultRateLimitMaxClients
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = zap.L()
+	}
+	limiter := &rateLimiter{cfg: cfg, clock: o.clock, buckets: make(map[string]*tokenBucket)}
+
+	return func(next http.Handler) http.Handler {
+		if cfg.RequestsPerSecond <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := clientIP(r, cfg.TrustProxyHeaders)
+			allowed, rate := limiter.allow(ip)
+			if !allowed {
+				logger := cfg.Logger
+				if ctxLogger, ok := r.Context().Value(LoggerKey).(*zap.Logger); ok {
+					logger = ctxLogger
+				}
+				logger.Warn("Rate limit exceeded",
+					zap.Bool("rate_limited", true),
+					zap.String("client_ip", ip),
+					zap.Int("rate_rps", rate),
+					zap.Float64("limit_rps", cfg.RequestsPerSecond),
+					zap.String("method", r.Method),
+					zap.String("path", r.URL.Path),
+				)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(1/cfg.RequestsPerSecond))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimiter holds a token bucket per client IP.
+type rateLimiter struct {
+	cfg     RateLimitConfig
+	clock   clock
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket is the state of a client: its tokens, and the requests it made in the current
+// one second window, reported as its rate.
+type tokenBucket struct {
+	tokens      float64
+	last        time.Time
+	windowStart time.Time
+	windowCount int
+}
+
+// allow takes a token from the bucket of ip, and returns whether there was one and the
+// number of requests ip made in the last second, this one included.
+func (l *rateLimiter) allow(ip string) (bool, int) {
+	now := l.clock.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		if len(l.buckets) >= l.cfg.MaxClients {
+			l.evictIdle(now)
+		}
+		if len(l.buckets) >= l.cfg.MaxClients {
+			l.evictOldest()
+		}
+		b = &tokenBucket{tokens: float64(l.cfg.Burst), last: now, windowStart: now}
+		l.buckets[ip] = b
+	}
+
+	b.tokens = min(float64(l.cfg.Burst), b.tokens+now.Sub(b.last).Seconds()*l.cfg.RequestsPerSecond)
+	b.last = now
+	if now.Sub(b.windowStart) >= time.Second {
+		b.windowStart, b.windowCount = now, 0
+	}
+	b.windowCount++
+
+	if b.tokens < 1 {
+		return false, b.windowCount
+	}
+	b.tokens--
+	return true, b.windowCount
+}
+
+// evictIdle forgets the clients whose bucket has refilled, as they are no different from new
+// ones. Callers must hold l.mu.
+func (l *rateLimiter) evictIdle(now time.Time) {
+	for ip, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.cfg.RequestsPerSecond >= float64(l.cfg.Burst) {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+// evictOldest forgets the client seen least recently when every client is still active.
+// Clients that keep sending requests, limited ones included, keep their bucket, so new IPs
+// can't reset it. Callers must hold l.mu.
+func (l *rateLimiter) evictOldest() {
+	var oldestIP string
+	var oldest time.Time
+	for ip, b := range l.buckets {
+		if oldestIP == "" || b.last.Before(oldest) {
+			oldestIP, oldest = ip, b.last
+		}
+	}
+	delete(l.buckets, oldestIP)
+}
+
+// clientIP returns the IP of the client that sent r: the last address of X-Forwarded-For,
+// or X-Real-IP, when trustProxy is set, else the remote address of the connection. The
+// last address is the one the trusted proxy appended; the ones before it come from the
+// client and can be spoofed.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			forwarded := values[len(values)-1]
+			if ip := strings.TrimSpace(forwarded[strings.LastIndexByte(forwarded, ',')+1:]); ip != "" {
+				return ip
+			}
+		}
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,118 @@
+package smartlog
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRateLimit(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mw := RateLimit(RateLimitConfig{RequestsPerSecond: 2, Burst: 3, Logger: zap.New(core)}, WithClock(func() time.Time { return now }))
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("Client exceeding the limit", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, http.StatusOK, send("203.0.113.7:50000"), "request %d is within the burst", i+1)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, send("203.0.113.7:50001"))
+
+		logs := recorded.TakeAll()
+		require.Len(t, logs, 1)
+		assert.Equal(t, zapcore.WarnLevel, logs[0].Level)
+		fields := logs[0].ContextMap()
+		assert.Equal(t, true, fields["rate_limited"])
+		assert.Equal(t, "203.0.113.7", fields["client_ip"])
+		assert.Equal(t, int64(4), fields["rate_rps"])
+
+		// Tokens refill at the configured rate
+		now = now.Add(500 * time.Millisecond)
+		assert.Equal(t, http.StatusOK, send("203.0.113.7:50002"))
+		assert.Equal(t, http.StatusTooManyRequests, send("203.0.113.7:50003"))
+		recorded.TakeAll()
+	})
+
+	t.Run("Client staying under the limit", func(t *testing.T) {
+		for i := 0; i < 6; i++ {
+			assert.Equal(t, http.StatusOK, send("198.51.100.2:40000"))
+			now = now.Add(500 * time.Millisecond)
+		}
+		assert.Zero(t, recorded.Len())
+	})
+}
+
+func TestRateLimit_IgnoresSpoofedForwardedFor(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mw := RateLimit(RateLimitConfig{RequestsPerSecond: 1, Burst: 2, TrustProxyHeaders: true}, WithClock(func() time.Time { return now }))
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// The client forges a new leading address for every request; the proxy appends the real one
+	var codes []int
+	for _, spoofed := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", spoofed+", 203.0.113.7")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+}
+
+func TestRateLimit_FloodOfNewClientsKeepsLimitedOnes(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mw := RateLimit(RateLimitConfig{RequestsPerSecond: 1, Burst: 2, MaxClients: 3}, WithClock(func() time.Time { return now }))
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func(remoteAddr string) int {
+		now = now.Add(time.Millisecond)
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	send("203.0.113.7:50000")
+	send("203.0.113.7:50000")
+	require.Equal(t, http.StatusTooManyRequests, send("203.0.113.7:50000"))
+
+	// Far more new IPs than MaxClients arrive while the limited client keeps trying
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, http.StatusOK, send(fmt.Sprintf("192.0.2.%d:40000", i+1)))
+		assert.Equal(t, http.StatusTooManyRequests, send("203.0.113.7:50000"), "the limited client stays limited after %d new clients", i+1)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.2")
+
+	assert.Equal(t, "10.0.0.1", clientIP(req, false), "Proxy headers are ignored unless trusted")
+	assert.Equal(t, "10.0.0.2", clientIP(req, true), "The address appended by the proxy is used")
+
+	req.Header.Add("X-Forwarded-For", "198.51.100.9")
+	assert.Equal(t, "198.51.100.9", clientIP(req, true), "The last header is the proxy's")
+
+	req.Header.Del("X-Forwarded-For")
+	req.Header.Set("X-Real-IP", "198.51.100.2")
+	assert.Equal(t, "198.51.100.2", clientIP(req, true))
+}
@@ -1,28 +1,44 @@
 package smartlog
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"regexp"
 	"strings"
 )
 
 const redactionPlaceholder = "[REDACTED]"
 
-// redactHeaders creates a copy of http.Header and redacts sensitive keys.
-func redactHeaders(headers http.Header, keysToRedact []string) http.Header {
-	if len(keysToRedact) == 0 {
+// redactHeaders creates a copy of http.Header, redacting the headers in keysToRedact and
+// leaving out the ones in keysToDrop.
+//
+// When there is nothing to redact or drop, the original headers are returned as-is so the
+// common path stays allocation free. Sharing is only safe when the log entry is encoded
+// synchronously, before the handler gets a chance to mutate the headers. When snapshot
+// is set (asynchronous cores), a deep copy is always returned instead.
+func redactHeaders(headers http.Header, keysToRedact, keysToDrop []string, snapshot bool) http.Header {
+	if len(keysToRedact) == 0 && len(keysToDrop) == 0 {
+		if snapshot {
+			return headers.Clone()
+		}
 		return headers
 	}
 
 	redactedHeaders := make(http.Header)
-	keyMap := make(map[string]struct{})
-	for _, key := range keysToRedact {
-		keyMap[strings.ToLower(key)] = struct{}{}
-	}
+	keyMap := lowerKeySet(keysToRedact)
+	dropMap := lowerKeySet(keysToDrop)
 
 	for key, values := range headers {
+		if _, drop := dropMap[strings.ToLower(key)]; drop {
+			continue
+		}
 		if _, exists := keyMap[strings.ToLower(key)]; exists {
 			redactedHeaders[key] = []string{redactionPlaceholder}
+		} else if snapshot {
+			redactedHeaders[key] = append([]string(nil), values...)
 		} else {
 			redactedHeaders[key] = values
 		}
@@ -30,61 +46,210 @@ func redactHeaders(headers http.Header, keysToRedact []string) http.Header {
 	return redactedHeaders
 }
 
-// redact takes a map representing a JSON object and a list of keys to redact.
-// It recursively redacts the given keys.
-func redact(data map[string]interface{}, keysToRedact []string) map[string]interface{} {
-	redactedData := make(map[string]interface{})
-	keyMap := make(map[string]struct{})
-	for _, key := range keysToRedact {
-		keyMap[strings.ToLower(key)] = struct{}{}
+// redactBaggage returns a copy of the baggage with the values of keysToRedact redacted and
+// the keys in keysToDrop left out, matched case-insensitively like headers. The baggage is
+// returned as is when there is nothing to redact or drop.
+func redactBaggage(baggage Baggage, keysToRedact, keysToDrop []string) Baggage {
+	if len(keysToRedact) == 0 && len(keysToDrop) == 0 {
+		return baggage
+	}
+	redactMap := lowerKeySet(keysToRedact)
+	dropMap := lowerKeySet(keysToDrop)
+
+	redacted := make(Baggage, len(baggage))
+	for key, value := range baggage {
+		if _, drop := dropMap[strings.ToLower(key)]; drop {
+			continue
+		}
+		if _, redact := redactMap[strings.ToLower(key)]; redact {
+			value = redactionPlaceholder
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+// lowerKeySet returns the lowercased keys as a set, for case-insensitive lookups.
+func lowerKeySet(keys []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		set[strings.ToLower(key)] = struct{}{}
+	}
+	return set
+}
+
+// maxNestedJSONDepth is how many levels of JSON encoded in string values are redacted with
+// Config.RedactNestedJSONStrings. Deeper strings are left as they are.
+const maxNestedJSONDepth = 3
+
+// nestedJSONDepth returns the depth of JSON strings redacted for the configuration.
+func nestedJSONDepth(cfg *Config) int {
+	if !cfg.RedactNestedJSONStrings {
+		return 0
 	}
+	return maxNestedJSONDepth
+}
+
+// redact takes a map representing a JSON object and the keys to redact and drop.
+// It recursively redacts the given keys, and string values matched by secrets, and
+// removes the keys to drop along with their values. A key in both lists is dropped.
+// String values holding a JSON object or array are redacted too, up to nestedDepth levels.
+func redact(data map[string]interface{}, keysToRedact, keysToDrop []string, secrets *secretDetector, nestedDepth int) map[string]interface{} {
+	redactedData := make(map[string]interface{})
+	keyMap := lowerKeySet(keysToRedact)
+	dropMap := lowerKeySet(keysToDrop)
 
 	for key, value := range data {
+		if _, drop := dropMap[strings.ToLower(key)]; drop {
+			continue
+		}
 		if _, exists := keyMap[strings.ToLower(key)]; exists {
 			redactedData[key] = redactionPlaceholder
 			continue
 		}
+		redactedData[key] = redactValue(value, keysToRedact, keysToDrop, secrets, nestedDepth)
+	}
+	return redactedData
+}
 
-		switch v := value.(type) {
-		case map[string]interface{}:
-			redactedData[key] = redact(v, keysToRedact)
-		case []interface{}:
-			var newSlice []interface{}
-			for _, item := range v {
-				if m, ok := item.(map[string]interface{}); ok {
-					newSlice = append(newSlice, redact(m, keysToRedact))
-				} else {
-					newSlice = append(newSlice, item)
-				}
-			}
-			redactedData[key] = newSlice
-		default:
-			redactedData[key] = value
+// redactValue redacts a single JSON value found under a key that isn't itself redacted.
+func redactValue(value interface{}, keysToRedact, keysToDrop []string, secrets *secretDetector, nestedDepth int) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return redact(v, keysToRedact, keysToDrop, secrets, nestedDepth)
+	case []interface{}:
+		newSlice := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			newSlice = append(newSlice, redactValue(item, keysToRedact, keysToDrop, secrets, nestedDepth))
+		}
+		return newSlice
+	case string:
+		if nested, ok := redactJSONString(v, keysToRedact, keysToDrop, secrets, nestedDepth); ok {
+			return nested
+		}
+		if secrets.matches(v) {
+			return redactionPlaceholder
 		}
+		return v
+	default:
+		return value
 	}
-	return redactedData
 }
 
-// redactJSONBody takes a JSON body as a byte slice and redacts sensitive keys.
-// If the body is not a valid JSON object, it returns the original body.
-func redactJSONBody(body []byte, keysToRedact []string) []byte {
-	if len(keysToRedact) == 0 || len(body) == 0 {
-		return body
+// redactJSONBody takes a JSON body as a byte slice, redacts sensitive keys and removes the
+// keys to drop.
+//
+// Object and array roots are redacted recursively. Scalar roots (a bare string, number,
+// boolean or null) have no keys to redact and are returned as is, as are bodies that
+// aren't valid JSON.
+func redactJSONBody(body []byte, keysToRedact, keysToDrop []string, secrets *secretDetector, nestedDepth int) []byte {
+	redacted, _, _ := redactJSONBodyChecked(body, keysToRedact, keysToDrop, secrets, nestedDepth)
+	return redacted
+}
+
+// redactJSONBodyChecked is redactJSONBody, also returning whether the body parsed and whether
+// it was redacted. parsed is false when the body looks like a JSON object or array but can't
+// be parsed, so redaction couldn't run and the body returned as is may hold secrets. applied
+// is true only when a JSON object or array went through redaction, not when there was
+// nothing to redact with or the body was returned as is.
+func redactJSONBodyChecked(body []byte, keysToRedact, keysToDrop []string, secrets *secretDetector, nestedDepth int) (redacted []byte, parsed, applied bool) {
+	if !redactionConfigured(keysToRedact, keysToDrop, secrets) || len(body) == 0 {
+		return body, true, false
 	}
 
-	var data map[string]interface{}
-	if err := json.Unmarshal(body, &data); err != nil {
-		// Not a valid JSON object, return as is.
-		return body
+	var data interface{}
+	if err := decodeJSONNumbers(body, &data); err != nil {
+		// Not valid JSON, return as is. Plain text has no keys to redact, but a broken
+		// object or array may well have some.
+		trimmed := bytes.TrimSpace(body)
+		return body, len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '['), false
 	}
 
-	redactedData := redact(data, keysToRedact)
+	var redactedData interface{}
+	switch root := data.(type) {
+	case map[string]interface{}:
+		redactedData = redact(root, keysToRedact, keysToDrop, secrets, nestedDepth)
+	case []interface{}:
+		redactedData = redactValue(root, keysToRedact, keysToDrop, secrets, nestedDepth)
+	default:
+		return body, true, false
+	}
 
 	redactedBody, err := json.Marshal(redactedData)
 	if err != nil {
 		// Should not happen in practice, but as a fallback, return the original.
-		return body
+		return body, true, false
+	}
+
+	return redactedBody, true, true
+}
+
+// redactionConfigured reports whether there is anything to redact bodies with.
+func redactionConfigured(keysToRedact, keysToDrop []string, secrets *secretDetector) bool {
+	return len(keysToRedact) > 0 || len(keysToDrop) > 0 || secrets != nil
+}
+
+// decodeJSONNumbers unmarshals data like json.Unmarshal, but decodes numbers as json.Number
+// so they are re-encoded with their exact text: an id like 10000000000000001 would otherwise
+// go through float64 and be logged as 1e+16.
+func decodeJSONNumbers(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("smartlog: invalid data after top-level JSON value")
+	}
+	return nil
+}
+
+// redactJSONString redacts a string value holding a JSON object or array, as found in
+// double-encoded webhook payloads, and re-encodes it. It returns false for any other string,
+// or once nestedDepth levels have been redacted.
+func redactJSONString(s string, keysToRedact, keysToDrop []string, secrets *secretDetector, nestedDepth int) (string, bool) {
+	trimmed := strings.TrimSpace(s)
+	if nestedDepth <= 0 || trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return "", false
 	}
+	var data interface{}
+	if err := decodeJSONNumbers([]byte(trimmed), &data); err != nil {
+		return "", false
+	}
+	redacted, err := json.Marshal(redactValue(data, keysToRedact, keysToDrop, secrets, nestedDepth-1))
+	if err != nil {
+		return "", false
+	}
+	return string(redacted), true
+}
+
+// compilePathPatterns compiles the configured path segment patterns.
+// It panics on an invalid pattern so misconfiguration surfaces at startup.
+func compilePathPatterns(patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		compiled = append(compiled, regexp.MustCompile(pattern))
+	}
+	return compiled
+}
 
-	return redactedBody
+// redactPath replaces every path segment matching one of the patterns with the redaction placeholder.
+func redactPath(path string, patterns []*regexp.Regexp) string {
+	if len(patterns) == 0 {
+		return path
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		for _, pattern := range patterns {
+			if pattern.MatchString(segment) {
+				segments[i] = redactionPlaceholder
+				break
+			}
+		}
+	}
+	return strings.Join(segments, "/")
 }
@@ -0,0 +1,132 @@
+package smartlog
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// redactionAudit records which configured redact keys matched during a request, and where,
+// without keeping the values. It backs Config.RedactAudit.
+type redactionAudit struct {
+	keyMap   map[string]string // lowercased key -> key as configured
+	matched  map[string]struct{}
+	fields   []string
+	sanitize bool
+}
+
+// newRedactionAudit returns an audit for keysToRedact, or nil when auditing is disabled.
+// All methods are no-ops on a nil audit.
+func newRedactionAudit(enabled bool, keysToRedact []string, sanitize bool) *redactionAudit {
+	if !enabled {
+		return nil
+	}
+	keyMap := make(map[string]string, len(keysToRedact))
+	for _, key := range keysToRedact {
+		keyMap[strings.ToLower(key)] = key
+	}
+	return &redactionAudit{keyMap: keyMap, matched: make(map[string]struct{}), sanitize: sanitize}
+}
+
+// headers records the header names that redactHeaders would redact.
+func (a *redactionAudit) headers(location string, headers http.Header) {
+	if a == nil {
+		return
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		a.check(location, name)
+	}
+}
+
+// jsonBody records the keys in a JSON object body that redactJSONBody would redact.
+func (a *redactionAudit) jsonBody(location string, body []byte) {
+	if a == nil || len(a.keyMap) == 0 || len(body) == 0 {
+		return
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return
+	}
+	a.object(location, data)
+}
+
+func (a *redactionAudit) object(path string, data map[string]interface{}) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		// Redacted values aren't descended into, matching redact
+		if a.check(path, key) {
+			continue
+		}
+		switch v := data[key].(type) {
+		case map[string]interface{}:
+			a.object(path+"."+key, v)
+		case []interface{}:
+			for _, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					a.object(path+"."+key+"[]", m)
+				}
+			}
+		}
+	}
+}
+
+// check records key under path if it is one of the configured keys, and reports whether it was.
+func (a *redactionAudit) check(path, key string) bool {
+	configured, ok := a.keyMap[strings.ToLower(key)]
+	if !ok {
+		return false
+	}
+	a.matched[configured] = struct{}{}
+	field := path + "." + key
+	if a.sanitize {
+		field = sanitizeString(field)
+	}
+	a.fields = append(a.fields, field)
+	return true
+}
+
+// log writes the audit entry for the request.
+func (a *redactionAudit) log(logger *zap.Logger, fields ...zap.Field) {
+	if a == nil {
+		return
+	}
+	logger.Info("Redaction audit", append(fields, zap.Object("redaction_audit", a))...)
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (a *redactionAudit) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	matched := make([]string, 0, len(a.matched))
+	for key := range a.matched {
+		matched = append(matched, key)
+	}
+	sort.Strings(matched)
+
+	if err := enc.AddArray("matched_keys", zapcore.ArrayMarshalerFunc(func(arr zapcore.ArrayEncoder) error {
+		for _, key := range matched {
+			arr.AppendString(key)
+		}
+		return nil
+	})); err != nil {
+		return err
+	}
+	return enc.AddArray("fields", zapcore.ArrayMarshalerFunc(func(arr zapcore.ArrayEncoder) error {
+		for _, field := range a.fields {
+			arr.AppendString(field)
+		}
+		return nil
+	}))
+}
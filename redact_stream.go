@@ -0,0 +1,262 @@
+package smartlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+// redactBodyForLog redacts a body for logging. A body longer than maxBytes (0 means no
+// limit) is redacted by redactJSONStream, which stops at the limit, so that a huge JSON body
+// is never decoded in full; truncated is then true if it stopped at the limit, and false if
+// dropped keys and redacted values made the whole body fit. Bodies that aren't JSON objects or arrays
+// are left to redactJSONBody and loggedBody as usual.
+//
+// skipped is true when the body looks like a JSON object or array but couldn't be parsed, so
+// it is returned unredacted. applied is true when a JSON object or array went through
+// redaction, as there were keys or secrets to redact it with.
+func redactBodyForLog(body []byte, keysToRedact, keysToDrop []string, secrets *secretDetector, nestedDepth, maxBytes int) (redacted []byte, truncated, skipped, applied bool) {
+	if maxBytes > 0 && len(body) > maxBytes {
+		if streamed, cut, ok := redactJSONStream(bytes.NewReader(body), keysToRedact, keysToDrop, secrets, nestedDepth, maxBytes); ok {
+			return streamed, cut, false, redactionConfigured(keysToRedact, keysToDrop, secrets)
+		}
+	}
+	redacted, parsed, applied := redactJSONBodyChecked(body, keysToRedact, keysToDrop, secrets, nestedDepth)
+	return redacted, false, !parsed, applied
+}
+
+// redactJSONStream redacts the JSON object or array read from r token by token, like
+// redactJSONBody but without decoding it into maps. It stops once the output reaches limit
+// bytes, at the last complete value, and closes the open objects and arrays so the result is
+// still valid JSON; truncated then reports that it stopped. Keys keep their order and numbers
+// their exact text.
+//
+// ok is false if the input is a scalar or turns out not to be valid JSON before the limit is
+// reached.
+func redactJSONStream(r io.Reader, keysToRedact, keysToDrop []string, secrets *secretDetector, nestedDepth, limit int) (redacted []byte, truncated, ok bool) {
+	s := &jsonStreamRedactor{
+		dec:          json.NewDecoder(r),
+		limit:        limit,
+		redact:       lowerKeySet(keysToRedact),
+		drop:         lowerKeySet(keysToDrop),
+		keysToRedact: keysToRedact,
+		keysToDrop:   keysToDrop,
+		secrets:      secrets,
+		nestedDepth:  nestedDepth,
+	}
+	s.dec.UseNumber()
+	truncated, err := s.run()
+	if err != nil {
+		return nil, false, false
+	}
+	return s.out.Bytes(), truncated, true
+}
+
+// errStreamLimit stops a jsonStreamRedactor once its output is full.
+var errStreamLimit = errors.New("smartlog: redacted body reached the limit")
+
+// errStreamScalar stops a jsonStreamRedactor reading a body that isn't a single object or array.
+var errStreamScalar = errors.New("smartlog: body isn't a single object or array")
+
+// streamFrame is an object or array open in the output.
+type streamFrame struct {
+	object    bool
+	count     int    // values written so far
+	expectKey bool   // the next token of an object is a key
+	key       string // the encoded key and colon waiting for its value, with any comma
+}
+
+type jsonStreamRedactor struct {
+	dec          *json.Decoder
+	out          bytes.Buffer
+	limit        int
+	redact, drop map[string]struct{}
+	keysToRedact []string
+	keysToDrop   []string
+	secrets      *secretDetector
+	nestedDepth  int
+	stack        []*streamFrame
+}
+
+// run copies the tokens to the output, closing the open containers and returning true when
+// it stops early at the limit.
+func (s *jsonStreamRedactor) run() (bool, error) {
+	err := s.copyTokens()
+	if errors.Is(err, errStreamLimit) {
+		for i := len(s.stack) - 1; i >= 0; i-- {
+			s.out.WriteByte(closer(s.stack[i].object))
+		}
+		return true, nil
+	}
+	return false, err
+}
+
+func (s *jsonStreamRedactor) copyTokens() error {
+	for {
+		tok, err := s.dec.Token()
+		if err == io.EOF && len(s.stack) == 0 && s.out.Len() > 0 {
+			return nil
+		}
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		if len(s.stack) == 0 {
+			if _, ok := tok.(json.Delim); !ok || s.out.Len() > 0 {
+				// A scalar, or data after the root value
+				return errStreamScalar
+			}
+		}
+
+		var top *streamFrame
+		if len(s.stack) > 0 {
+			top = s.stack[len(s.stack)-1]
+		}
+
+		// Closing delimiters are always written, so the output stays valid
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			s.out.WriteByte(byte(delim))
+			s.stack = s.stack[:len(s.stack)-1]
+			s.valueDone()
+			continue
+		}
+
+		if top != nil && top.object && top.expectKey {
+			key := tok.(string)
+			prefix := ""
+			if top.count > 0 {
+				prefix = ","
+			}
+			lower := strings.ToLower(key)
+			if _, drop := s.drop[lower]; drop {
+				if err := s.skipValue(); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, redact := s.redact[lower]; redact {
+				if err := s.skipValue(); err != nil {
+					return err
+				}
+				if err := s.emit(prefix+encodeJSONString(key)+":"+encodeJSONString(redactionPlaceholder), false); err != nil {
+					return err
+				}
+				top.count++
+				continue
+			}
+			top.key = prefix + encodeJSONString(key) + ":"
+			top.expectKey = false
+			continue
+		}
+
+		// A value: an object or array opening, or a scalar
+		piece := ""
+		if top != nil {
+			if top.object {
+				piece = top.key
+			} else if top.count > 0 {
+				piece = ","
+			}
+		}
+		if delim, ok := tok.(json.Delim); ok {
+			if err := s.emit(piece+string(delim), true); err != nil {
+				return err
+			}
+			s.stack = append(s.stack, &streamFrame{object: delim == '{', expectKey: delim == '{'})
+			continue
+		}
+		if err := s.emit(piece+s.encodeScalar(tok), false); err != nil {
+			return err
+		}
+		s.valueDone()
+	}
+}
+
+// valueDone records a complete value in the innermost open container.
+func (s *jsonStreamRedactor) valueDone() {
+	if len(s.stack) == 0 {
+		return
+	}
+	top := s.stack[len(s.stack)-1]
+	top.count++
+	if top.object {
+		top.expectKey = true
+		top.key = ""
+	}
+}
+
+// emit writes piece, which opens a container if opens is set, or returns errStreamLimit if
+// it would leave no room under the limit to close every open container.
+func (s *jsonStreamRedactor) emit(piece string, opens bool) error {
+	closers := len(s.stack)
+	if opens {
+		closers++
+	}
+	if s.out.Len()+len(piece)+closers > s.limit {
+		return errStreamLimit
+	}
+	s.out.WriteString(piece)
+	return nil
+}
+
+// skipValue reads past the next value, which may be an object or array.
+func (s *jsonStreamRedactor) skipValue() error {
+	depth := 0
+	for {
+		tok, err := s.dec.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := tok.(json.Delim); ok {
+			switch delim {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// encodeScalar encodes a string, number, boolean or null token, redacting strings matched
+// by the secret detector and JSON strings like redactValue.
+func (s *jsonStreamRedactor) encodeScalar(tok json.Token) string {
+	switch v := tok.(type) {
+	case string:
+		if nested, ok := redactJSONString(v, s.keysToRedact, s.keysToDrop, s.secrets, s.nestedDepth); ok {
+			return encodeJSONString(nested)
+		}
+		if s.secrets.matches(v) {
+			return encodeJSONString(redactionPlaceholder)
+		}
+		return encodeJSONString(v)
+	case json.Number:
+		return v.String()
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	default:
+		return "null"
+	}
+}
+
+func encodeJSONString(s string) string {
+	encoded, _ := json.Marshal(s)
+	return string(encoded)
+}
+
+func closer(object bool) byte {
+	if object {
+		return '}'
+	}
+	return ']'
+}
@@ -2,6 +2,10 @@ package smartlog
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -10,6 +14,7 @@ func TestRedactJSONBody(t *testing.T) {
 		name         string
 		inputBody    []byte
 		keysToRedact []string
+		keysToDrop   []string
 		expectedBody []byte
 	}{
 		{
@@ -42,6 +47,24 @@ func TestRedactJSONBody(t *testing.T) {
 			keysToRedact: []string{"api_key"},
 			expectedBody: []byte(`{"users":[{"api_key":"[REDACTED]","name":"jules"},{"api_key":"[REDACTED]","name":"agent"}]}`),
 		},
+		{
+			name:         "Array root",
+			inputBody:    []byte(`[{"name":"jules","password":"p1"},{"password":"p2"},[]]`),
+			keysToRedact: []string{"password"},
+			expectedBody: []byte(`[{"name":"jules","password":"[REDACTED]"},{"password":"[REDACTED]"},[]]`),
+		},
+		{
+			name:         "Scalar string root",
+			inputBody:    []byte(`"hello"`),
+			keysToRedact: []string{"password"},
+			expectedBody: []byte(`"hello"`),
+		},
+		{
+			name:         "Scalar number root",
+			inputBody:    []byte(` 42.50`),
+			keysToRedact: []string{"password"},
+			expectedBody: []byte(` 42.50`),
+		},
 		{
 			name:         "Invalid JSON input",
 			inputBody:    []byte(`not a json`),
@@ -54,6 +77,20 @@ func TestRedactJSONBody(t *testing.T) {
 			keysToRedact: []string{"password"},
 			expectedBody: []byte(`{}`),
 		},
+		{
+			name:         "Dropped keys are removed, redacted keys keep the placeholder",
+			inputBody:    []byte(`{"user":"jules","password":"supersecret","avatar":"iVBORw0KGgo=","items":[{"id":1,"Avatar":"R0lGOD=="}]}`),
+			keysToRedact: []string{"password"},
+			keysToDrop:   []string{"avatar"},
+			expectedBody: []byte(`{"items":[{"id":1}],"password":"[REDACTED]","user":"jules"}`),
+		},
+		{
+			name:         "Drop takes precedence over redaction",
+			inputBody:    []byte(`{"user":"jules","debug":{"trace":"..."}}`),
+			keysToRedact: []string{"debug"},
+			keysToDrop:   []string{"debug"},
+			expectedBody: []byte(`{"user":"jules"}`),
+		},
 		{
 			name:         "Empty input body",
 			inputBody:    []byte(``),
@@ -64,10 +101,146 @@ func TestRedactJSONBody(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			result := redactJSONBody(tc.inputBody, tc.keysToRedact)
+			result := redactJSONBody(tc.inputBody, tc.keysToRedact, tc.keysToDrop, nil, 0)
 			if !bytes.Equal(result, tc.expectedBody) {
 				t.Errorf("Expected '%s', but got '%s'", tc.expectedBody, result)
 			}
 		})
 	}
 }
+
+func TestRedactHeaders_DropKeys(t *testing.T) {
+	headers := http.Header{
+		"Authorization": {"Bearer token"},
+		"X-Debug-Blob":  {"a very long blob"},
+		"Accept":        {"application/json"},
+	}
+
+	result := redactHeaders(headers, []string{"authorization"}, []string{"x-debug-blob"}, false)
+	if _, ok := result["X-Debug-Blob"]; ok {
+		t.Errorf("Expected X-Debug-Blob to be dropped, got %v", result)
+	}
+	if got := result.Get("Authorization"); got != redactionPlaceholder {
+		t.Errorf("Expected Authorization to be redacted, got %q", got)
+	}
+	if got := result.Get("Accept"); got != "application/json" {
+		t.Errorf("Expected Accept to be kept, got %q", got)
+	}
+	if headers.Get("X-Debug-Blob") == "" {
+		t.Error("Expected the original headers to be left untouched")
+	}
+}
+
+func TestRedactJSONBody_NestedJSONStrings(t *testing.T) {
+	body := []byte(`{"payload":"{\"password\":\"x\",\"user\":\"jules\"}","note":"{not json"}`)
+
+	result := redactJSONBody(body, []string{"password"}, nil, nil, 0)
+	if !bytes.Equal(result, []byte(`{"note":"{not json","payload":"{\"password\":\"x\",\"user\":\"jules\"}"}`)) {
+		t.Errorf("Expected the inner string to be left alone when disabled, got '%s'", result)
+	}
+
+	result = redactJSONBody(body, []string{"password"}, nil, nil, maxNestedJSONDepth)
+	expected := []byte(`{"note":"{not json","payload":"{\"password\":\"[REDACTED]\",\"user\":\"jules\"}"}`)
+	if !bytes.Equal(result, expected) {
+		t.Errorf("Expected '%s', but got '%s'", expected, result)
+	}
+
+	// Strings nested deeper than the limit are left as they are
+	deep := []byte(`{"a":"{\"b\":\"{\\\"password\\\":\\\"x\\\"}\"}"}`)
+	result = redactJSONBody(deep, []string{"password"}, nil, nil, 1)
+	if !bytes.Equal(result, deep) {
+		t.Errorf("Expected the depth limit to stop redaction, got '%s'", result)
+	}
+	result = redactJSONBody(deep, []string{"password"}, nil, nil, 2)
+	if !bytes.Contains(result, []byte(redactionPlaceholder)) {
+		t.Errorf("Expected the second level to be redacted, got '%s'", result)
+	}
+}
+
+func TestRedactJSONStream(t *testing.T) {
+	testCases := []struct {
+		name              string
+		inputBody         string
+		limit             int
+		expectedBody      string
+		expectedTruncated bool
+	}{
+		{
+			name:         "Under the limit",
+			inputBody:    `{"user":"jules","password":"supersecret","id":10000000000000001}`,
+			limit:        1000,
+			expectedBody: `{"user":"jules","password":"[REDACTED]","id":10000000000000001}`,
+		},
+		{
+			name:         "Fits once redacted",
+			inputBody:    `{"user":"jules","avatar":"R0lGODlhAQABAIAAAP","password":"a very long secret value"}`,
+			limit:        45,
+			expectedBody: `{"user":"jules","password":"[REDACTED]"}`,
+		},
+		{
+			name:              "Cut at the last complete value",
+			inputBody:         `{"user":"jules","password":"supersecret","bio":"a long biography"}`,
+			limit:             45,
+			expectedBody:      `{"user":"jules","password":"[REDACTED]"}`,
+			expectedTruncated: true,
+		},
+		{
+			name:              "Open containers are closed",
+			inputBody:         `{"items":[{"id":1,"avatar":"R0lGOD=="},{"id":2},{"id":3}],"total":3}`,
+			limit:             24,
+			expectedBody:      `{"items":[{"id":1},{}]}`,
+			expectedTruncated: true,
+		},
+		{
+			name:              "Array root",
+			inputBody:         `[1,2,3,4,5,6,7,8,9]`,
+			limit:             8,
+			expectedBody:      `[1,2,3]`,
+			expectedTruncated: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, truncated, ok := redactJSONStream(strings.NewReader(tc.inputBody), []string{"password"}, []string{"avatar"}, nil, 0, tc.limit)
+			if !ok {
+				t.Fatal("Expected the body to be redacted")
+			}
+			if string(result) != tc.expectedBody {
+				t.Errorf("Expected '%s', but got '%s'", tc.expectedBody, result)
+			}
+			if truncated != tc.expectedTruncated {
+				t.Errorf("Expected truncated to be %v, got %v", tc.expectedTruncated, truncated)
+			}
+			if !json.Valid(result) {
+				t.Errorf("Expected valid JSON, got '%s'", result)
+			}
+		})
+	}
+
+	// A body over the limit that fits once redacted isn't marked truncated
+	body := []byte(`{"user":"jules","avatar":"R0lGODlhAQABAIAAAP","password":"a very long secret value"}`)
+	if _, truncated, _, _ := redactBodyForLog(body, []string{"password"}, []string{"avatar"}, nil, 0, 45); truncated {
+		t.Error("Expected a body that fits once redacted not to be marked truncated")
+	}
+
+	for _, body := range []string{`"a scalar"`, `{"broken":`, `{} {}`} {
+		if _, _, ok := redactJSONStream(strings.NewReader(body), nil, nil, nil, 0, 1000); ok {
+			t.Errorf("Expected %s to be left to redactJSONBody", body)
+		}
+	}
+}
+
+// largeJSONBody returns a JSON array of objects of about size bytes.
+func largeJSONBody(size int) []byte {
+	var b bytes.Buffer
+	b.WriteByte('[')
+	for i := 0; b.Len() < size; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"id":%d,"name":"user %d","password":"secret-%d","tags":["a","b","c"]}`, i, i, i)
+	}
+	b.WriteByte(']')
+	return b.Bytes()
+}
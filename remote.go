@@ -0,0 +1,301 @@
+package smartlog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// defaultRemoteBatchSize is the number of entries posted in a single request.
+	defaultRemoteBatchSize = 100
+	// defaultRemoteFlushInterval is how long a partial batch waits before it is posted.
+	defaultRemoteFlushInterval = time.Second
+	// defaultRemoteQueueSize is the number of entries buffered while a batch is being posted.
+	defaultRemoteQueueSize = 1000
+	// defaultRemoteMaxRetries is the number of retries after a failed post.
+	defaultRemoteMaxRetries = 3
+	// defaultRemoteRetryBackoff is the delay before the first retry; it doubles for each retry.
+	defaultRemoteRetryBackoff = 100 * time.Millisecond
+	// defaultRemoteTimeout bounds a single post.
+	defaultRemoteTimeout = 5 * time.Second
+)
+
+// RemoteConfig configures a core shipping entries to an external collector over HTTP.
+type RemoteConfig struct {
+	Endpoint      string               // collector endpoint receiving the batches, e.g. a Loki or Vector HTTP source
+	Headers       map[string]string    // extra headers sent with every batch, e.g. authentication
+	BatchSize     int                  // entries posted per request; defaults to 100
+	FlushInterval time.Duration        // maximum time a partial batch waits; defaults to 1s
+	QueueSize     int                  // entries buffered before new ones are dropped; defaults to 1000
+	MaxRetries    int                  // retries after a failed post; defaults to 3, negative disables retries
+	RetryBackoff  time.Duration        // delay before the first retry, doubled for each retry; defaults to 100ms
+	Timeout       time.Duration        // timeout of a single post; defaults to 5s
+	Level         zapcore.LevelEnabler // minimum level shipped; defaults to Info
+	Client        *http.Client         // HTTP client used for posts; defaults to one using Timeout
+}
+
+// NewRemoteCore creates a zapcore.Core that ships entries to cfg.Endpoint. Entries are
+// encoded as JSON like in the log file and posted as newline-delimited JSON batches from
+// a background goroutine, once BatchSize entries are pending or FlushInterval has passed.
+// Failed posts are retried with exponential backoff when the collector is unreachable or
+// answers 429 or 5xx.
+//
+// Logging never blocks on the collector: while it is slow or down, entries queue up to
+// QueueSize and further ones are dropped. The next Sync posts all pending entries and
+// reports the entries dropped and the posts that failed in the background since the
+// last Sync. Pass the core to NewLogger with WithExtraCore, and call Close on shutdown
+// to post the remaining entries and stop the goroutine.
+func NewRemoteCore(cfg RemoteConfig) *RemoteCore {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultRemoteBatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultRemoteFlushInterval
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultRemoteQueueSize
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = defaultRemoteMaxRetries
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = defaultRemoteRetryBackoff
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultRemoteTimeout
+	}
+	if cfg.Level == nil {
+		cfg.Level = zapcore.InfoLevel
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: cfg.Timeout}
+	}
+
+	s := &remoteShipper{
+		cfg:     cfg,
+		queue:   make(chan []byte, cfg.QueueSize),
+		flushes: make(chan chan error),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.run()
+
+	return &RemoteCore{
+		LevelEnabler: cfg.Level,
+		enc:          zapcore.NewJSONEncoder(newEncoderConfig()),
+		shipper:      s,
+	}
+}
+
+// RemoteCore encodes entries and hands them to the shared shipper.
+type RemoteCore struct {
+	zapcore.LevelEnabler
+	enc     zapcore.Encoder
+	shipper *remoteShipper
+}
+
+// With returns a copy of the core carrying the additional fields.
+func (c *RemoteCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &RemoteCore{
+		LevelEnabler: c.LevelEnabler,
+		enc:          c.enc.Clone(),
+		shipper:      c.shipper,
+	}
+	for _, field := range fields {
+		field.AddTo(clone.enc)
+	}
+	return clone
+}
+
+// Check adds the core to the checked entry if the level is enabled.
+func (c *RemoteCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+// Write encodes the entry and queues it for shipping.
+func (c *RemoteCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	line := bytes.Clone(buf.Bytes())
+	buf.Free()
+
+	c.shipper.enqueue(line)
+	return nil
+}
+
+// Sync posts all queued entries, and reports the entries dropped and the posts that
+// failed in the background since the last Sync.
+func (c *RemoteCore) Sync() error {
+	return c.shipper.flush()
+}
+
+// Close posts all queued entries like Sync, then stops the background goroutine. The
+// core must not be used afterwards.
+func (c *RemoteCore) Close() error {
+	return c.shipper.close()
+}
+
+// remoteShipper batches encoded entries and posts them to the collector. A single
+// goroutine does the posting, so a slow collector fills the queue instead of
+// blocking the callers.
+type remoteShipper struct {
+	cfg       RemoteConfig
+	queue     chan []byte
+	flushes   chan chan error
+	stop      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+	dropped   atomic.Int64
+
+	// failures counts the background posts that failed since the last flush, and
+	// lastErr is the latest of them.
+	failMu   sync.Mutex
+	failures int
+	lastErr  error
+}
+
+// enqueue queues an encoded entry, dropping it if the queue is full.
+func (s *remoteShipper) enqueue(line []byte) {
+	select {
+	case s.queue <- line:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// flush waits until all queued entries have been posted.
+func (s *remoteShipper) flush() error {
+	done := make(chan error)
+	select {
+	case s.flushes <- done:
+	case <-s.stopped:
+		return errors.New("smartlog: remote core is closed")
+	}
+	err := <-done
+
+	if dropped := s.dropped.Swap(0); dropped > 0 {
+		err = errors.Join(err, fmt.Errorf("smartlog: remote queue full, dropped %d entries", dropped))
+	}
+	s.failMu.Lock()
+	if s.failures > 0 {
+		err = errors.Join(err, fmt.Errorf("smartlog: %d background posts failed, last: %w", s.failures, s.lastErr))
+		s.failures, s.lastErr = 0, nil
+	}
+	s.failMu.Unlock()
+	return err
+}
+
+func (s *remoteShipper) close() error {
+	err := errors.New("smartlog: remote core is closed")
+	s.closeOnce.Do(func() {
+		err = s.flush()
+		close(s.stop)
+		<-s.stopped
+	})
+	return err
+}
+
+// postInBackground posts a batch outside of a flush, recording a failure for the next flush.
+func (s *remoteShipper) postInBackground(batch [][]byte) {
+	if err := s.post(batch); err != nil {
+		s.failMu.Lock()
+		s.failures++
+		s.lastErr = err
+		s.failMu.Unlock()
+	}
+}
+
+func (s *remoteShipper) run() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	var batch [][]byte
+	for {
+		select {
+		case line := <-s.queue:
+			batch = append(batch, line)
+			if len(batch) >= s.cfg.BatchSize {
+				s.postInBackground(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				s.postInBackground(batch)
+				batch = nil
+			}
+		case done := <-s.flushes:
+			var errs []error
+			for drained := false; !drained; {
+				select {
+				case line := <-s.queue:
+					batch = append(batch, line)
+					if len(batch) >= s.cfg.BatchSize {
+						errs = append(errs, s.post(batch))
+						batch = nil
+					}
+				default:
+					drained = true
+				}
+			}
+			if len(batch) > 0 {
+				errs = append(errs, s.post(batch))
+				batch = nil
+			}
+			done <- errors.Join(errs...)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// post sends a batch, retrying with exponential backoff on retryable failures.
+func (s *remoteShipper) post(batch [][]byte) error {
+	payload := bytes.Join(batch, nil) // encoded entries already end in a newline
+
+	backoff := s.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retryable, err := s.send(payload)
+		if err == nil || !retryable || attempt >= s.cfg.MaxRetries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// send posts the payload once and reports whether a failure is worth retrying.
+func (s *remoteShipper) send(payload []byte) (retryable bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, s.cfg.Endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for key, value := range s.cfg.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retryable = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retryable, fmt.Errorf("smartlog: remote collector returned status %d", resp.StatusCode)
+	}
+	return false, nil
+}
@@ -0,0 +1,196 @@
+package smartlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// collector records the batches posted to it, answering with the queued statuses first.
+type collector struct {
+	mu       sync.Mutex
+	batches  [][]map[string]any
+	statuses []int
+	attempts int
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.attempts++
+	if len(c.statuses) > 0 {
+		status := c.statuses[0]
+		c.statuses = c.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+
+	var batch []map[string]any
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var entry map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			batch = append(batch, entry)
+		}
+	}
+	c.batches = append(c.batches, batch)
+}
+
+func (c *collector) received() [][]map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]map[string]any(nil), c.batches...)
+}
+
+func TestRemoteCore_ShipsBatches(t *testing.T) {
+	col := &collector{}
+	server := httptest.NewServer(col)
+	defer server.Close()
+
+	core := NewRemoteCore(RemoteConfig{
+		Endpoint:      server.URL,
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+	})
+	defer core.Close()
+	logger := zap.New(core).With(zap.String("service", "test-service"))
+
+	logger.Info("first", zap.Int("n", 1))
+	logger.Debug("below the level")
+	logger.Info("second", zap.Int("n", 2))
+
+	// A full batch is posted without waiting for Sync
+	require.Eventually(t, func() bool { return len(col.received()) == 1 }, time.Second, 10*time.Millisecond)
+
+	logger.Warn("third", zap.Int("n", 3))
+	require.NoError(t, logger.Sync())
+
+	batches := col.received()
+	require.Len(t, batches, 2)
+	require.Len(t, batches[0], 2)
+	require.Len(t, batches[1], 1)
+
+	assert.Equal(t, "first", batches[0][0]["message"])
+	assert.Equal(t, "INFO", batches[0][0]["level"])
+	assert.Equal(t, "test-service", batches[0][0]["service"])
+	assert.Equal(t, float64(1), batches[0][0]["n"])
+	assert.Equal(t, "second", batches[0][1]["message"])
+	assert.Equal(t, "third", batches[1][0]["message"])
+	assert.Equal(t, "WARN", batches[1][0]["level"])
+}
+
+func TestRemoteCore_RetriesFailedPosts(t *testing.T) {
+	col := &collector{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	server := httptest.NewServer(col)
+	defer server.Close()
+
+	core := NewRemoteCore(RemoteConfig{
+		Endpoint:     server.URL,
+		RetryBackoff: time.Millisecond,
+	})
+	defer core.Close()
+	logger := zap.New(core)
+	logger.Info("retried")
+	require.NoError(t, logger.Sync())
+
+	assert.Equal(t, 3, col.attempts)
+	if batches := col.received(); assert.Len(t, batches, 1) {
+		assert.Equal(t, "retried", batches[0][0]["message"])
+	}
+}
+
+func TestRemoteCore_DoesNotRetryClientErrors(t *testing.T) {
+	col := &collector{statuses: []int{http.StatusBadRequest}}
+	server := httptest.NewServer(col)
+	defer server.Close()
+
+	core := NewRemoteCore(RemoteConfig{Endpoint: server.URL})
+	defer core.Close()
+	logger := zap.New(core)
+	logger.Info("rejected")
+
+	err := logger.Sync()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "status 400")
+	}
+	assert.Equal(t, 1, col.attempts)
+}
+
+func TestRemoteCore_ReportsBackgroundFailures(t *testing.T) {
+	col := &collector{statuses: []int{http.StatusBadRequest}}
+	server := httptest.NewServer(col)
+	defer server.Close()
+
+	core := NewRemoteCore(RemoteConfig{
+		Endpoint:      server.URL,
+		BatchSize:     1,
+		FlushInterval: time.Hour,
+	})
+	defer core.Close()
+	logger := zap.New(core)
+
+	// The full batch is posted, and rejected, by the background goroutine
+	logger.Info("rejected")
+	require.Eventually(t, func() bool {
+		col.mu.Lock()
+		defer col.mu.Unlock()
+		return col.attempts == 1
+	}, time.Second, 10*time.Millisecond)
+
+	err := logger.Sync()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "1 background posts failed")
+		assert.Contains(t, err.Error(), "status 400")
+	}
+	assert.NoError(t, logger.Sync(), "failures are reported once")
+}
+
+func TestRemoteCore_Close(t *testing.T) {
+	col := &collector{}
+	server := httptest.NewServer(col)
+	defer server.Close()
+
+	core := NewRemoteCore(RemoteConfig{Endpoint: server.URL, FlushInterval: time.Hour})
+	zap.New(core).Info("pending")
+
+	require.NoError(t, core.Close())
+	if batches := col.received(); assert.Len(t, batches, 1) {
+		assert.Equal(t, "pending", batches[0][0]["message"])
+	}
+
+	assert.Error(t, core.Sync())
+	assert.Error(t, core.Close())
+}
+
+func TestNewLogger_WithExtraCore(t *testing.T) {
+	col := &collector{}
+	server := httptest.NewServer(col)
+	defer server.Close()
+
+	cfg := &Config{
+		ServiceName: "test-service",
+		Env:         "test",
+		Log:         TimberjackConfig{Filename: filepath.Join(t.TempDir(), "app.log")},
+	}
+	remote := NewRemoteCore(RemoteConfig{Endpoint: server.URL})
+	defer remote.Close()
+	logger := NewLogger(cfg, WithExtraCore(remote))
+	logger.Info("shipped")
+	_ = logger.Sync() // syncing stdout fails on some platforms
+
+	batches := col.received()
+	require.Len(t, batches, 1)
+	assert.Equal(t, "shipped", batches[0][0]["message"])
+	assert.Equal(t, "test-service", batches[0][0]["service"])
+	assert.Equal(t, "test", batches[0][0]["env"])
+}
@@ -0,0 +1,85 @@
+package smartlog
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/DeRuina/timberjack"
+	"go.uber.org/zap"
+)
+
+// Rotator rotates the log file of a logger on demand, for logrotate-style tooling that sends
+// SIGHUP to have logs reopened. Pass it to NewLogger with WithRotator, then either call
+// Rotate yourself or opt into the signal handler with HandleSIGHUP:
+//
+//	rotator := smartlog.NewRotator()
+//	logger := smartlog.NewLogger(cfg, smartlog.WithRotator(rotator))
+//	stop := rotator.HandleSIGHUP()
+//	defer stop()
+type Rotator struct {
+	mu     sync.Mutex
+	file   *timberjack.Logger
+	logger *zap.Logger
+}
+
+// NewRotator returns a Rotator to pass to NewLogger.
+func NewRotator() *Rotator {
+	return &Rotator{}
+}
+
+// attach registers the log file and the logger NewLogger created.
+func (r *Rotator) attach(file *timberjack.Logger, logger *zap.Logger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.file = file
+	r.logger = logger
+}
+
+// Rotate flushes the logger, then moves the log file aside as a backup and opens a new one.
+// It returns an error if the logger has no log file, e.g. because it fell back to the console.
+func (r *Rotator) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return errors.New("smartlog: no log file to rotate")
+	}
+	_ = r.logger.Sync()
+	return r.file.RotateWithReason("sighup")
+}
+
+// HandleSIGHUP rotates the log file whenever the process receives SIGHUP, until the returned
+// function is called. Rotation failures are logged as warnings. Nothing listens for the
+// signal unless HandleSIGHUP is called.
+func (r *Rotator) HandleSIGHUP() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-signals:
+				if err := r.Rotate(); err != nil {
+					r.mu.Lock()
+					logger := r.logger
+					r.mu.Unlock()
+					if logger != nil {
+						logger.Warn("Log rotation on SIGHUP failed", zap.Error(err))
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(done)
+		})
+	}
+}
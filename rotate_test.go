@@ -0,0 +1,49 @@
+package smartlog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotator_Rotate(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	rotator := NewRotator()
+	logger := NewLogger(&Config{Log: TimberjackConfig{Filename: logPath}}, WithRotator(rotator))
+
+	logger.Info("before rotation")
+	require.NoError(t, rotator.Rotate())
+	logger.Info("after rotation")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2, "The rotated file is kept as a backup next to the new one")
+
+	current, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(current), "after rotation")
+	assert.NotContains(t, string(current), "before rotation")
+
+	for _, entry := range entries {
+		if entry.Name() == "app.log" {
+			continue
+		}
+		assert.Contains(t, entry.Name(), "sighup")
+		backup, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		require.NoError(t, err)
+		assert.Contains(t, string(backup), "before rotation")
+	}
+
+	// Stopping the signal handler more than once is fine
+	stop := rotator.HandleSIGHUP()
+	stop()
+	stop()
+}
+
+func TestRotator_NoLogFile(t *testing.T) {
+	assert.Error(t, NewRotator().Rotate(), "A rotator not passed to NewLogger has nothing to rotate")
+}
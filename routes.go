@@ -0,0 +1,108 @@
+package smartlog
+
+import (
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// routeSettings are the logging settings for a request after applying the matching
+// Config.RouteOverrides entry, if any.
+type routeSettings struct {
+	redactKeys      []string
+	logRequestBody  bool
+	logResponseBody bool
+	skip            bool
+	level           zapcore.Level
+}
+
+// routePattern is a configured path pattern, like the keys of Config.RouteOverrides.
+type routePattern struct {
+	pattern string
+	prefix  bool // pattern ends in "*" and matches paths starting with it
+}
+
+func newRoutePattern(pattern string) routePattern {
+	return routePattern{pattern: strings.TrimSuffix(pattern, "*"), prefix: strings.HasSuffix(pattern, "*")}
+}
+
+// matchRoute returns the index of the pattern matching a request, or -1 if none does. A
+// pattern equal to route, as returned by the route func, applies first. Otherwise the most
+// specific pattern matching path wins: an exact pattern over prefix patterns, and a longer
+// prefix over a shorter one.
+func matchRoute(patterns []routePattern, path, route string) int {
+	if route != "" {
+		for i, p := range patterns {
+			if !p.prefix && p.pattern == route {
+				return i
+			}
+		}
+	}
+	best, bestLen := -1, -1
+	for i, p := range patterns {
+		if !p.prefix {
+			if path == p.pattern {
+				return i
+			}
+			continue
+		}
+		// "/admin/*" also matches "/admin" itself
+		matches := strings.HasPrefix(path, p.pattern) || path == strings.TrimSuffix(p.pattern, "/")
+		if matches && len(p.pattern) > bestLen {
+			best, bestLen = i, len(p.pattern)
+		}
+	}
+	return best
+}
+
+// routeOverrides resolves the settings for each request path.
+type routeOverrides struct {
+	defaults routeSettings
+	// patterns are the RouteOverrides keys and overrides their entries resolved against the
+	// global settings, in the same order.
+	patterns  []routePattern
+	overrides []routeSettings
+}
+
+// newRouteOverrides resolves each configured override against the global settings. Route
+// redact keys are added to the global ones, and unset fields keep the global behavior.
+func newRouteOverrides(cfg *Config) *routeOverrides {
+	ro := &routeOverrides{
+		defaults: routeSettings{
+			redactKeys:      cfg.allRedactKeys(),
+			logRequestBody:  true,
+			logResponseBody: true,
+			level:           zapcore.InfoLevel,
+		},
+	}
+	for pattern, override := range cfg.RouteOverrides {
+		settings := ro.defaults
+		if len(override.RedactKeys) > 0 {
+			settings.redactKeys = append(append([]string(nil), ro.defaults.redactKeys...), override.RedactKeys...)
+		}
+		settings.logRequestBody = boolOrDefault(override.LogRequestBody, true)
+		settings.logResponseBody = boolOrDefault(override.LogResponseBody, true)
+		settings.skip = override.Skip
+		if level, err := zapcore.ParseLevel(override.Level); err == nil && override.Level != "" {
+			settings.level = level
+		}
+
+		ro.patterns = append(ro.patterns, newRoutePattern(pattern))
+		ro.overrides = append(ro.overrides, settings)
+	}
+	return ro
+}
+
+// resolveRoute returns the settings for a request to path. An override keyed by exactly the
+// route Config.RouteFunc returned for it (e.g. "/users/{id}") applies first; otherwise the
+// most specific override matching path does, or the global settings without a match.
+func (ro *routeOverrides) resolveRoute(path, route string) routeSettings {
+	return ro.settings(matchRoute(ro.patterns, path, route))
+}
+
+func (ro *routeOverrides) settings(match int) routeSettings {
+	if match < 0 {
+		return ro.defaults
+	}
+	return ro.overrides[match]
+}
@@ -0,0 +1,117 @@
+package smartlog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRouteOverrides_MostSpecificMatch(t *testing.T) {
+	noBody := false
+	routes := newRouteOverrides(&Config{
+		RedactKeys:                    []string{"password"},
+		DisableDefaultHeaderRedaction: true,
+		RouteOverrides: map[string]RouteConfig{
+			"/admin/*":       {Level: "debug"},
+			"/admin/users/*": {RedactKeys: []string{"ssn"}},
+			"/admin/health":  {Skip: true},
+			"/public/*":      {LogRequestBody: &noBody, LogResponseBody: &noBody},
+		},
+	})
+
+	assert.Equal(t, zapcore.DebugLevel, routes.resolveRoute("/admin", "").level)
+	assert.Equal(t, zapcore.DebugLevel, routes.resolveRoute("/admin/settings", "").level)
+	assert.Equal(t, []string{"password", "ssn"}, routes.resolveRoute("/admin/users/42", "").redactKeys)
+	assert.True(t, routes.resolveRoute("/admin/health", "").skip)
+	assert.False(t, routes.resolveRoute("/public/docs", "").logRequestBody)
+
+	defaults := routes.resolveRoute("/orders", "")
+	assert.Equal(t, []string{"password"}, defaults.redactKeys)
+	assert.True(t, defaults.logRequestBody)
+	assert.Equal(t, zapcore.InfoLevel, defaults.level)
+}
+
+func TestServerLogging_RouteOverridesBodyLogging(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	noBody := false
+	cfg := &Config{
+		RouteOverrides: map[string]RouteConfig{
+			"/public/*": {LogRequestBody: &noBody, LogResponseBody: &noBody},
+		},
+	}
+
+	handler := ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	for _, path := range []string{"/admin/users", "/public/docs"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"q":1}`)))
+	}
+
+	logs := recorded.All()
+	require.Len(t, logs, 4)
+
+	adminRequest := logs[0].ContextMap()["request"].(map[string]interface{})
+	adminResponse := logs[1].ContextMap()["response"].(map[string]interface{})
+	assert.Contains(t, adminRequest, "body")
+	assert.Contains(t, adminResponse, "body")
+
+	publicRequest := logs[2].ContextMap()["request"].(map[string]interface{})
+	publicResponse := logs[3].ContextMap()["response"].(map[string]interface{})
+	assert.Equal(t, "route", publicRequest["body_omitted"])
+	assert.NotContains(t, publicRequest, "body")
+	assert.Equal(t, "route", publicResponse["body_omitted"])
+	assert.NotContains(t, publicResponse, "body")
+}
+
+func TestServerLogging_RouteOverridesByRouteFunc(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	noBody := false
+	calls := 0
+	cfg := &Config{
+		RouteFunc: func(r *http.Request) string {
+			calls++
+			if strings.HasPrefix(r.URL.Path, "/users/") {
+				return "/users/{id}"
+			}
+			return "/files/{name}"
+		},
+		RouteOverrides: map[string]RouteConfig{
+			"/users/{id}":   {LogRequestBody: &noBody},
+			"/files/{name}": {LogResponseBody: &noBody},
+		},
+		RouteSampleRates: map[string]float64{"/users/{id}": 1},
+	}
+
+	var routes []string
+	handler := ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routes = append(routes, RouteFromContext(r.Context()))
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	for _, path := range []string{"/users/42", "/files/report.pdf"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"q":1}`)))
+	}
+	assert.Equal(t, 2, calls, "The route func runs once per request")
+	assert.Equal(t, []string{"/users/{id}", "/files/{name}"}, routes)
+
+	logs := recorded.All()
+	require.Len(t, logs, 4)
+
+	userRequest := logs[0].ContextMap()["request"].(map[string]interface{})
+	userResponse := logs[1].ContextMap()["response"].(map[string]interface{})
+	assert.Equal(t, "route", userRequest["body_omitted"])
+	assert.Contains(t, userResponse, "body")
+
+	fileRequest := logs[2].ContextMap()["request"].(map[string]interface{})
+	fileResponse := logs[3].ContextMap()["response"].(map[string]interface{})
+	assert.Contains(t, fileRequest, "body")
+	assert.Equal(t, "route", fileResponse["body_omitted"])
+}
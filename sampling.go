@@ -0,0 +1,67 @@
+package smartlog
+
+import (
+	"net/http"
+	"time"
+)
+
+// sampler decides which requests are logged when Config.SampleRate, or the route's
+// Config.RouteSampleRates entry, is below 1.
+type sampler struct {
+	rate   float64
+	random func() float64
+}
+
+// newSampler returns a sampler for the configured rate, which defaults to 1 (log everything).
+func newSampler(rate *float64, random func() float64) *sampler {
+	return &sampler{rate: floatOrDefault(rate, 1), random: random}
+}
+
+// sample makes the sampling decision for a request.
+func (s *sampler) sample() bool {
+	switch {
+	case s.rate >= 1:
+		return true
+	case s.rate <= 0:
+		return false
+	default:
+		return s.random() < s.rate
+	}
+}
+
+// routeSamplers picks the sampler for each request: the one of the Config.RouteSampleRates
+// pattern matching it, matched like RouteOverrides, or the global one.
+type routeSamplers struct {
+	global   *sampler
+	patterns []routePattern
+	samplers []*sampler
+}
+
+func newRouteSamplers(cfg *Config, random func() float64) *routeSamplers {
+	rs := &routeSamplers{global: newSampler(cfg.SampleRate, random)}
+	for pattern, rate := range cfg.RouteSampleRates {
+		rs.patterns = append(rs.patterns, newRoutePattern(pattern))
+		rs.samplers = append(rs.samplers, &sampler{rate: rate, random: random})
+	}
+	return rs
+}
+
+// resolveRoute returns the sampler for a request to path, whose route Config.RouteFunc
+// returned, matched like route overrides.
+func (rs *routeSamplers) resolveRoute(path, route string) *sampler {
+	if match := matchRoute(rs.patterns, path, route); match >= 0 {
+		return rs.samplers[match]
+	}
+	return rs.global
+}
+
+// keepUnsampled reports whether a response log must be kept even though its request wasn't
+// sampled: errors (status >= 400) and slow responses are never dropped.
+func keepUnsampled(status int, slow bool) bool {
+	return slow || status >= http.StatusBadRequest
+}
+
+// isSlow reports whether latency reaches the threshold in milliseconds. A threshold of 0 disables it.
+func isSlow(latency time.Duration, thresholdMs int) bool {
+	return thresholdMs > 0 && latency >= time.Duration(thresholdMs)*time.Millisecond
+}
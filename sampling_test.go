@@ -0,0 +1,164 @@
+package smartlog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSampler(t *testing.T) {
+	random := func() float64 { return 0.3 }
+	half, zero := 0.5, 0.0
+
+	assert.True(t, newSampler(nil, random).sample(), "unset rate logs everything")
+	assert.False(t, newSampler(&zero, random).sample())
+	assert.True(t, newSampler(&half, random).sample())
+	assert.False(t, newSampler(&half, func() float64 { return 0.7 }).sample())
+}
+
+func TestServerLogging_SlowRequestsSurviveSampling(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	sampleRate := 0.0
+	cfg := &Config{SampleRate: &sampleRate, SlowRequestThresholdMs: 500}
+
+	handler := ServerLogging(zap.New(core), cfg, WithClock(clock.Now))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			clock.Advance(800 * time.Millisecond)
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+
+	for _, path := range []string{"/fast", "/slow", "/error"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	logs := recorded.All()
+	require.Len(t, logs, 2, "only response logs of the slow request and the error are kept")
+
+	slow := logs[0].ContextMap()
+	assert.Equal(t, "/slow", slow["path"])
+	assert.Equal(t, int64(http.StatusOK), slow["status"])
+	assert.Equal(t, true, slow["slow"])
+	assert.Equal(t, false, slow["sampled"])
+
+	failed := logs[1].ContextMap()
+	assert.Equal(t, "/error", failed["path"])
+	assert.Equal(t, false, failed["sampled"])
+	assert.NotContains(t, failed, "slow")
+}
+
+func TestServerLogging_AlwaysLogSlowerThanMs(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	sampleRate := 0.0
+	cfg := &Config{SampleRate: &sampleRate, AlwaysLogSlowerThanMs: 300}
+
+	handler := ServerLogging(zap.New(core), cfg, WithClock(clock.Now))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			clock.Advance(300 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, path := range []string{"/fast", "/slow"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	logs := recorded.All()
+	require.Len(t, logs, 1, "the slow 200 is logged despite a zero sample rate")
+	entry := logs[0].ContextMap()
+	assert.Equal(t, "/slow", entry["path"])
+	assert.Equal(t, int64(http.StatusOK), entry["status"])
+	assert.Equal(t, int64(300), entry["latency_ms"])
+	assert.Equal(t, false, entry["sampled"])
+	assert.Equal(t, true, entry["slow"], "always_log_slower_than_ms is an alias of slow_request_threshold_ms")
+}
+
+func TestServerLogging_RouteSampleRates(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	sampleRate := 0.5
+	cfg := &Config{
+		SampleRate:       &sampleRate,
+		RouteSampleRates: map[string]float64{"/metrics-heavy": 0.01, "/checkout/*": 1},
+	}
+
+	// Every draw falls outside a 1% sample but inside a 50% one
+	random := func() float64 { return 0.3 }
+	handler := ServerLogging(zap.New(core), cfg, WithRandom(random))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, path := range []string{"/metrics-heavy", "/checkout/cart", "/orders"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	var paths []interface{}
+	for _, entry := range recorded.FilterMessage("Response sent").All() {
+		paths = append(paths, entry.ContextMap()["path"])
+	}
+	assert.Equal(t, []interface{}{"/checkout/cart", "/orders"}, paths, "/metrics-heavy is sampled at 1%, /orders at the global 50%")
+}
+
+func TestServerLogging_RouteSampleRates_RouteFunc(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	cfg := &Config{
+		RouteSampleRates: map[string]float64{"/users/{id}": 0},
+		RouteFunc:        func(r *http.Request) string { return "/users/{id}" },
+	}
+	handler := ServerLogging(zap.New(core), cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/42", nil))
+
+	assert.Empty(t, recorded.All(), "the rate of the route returned by RouteFunc applies")
+}
+
+func TestServerLogging_DetailSampleRate(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	detailRate := 0.5
+	draws := []float64{0.2, 0.8}
+	random := func() float64 {
+		draw := draws[0]
+		draws = draws[1:]
+		return draw
+	}
+	cfg := &Config{DetailSampleRate: &detailRate}
+
+	handler := ServerLogging(zap.New(core), cfg, WithRandom(random))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":1}`))
+	}))
+	for range 2 {
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"name":"jules"}`))
+		req.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	logs := recorded.All()
+	require.Len(t, logs, 4, "requests outside the detail sample are still logged")
+
+	// 0.2 falls in the detail sample
+	assert.Contains(t, logs[0].ContextMap(), "request")
+	assert.NotContains(t, logs[0].ContextMap(), "detailed")
+	assert.Contains(t, logs[1].ContextMap(), "response")
+	assert.NotContains(t, logs[1].ContextMap(), "detailed")
+
+	// 0.8 doesn't: metadata only
+	request := logs[2].ContextMap()
+	assert.Equal(t, false, request["detailed"])
+	assert.Equal(t, "POST", request["method"])
+	assert.Equal(t, "/users", request["path"])
+	assert.NotContains(t, request, "request")
+
+	response := logs[3].ContextMap()
+	assert.Equal(t, false, response["detailed"])
+	assert.Equal(t, int64(http.StatusOK), response["status"])
+	assert.Contains(t, response, "latency_ms")
+	assert.NotContains(t, response, "response")
+}
@@ -0,0 +1,62 @@
+package smartlog
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// sanitizeString escapes control characters such as newlines and ANSI escape sequences,
+// so user-controlled values can't forge log lines or inject terminal sequences.
+func sanitizeString(s string) string {
+	if strings.IndexFunc(s, unicode.IsControl) < 0 {
+		return s
+	}
+
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			quoted := strconv.QuoteRune(r)
+			b.WriteString(quoted[1 : len(quoted)-1])
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// sanitizeHeaders escapes control characters in header values. The original headers
+// are returned as-is when no value needs escaping.
+func sanitizeHeaders(headers http.Header) http.Header {
+	dirty := false
+	for _, values := range headers {
+		for _, value := range values {
+			if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+				dirty = true
+			}
+		}
+	}
+	if !dirty {
+		return headers
+	}
+
+	sanitized := make(http.Header, len(headers))
+	for key, values := range headers {
+		cleanValues := make([]string, len(values))
+		for i, value := range values {
+			cleanValues[i] = sanitizeString(value)
+		}
+		sanitized[key] = cleanValues
+	}
+	return sanitized
+}
+
+// sanitizeBaggage returns a copy of baggage with control characters in keys and values escaped.
+func sanitizeBaggage(baggage Baggage) Baggage {
+	sanitized := make(Baggage, len(baggage))
+	for key, value := range baggage {
+		sanitized[sanitizeString(key)] = sanitizeString(value)
+	}
+	return sanitized
+}
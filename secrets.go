@@ -0,0 +1,78 @@
+package smartlog
+
+import (
+	"math"
+	"regexp"
+)
+
+// defaultHighEntropyMinLength is the shortest string checked for high entropy when
+// Config.RedactHighEntropyMinLength is unset.
+const defaultHighEntropyMinLength = 32
+
+// minSecretEntropy is the Shannon entropy, in bits per character, above which a base64-like
+// string is treated as a secret. Random base64 tokens of 32+ characters score around 4.5-5,
+// while words, identifiers and hex digests stay at or below 4.
+const minSecretEntropy = 4.2
+
+var jwtPattern = regexp.MustCompile(`^eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*$`)
+
+// secretDetector recognizes secrets by the shape of their value rather than their key:
+// JWTs, and long base64-like strings with high entropy. A nil detector matches nothing.
+type secretDetector struct {
+	minLength int
+}
+
+// newSecretDetector returns a detector for the config, or nil if heuristic redaction is disabled.
+func newSecretDetector(cfg *Config) *secretDetector {
+	if !cfg.RedactHighEntropy {
+		return nil
+	}
+	minLength := cfg.RedactHighEntropyMinLength
+	if minLength <= 0 {
+		minLength = defaultHighEntropyMinLength
+	}
+	return &secretDetector{minLength: minLength}
+}
+
+// matches reports whether value looks like a secret.
+func (d *secretDetector) matches(value string) bool {
+	if d == nil {
+		return false
+	}
+	if jwtPattern.MatchString(value) {
+		return true
+	}
+	return len(value) >= d.minLength && isBase64Like(value) && shannonEntropy(value) >= minSecretEntropy
+}
+
+// isBase64Like reports whether s only contains characters of the standard or URL-safe base64 alphabets.
+func isBase64Like(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '+', c == '/', c == '=', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// shannonEntropy returns the Shannon entropy of s in bits per byte.
+func shannonEntropy(s string) float64 {
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	var entropy float64
+	n := float64(len(s))
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
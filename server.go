@@ -1,15 +1,23 @@
 package smartlog
 
 import (
+	"bufio"
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
-	"time"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 // contextKey is a custom type for context keys to avoid collisions.
@@ -20,119 +28,743 @@ const (
 	LoggerKey contextKey = "logger"
 	// LogIDKey is the key for the log ID in the request context.
 	LogIDKey contextKey = "log_id"
+	// RouteKey is the key for the route of the request in the request context.
+	RouteKey contextKey = "route"
+	// responseKey is the key for the request's *responseWriter in the request context.
+	responseKey contextKey = "response"
+	// stateKey is the key for the request's *requestState in the request context.
+	stateKey contextKey = "state"
+	// debugKey marks the context of a request flagged with HeaderDebugLog.
+	debugKey contextKey = "debug"
+	// skippedLoggerKey is the key for the *skippedLogger of the request's logger in the request context.
+	skippedLoggerKey contextKey = "skipped_logger"
 	// HeaderLogID is the name of the header for the log ID.
 	HeaderLogID = "X-Request-ID"
+	// HeaderDebugLog is the name of the header flagging a request for full debug logging,
+	// honored when Config.AllowDebugHeader is set.
+	HeaderDebugLog = "X-Debug-Log"
 )
 
-// responseWriter is a wrapper around http.ResponseWriter to capture the status code and response body.
+// responseWriter is a wrapper around http.ResponseWriter to capture the status code, size and response body.
 type responseWriter struct {
 	http.ResponseWriter
-	statusCode int
-	body       *bytes.Buffer
+	statusCode  int
+	wroteHeader bool
+	bytes       int
+	body        *bytes.Buffer // nil when the body isn't captured
+	hijacked    bool
+	streamed    bool // the handler flushed the response, so the body is no longer captured
+	timedOut    bool // a write failed with http.ErrHandlerTimeout from an enclosing http.TimeoutHandler
+	// deadlineExceeded is set by WithTimeout when its deadline passed before the handler returned
+	deadlineExceeded bool
+	// When set, the body is only captured once the handler reports an error with SetError
+	errorState *requestState
 }
 
-func newResponseWriter(w http.ResponseWriter) *responseWriter {
-	return &responseWriter{
+func newResponseWriter(w http.ResponseWriter, captureBody bool) *responseWriter {
+	rw := &responseWriter{
 		ResponseWriter: w,
 		statusCode:     http.StatusOK,
-		body:           new(bytes.Buffer),
 	}
+	if captureBody {
+		rw.body = new(bytes.Buffer)
+	}
+	return rw
 }
 
 // WriteHeader captures the status code before writing it to the original ResponseWriter.
+// Like net/http, only the first final (non-1xx) status counts.
 func (rw *responseWriter) WriteHeader(code int) {
-	rw.statusCode = code
+	if !rw.wroteHeader {
+		rw.statusCode = code
+		rw.wroteHeader = code >= 200
+	}
 	rw.ResponseWriter.WriteHeader(code)
 }
 
 // Write captures the response body before writing it to the original ResponseWriter.
 func (rw *responseWriter) Write(b []byte) (int, error) {
-	rw.body.Write(b)
-	return rw.ResponseWriter.Write(b)
+	rw.wroteHeader = true
+	if rw.body != nil && !rw.streamed && (rw.errorState == nil || rw.errorState.getError() != nil) {
+		rw.body.Write(b)
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += n
+	if errors.Is(err, http.ErrHandlerTimeout) {
+		rw.timedOut = true
+	}
+	return n, err
+}
+
+// capturedBody returns the captured response body, or nil if it isn't captured.
+func (rw *responseWriter) capturedBody() []byte {
+	if rw.body == nil {
+		return nil
+	}
+	return rw.body.Bytes()
+}
+
+// Flush sends buffered data to the client, for streaming responses such as server-sent
+// events. Once flushed, the body is no longer captured so long-lived streams don't pile
+// up in memory; the response log reports their size instead.
+func (rw *responseWriter) Flush() {
+	rw.wroteHeader = true
+	if !rw.streamed && rw.body != nil {
+		rw.body = new(bytes.Buffer)
+	}
+	rw.streamed = true
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// logIDFromRequest looks up an existing log ID for the request. The context keys are tried
+// in order, then the X-Request-ID header. It returns an empty string if none is found.
+func logIDFromRequest(r *http.Request, contextKeys []interface{}) string {
+	ctx := r.Context()
+	for _, key := range contextKeys {
+		switch v := ctx.Value(key).(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case fmt.Stringer:
+			if id := v.String(); id != "" {
+				return id
+			}
+		}
+	}
+	return r.Header.Get(HeaderLogID)
+}
+
+// isDebugRequest reports whether the request asks for full debug logging with HeaderDebugLog.
+func isDebugRequest(r *http.Request) bool {
+	debug, _ := strconv.ParseBool(r.Header.Get(HeaderDebugLog))
+	return debug
+}
+
+// neverEnabled turns off the stack traces a logger adds on its own with zap.AddStacktrace.
+var neverEnabled = zap.LevelEnablerFunc(func(zapcore.Level) bool { return false })
+
+// debugLogger returns a logger that logs at every level, for a request flagged with HeaderDebugLog.
+func debugLogger(logger *zap.Logger) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return &debugCore{Core: c}
+	})).With(zap.Bool("debug_log", true))
+}
+
+// hasBody reports whether a server request may carry a body. A ContentLength of 0 means
+// an empty body for server requests; -1 (unknown, e.g. chunked) may still have one.
+func hasBody(r *http.Request) bool {
+	return r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0
+}
+
+// routeForRequest resolves the route stored in the request context: route, the result of
+// Config.RouteFunc, if set, else the pattern matched by an enclosing http.ServeMux, else the
+// logged path.
+func routeForRequest(r *http.Request, route, logPath string, sanitize bool) string {
+	if route == "" {
+		route = r.Pattern
+	}
+	if route == "" {
+		return logPath
+	}
+	if sanitize {
+		route = sanitizeString(route)
+	}
+	return route
+}
+
+// responseTrailers collects the trailers a handler set on the response header, both those
+// declared in the Trailer header and those set with the http.TrailerPrefix.
+func responseTrailers(header http.Header) http.Header {
+	var trailers http.Header
+	add := func(key string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		if trailers == nil {
+			trailers = make(http.Header)
+		}
+		trailers[http.CanonicalHeaderKey(key)] = values
+	}
+
+	for _, declared := range header.Values("Trailer") {
+		for _, key := range strings.Split(declared, ",") {
+			key = strings.TrimSpace(key)
+			add(key, header.Values(key))
+		}
+	}
+	for key, values := range header {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			add(strings.TrimPrefix(key, http.TrailerPrefix), values)
+		}
+	}
+	return trailers
+}
+
+// Hijack lets the handler take over the connection, e.g. for WebSocket upgrades.
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("smartlog: underlying ResponseWriter does not implement http.Hijacker")
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err == nil {
+		rw.hijacked = true
+	}
+	return conn, brw, err
 }
 
 // ServerLogging is a middleware that logs incoming HTTP requests and their responses.
-func ServerLogging(logger *zap.Logger, cfg *Config) func(http.Handler) http.Handler {
+func ServerLogging(logger *zap.Logger, cfg *Config, opts ...Option) func(http.Handler) http.Handler {
+	return NewServerLogger(logger, cfg).WithOptions(opts...).Handler()
+}
+
+// ServerLogger builds the server logging middleware programmatically, on top of a base
+// configuration. The builder works on its own copy of the configuration, so adding skip
+// paths or redact keys never affects the Config it was created from or other middlewares
+// sharing it:
+//
+//	mw := smartlog.NewServerLogger(logger, cfg).
+//		SkipPath("/healthz").
+//		RedactKey("ssn").
+//		Handler()
+type ServerLogger struct {
+	logger *zap.Logger
+	cfg    Config
+	opts   []Option
+}
+
+// NewServerLogger returns a builder for the server logging middleware, starting from cfg.
+func NewServerLogger(logger *zap.Logger, cfg *Config) *ServerLogger {
+	return &ServerLogger{logger: logger, cfg: *cfg}
+}
+
+// SkipPath adds a path that is never logged, like skip_paths.
+func (s *ServerLogger) SkipPath(path string) *ServerLogger {
+	s.cfg.SkipPaths = append(slices.Clip(s.cfg.SkipPaths), path)
+	return s
+}
+
+// RedactKey adds a key redacted from headers and bodies, like redact_keys.
+func (s *ServerLogger) RedactKey(key string) *ServerLogger {
+	s.cfg.RedactKeys = append(slices.Clip(s.cfg.RedactKeys), key)
+	return s
+}
+
+// WithRouteFunc sets the function returning the route of a request, like Config.RouteFunc.
+func (s *ServerLogger) WithRouteFunc(f func(r *http.Request) string) *ServerLogger {
+	s.cfg.RouteFunc = f
+	return s
+}
+
+// WithOptions adds options such as WithClock.
+func (s *ServerLogger) WithOptions(opts ...Option) *ServerLogger {
+	s.opts = append(slices.Clip(s.opts), opts...)
+	return s
+}
+
+// Handler returns the middleware for the configuration built so far. Later calls to the
+// builder don't affect middlewares already returned.
+func (s *ServerLogger) Handler() func(http.Handler) http.Handler {
+	logger := s.logger
+	cfg := new(Config)
+	*cfg = s.cfg
+	o := newOptions(s.opts)
+	clock := o.clock
+	samplers := newRouteSamplers(cfg, o.random)
+	detailSampler := newSampler(cfg.DetailSampleRate, o.random)
 	// Create a map for quick lookup of skip paths
 	skipPaths := make(map[string]bool)
 	for _, path := range cfg.SkipPaths {
 		skipPaths[path] = true
 	}
+	skipMethods := make(map[string]bool)
+	for _, method := range cfg.SkipMethods {
+		skipMethods[strings.ToUpper(method)] = true
+	}
+	buckets := newLatencyBuckets(cfg.LatencyBuckets)
+	pathPatterns := compilePathPatterns(cfg.RedactPathSegments)
+	secrets := newSecretDetector(cfg)
+	sanitize := cfg.sanitizeControlChars()
+	messages := cfg.messages()
+	logRequest := boolOrDefault(cfg.LogRequest, true)
+	logResponse := boolOrDefault(cfg.LogResponse, true)
+	keys := newLogKeys(cfg.FlattenFields)
+	routes := newRouteOverrides(cfg)
+	projection := newFieldProjection(cfg.LogFields)
+	metrics := o.metrics
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// If the path is in our skip list, just call the next handler
-			if skipPaths[r.URL.Path] {
+			// The route func runs once per request: route overrides, route sample rates and
+			// the route stored in the context all use its result
+			var funcRoute string
+			if cfg.RouteFunc != nil {
+				funcRoute = cfg.RouteFunc(r)
+			}
+
+			// If the path is in our skip list or the skip func says so, just call the next handler
+			route := routes.resolveRoute(r.URL.Path, funcRoute)
+			if skipPaths[r.URL.Path] || route.skip || (cfg.SkipFunc != nil && cfg.SkipFunc(r)) {
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			startTime := time.Now()
+			startTime := clock.Now()
+			logPath := redactPath(r.URL.Path, pathPatterns)
+			var logURL string
+			if cfg.LogFullURL {
+				logURL = requestURLForLog(r, logPath, route.redactKeys, cfg.TrustProxyHeaders)
+			}
+			if sanitize {
+				logPath = sanitizeString(logPath)
+				logURL = sanitizeString(logURL)
+			}
 
 			// Get or create Log ID
-			logID := r.Header.Get(HeaderLogID)
+			logID := logIDFromRequest(r, cfg.LogIDContextKeys)
 			if logID == "" {
 				logID = uuid.NewString()
 			}
+			if sanitize {
+				logID = sanitizeString(logID)
+			}
 
-			// Create a logger with the log ID
-			ctxLogger := logger.With(zap.String("log_id", logID))
+			// Pick up baggage propagated by the caller
+			ctx := r.Context()
+			if baggage := parseBaggage(r.Header.Get(HeaderBaggage)); len(baggage) > 0 {
+				ctx = WithBaggage(ctx, baggage)
+			}
 
-			// Add logger and logID to context
-			ctx := context.WithValue(r.Context(), LoggerKey, ctxLogger)
+			// Create a logger with the log ID and baggage
+			ctxLogger := logger.With(zap.String("log_id", logID), keys.baggage(BaggageFromContext(ctx), route.redactKeys, cfg.DropKeys, sanitize))
+			// The request and response logs leave the log ID out unless it's projected
+			entryLogger := ctxLogger
+			if !projection.includes(LogFieldLogID) {
+				entryLogger = logger.With(keys.baggage(BaggageFromContext(ctx), route.redactKeys, cfg.DropKeys, sanitize))
+			}
+
+			// A request flagged for debugging is logged in full, at every level, by the
+			// middleware and by everything using the context's logger
+			debug := cfg.AllowDebugHeader && isDebugRequest(r)
+			if debug {
+				ctxLogger = debugLogger(ctxLogger)
+				entryLogger = debugLogger(entryLogger)
+				ctx = context.WithValue(ctx, debugKey, true)
+			}
+
+			// Values the handler reports for the response log, e.g. via SetOperation. The
+			// handler's logger counts the warnings and errors it logs into it.
+			state := &requestState{}
+			handlerLogger := ctxLogger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+				return &levelCountingCore{Core: c, state: state}
+			}))
+
+			// Add logger, logID and route to context
+			ctx = context.WithValue(ctx, LoggerKey, handlerLogger)
+			ctx = context.WithValue(ctx, skippedLoggerKey, newSkippedLogger(handlerLogger))
 			ctx = context.WithValue(ctx, LogIDKey, logID)
+			ctx = WithRoute(ctx, routeForRequest(r, funcRoute, logPath, sanitize))
+
+			// Skipped methods still get the context values, but no log entries
+			if skipMethods[r.Method] {
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			// Wrap response writer to capture status and size, and the body when it is logged.
+			// It's kept in the context for ResponseStatus and ResponseBytes.
+			// Entries the logger's level would discard aren't worth capturing bodies for, and
+			// neither are requests outside the detail sample, which only log metadata.
+			levelEnabled := ctxLogger.Core().Enabled(route.level)
+			detailed := debug || detailSampler.sample()
+			rw := newResponseWriter(w, logResponse && levelEnabled && detailed)
+			if cfg.CaptureResponseBodyOnError {
+				rw.errorState = state
+			}
+			ctx = context.WithValue(ctx, responseKey, rw)
+
+			ctx = context.WithValue(ctx, stateKey, state)
 			r = r.WithContext(ctx)
 
-			// Read request body
+			websocket := isWebSocketUpgrade(r)
+
+			// Unsampled requests only get a response log if it turns out to be an error or slow
+			sampled := debug || samplers.resolveRoute(r.URL.Path, funcRoute).sample()
+
+			// Report which redact keys matched once the request is done
+			audit := newRedactionAudit(cfg.RedactAudit, route.redactKeys, sanitize)
+			defer audit.log(ctxLogger, zap.String("method", r.Method), zap.String("path", logPath))
+
+			// Reading the body up front would make the server send 100 Continue before the
+			// handler decides whether it wants the upload, so such bodies are left to the
+			// handler to stream. The size limit still applies as it reads.
+			expectContinue := strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+			if expectContinue && r.Body != nil && cfg.MaxRequestBytes > 0 {
+				r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxRequestBytes)
+			}
+
+			// Read request body when it is logged or its size is limited. Server requests
+			// report an empty body with a ContentLength of 0, which is typical of GET and
+			// DELETE, so there is nothing to read or restore.
 			var reqBodyBytes []byte
-			if r.Body != nil {
-				reqBodyBytes, _ = io.ReadAll(r.Body)
+			logsRequestDetails := logRequest && sampled && levelEnabled && detailed
+			var uploadBoundary string
+			if cfg.LogUploadFiles && logsRequestDetails && route.logRequestBody && !expectContinue {
+				uploadBoundary = multipartBoundary(r)
+			}
+			if hasBody(r) && !expectContinue && ((logsRequestDetails && uploadBoundary == "") || cfg.MaxRequestBytes > 0) {
+				body := r.Body
+				if cfg.MaxRequestBytes > 0 {
+					body = http.MaxBytesReader(w, r.Body, cfg.MaxRequestBytes)
+				}
+				var err error
+				reqBodyBytes, err = io.ReadAll(body)
+
+				// Reject oversized bodies before the handler runs
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					ctxLogger.Warn("Request too large",
+						zap.String("error_kind", "request_too_large"),
+						zap.String("method", r.Method),
+						zap.String("path", logPath),
+						zap.Int64("content_length", r.ContentLength),
+						zap.Int64("max_request_bytes", maxBytesErr.Limit),
+					)
+					http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+					return
+				}
+
 				// Restore the body so the next handler can read it
 				r.Body = io.NopCloser(bytes.NewBuffer(reqBodyBytes))
 			}
 
-			// Redact and prepare request body for logging
-			redactedReqBody := redactJSONBody(reqBodyBytes, cfg.RedactKeys)
-			var reqBodyForLog json.RawMessage
-			if len(redactedReqBody) > 0 {
-				reqBodyForLog = json.RawMessage(redactedReqBody)
+			// Only the metadata of uploaded files is logged. Unless the whole body was
+			// read for its size limit, just its start is buffered to find them.
+			var uploads uploadedFiles
+			if uploadBoundary != "" && hasBody(r) {
+				scanned := reqBodyBytes
+				if scanned == nil {
+					scanned = peekBody(r, cmp.Or(cfg.UploadScanMaxBytes, defaultUploadScanMaxBytes))
+				}
+				uploads = scanUploadedFiles(scanned, uploadBoundary, sanitize)
 			}
 
-			redactedHeaders := redactHeaders(r.Header, cfg.RedactKeys)
+			if logRequest && sampled && levelEnabled && !detailed {
+				entryLogger.Log(route.level, messages.RequestMsg,
+					projection.field(LogFieldMethod, zap.String(keys.method, r.Method)),
+					projection.field(LogFieldPath, zap.String(keys.path, logPath)),
+					omittedBodySizeField("req_body_bytes", cfg.LogOmittedBodySize, true, requestBodySize(r, reqBodyBytes)),
+					zap.Bool("detailed", false),
+				)
+			} else if logRequest && sampled && levelEnabled {
+				var reqBodyForLog json.RawMessage
+				var reqBodyRaw, reqBodyHash, reqBodyOmitted, reqRedactionSkipped string
+				var reqBodyTruncated, reqRedactionApplied bool
+				var graphQLOp graphQLOperation
+				var isGraphQL bool
+				switch {
+				case !route.logRequestBody:
+					reqBodyOmitted = "route"
+				case expectContinue:
+					reqBodyOmitted = "expect_continue"
+				case uploadBoundary != "":
+					reqBodyOmitted = "multipart"
+				case cfg.HashBodiesInsteadOfLog:
+					reqBodyHash = bodySHA256(reqBodyBytes)
+				default:
+					// Decode a copy of compressed bodies so redaction sees the actual payload.
+					// The handler still receives the original compressed stream.
+					logReqBody := decodeBodyForLog(reqBodyBytes, r.Header.Get("Content-Encoding"))
 
-			ctxLogger.Info("Request received",
-				zap.String("method", r.Method),
-				zap.String("path", r.URL.Path),
-				zap.Any("request", map[string]interface{}{
-					"headers": redactedHeaders,
-					"body":    reqBodyForLog,
-				}),
-			)
+					// GraphQL variables are redacted like any body key; the query itself can
+					// be left out, as the operation is logged on its own. Bodies over the log
+					// limit aren't decoded in full for it.
+					if cfg.MaxBodyLogBytes <= 0 || len(logReqBody) <= cfg.MaxBodyLogBytes {
+						graphQLOp, isGraphQL = parseGraphQLRequest(r.Header.Get("Content-Type"), logReqBody)
+						if isGraphQL && cfg.GraphQLStripQuery {
+							logReqBody = stripGraphQLQuery(logReqBody)
+						}
+					}
+
+					// Redact and prepare request body for logging. A body that looks like JSON
+					// but can't be parsed isn't redacted, so it's left out unless allowed.
+					var redactedReqBody []byte
+					var rawTruncated, redactionSkipped bool
+					redactedReqBody, reqBodyTruncated, redactionSkipped, reqRedactionApplied = redactBodyForLog(logReqBody, route.redactKeys, cfg.DropKeys, secrets, nestedJSONDepth(cfg), cfg.MaxBodyLogBytes)
+					if redactionSkipped {
+						reqRedactionSkipped = "parse_error"
+					}
+					if redactionSkipped && !cfg.LogUnredactableBodies {
+						reqBodyOmitted = "redaction_skipped"
+					} else {
+						audit.jsonBody("request.body", logReqBody)
+						reqBodyForLog, reqBodyRaw, rawTruncated = loggedBody(redactedReqBody, cfg.MaxBodyLogBytes)
+						metrics.observeBody(logReqBody, redactedReqBody, reqBodyTruncated || rawTruncated)
+					}
+				}
+
+				redactedHeaders := redactHeaders(r.Header, route.redactKeys, cfg.DropKeys, cfg.AsyncCore)
+				audit.headers("request.headers", r.Header)
+				metrics.observeHeaders(r.Header, redactedHeaders)
+				if sanitize {
+					redactedHeaders = sanitizeHeaders(redactedHeaders)
+				}
+				// Cut after escaping, which can make values longer
+				redactedHeaders = truncateHeaderValues(redactedHeaders, cfg.MaxHeaderValueLogBytes)
+
+				reqFields := []zap.Field{
+					projection.field(LogFieldMethod, zap.String(keys.method, r.Method)),
+					projection.field(LogFieldPath, zap.String(keys.path, logPath)),
+					optionalString(keys.url, logURL),
+					projection.field(LogFieldProto, zap.String("proto", r.Proto)),
+				}
+				if len(uploads) > 0 {
+					reqFields = append(reqFields, zap.Array("files", uploads))
+				}
+				if isGraphQL {
+					reqFields = append(reqFields, graphQLFields(graphQLOp)...)
+				}
+				if cfg.LogQueryParams {
+					reqFields = append(reqFields, keys.query(parseQueryParams(r.URL.RawQuery, route.redactKeys, sanitize)))
+				}
 
-			// Wrap response writer to capture status and body
-			rw := newResponseWriter(w)
+				// Mark WebSocket upgrades along with the requested subprotocols
+				if websocket {
+					wsProtocol := r.Header.Get("Sec-WebSocket-Protocol")
+					if sanitize {
+						wsProtocol = sanitizeString(wsProtocol)
+					}
+					reqFields = append(reqFields, zap.Bool("websocket", true), optionalString("ws_protocol", wsProtocol))
+				}
+
+				if cfg.LogTLSInfo {
+					reqFields = append(reqFields, tlsFields(r.TLS)...)
+				}
+
+				reqFields = append(reqFields, bodyHashField("request_body_sha256", reqBodyBytes, cfg.HashBodies && !cfg.HashBodiesInsteadOfLog))
+				reqBodyLeftOut := reqBodyOmitted != "" || cfg.HashBodiesInsteadOfLog || !projection.includes(LogFieldReqBody)
+				reqFields = append(reqFields, omittedBodySizeField("req_body_bytes", cfg.LogOmittedBodySize, reqBodyLeftOut, requestBodySize(r, reqBodyBytes)))
+				// Fields read from the headers are left out with them
+				var accept, acceptLanguage string
+				if projection.includes(LogFieldHeaders) {
+					reqFields = append(reqFields, geoFields(r.Header, cfg.GeoHeaders, sanitize)...)
+					accept = r.Header.Get("Accept")
+					acceptLanguage = r.Header.Get("Accept-Language")
+				}
+				if sanitize {
+					accept = sanitizeString(accept)
+					acceptLanguage = sanitizeString(acceptLanguage)
+				}
+				reqFields = append(reqFields, keys.request(httpRequestLog{
+					accept:           accept,
+					acceptLanguage:   acceptLanguage,
+					headers:          redactedHeaders,
+					body:             reqBodyForLog,
+					bodyRaw:          reqBodyRaw,
+					bodySHA256:       reqBodyHash,
+					bodyOmitted:      reqBodyOmitted,
+					bodyTruncated:    reqBodyTruncated,
+					redactionSkipped: reqRedactionSkipped,
+					redactionApplied: reqRedactionApplied,
+					skipHeaders:      !projection.includes(LogFieldHeaders),
+					skipBody:         !projection.includes(LogFieldReqBody),
+				}))
+				entryLogger.Log(route.level, messages.RequestMsg, reqFields...)
+			}
+
+			// With response logging off, there's nothing left to log
+			if !logResponse {
+				next.ServeHTTP(rw, r)
+				return
+			}
 
 			// Call the next handler
 			next.ServeHTTP(rw, r)
 
 			// Calculate latency
-			latency := time.Since(startTime)
+			latency := clock.Since(startTime)
+
+			// A hijacked WebSocket upgrade writes its 101 response on the raw connection
+			status := rw.statusCode
+			if websocket && rw.hijacked {
+				status = http.StatusSwitchingProtocols
+			}
 
-			// Redact and prepare response body for logging
-			redactedRespBody := redactJSONBody(rw.body.Bytes(), cfg.RedactKeys)
+			// An enclosing http.TimeoutHandler answers 503 through its own writer once the
+			// deadline passes, discarding what the handler writes afterwards. WithTimeout only
+			// cancels the context, so the status the handler sent stands.
+			if rw.timedOut {
+				status = http.StatusServiceUnavailable
+			}
+			timedOut := rw.timedOut || rw.deadlineExceeded
+
+			// The sampling decision is overridden for errors and slow responses
+			slow := isSlow(latency, cfg.slowRequestThresholdMs())
+			if !sampled && !keepUnsampled(status, slow) {
+				return
+			}
+
+			// Escalate the response log to the most severe level the handler logged at. Only
+			// then is it known whether the entry is emitted at all.
+			level := route.level
+			warnCount, errorCount := state.levelCounts()
+			if warnCount > 0 {
+				level = max(level, zapcore.WarnLevel)
+			}
+			if errorCount > 0 {
+				level = max(level, zapcore.ErrorLevel)
+			}
+			if timedOut {
+				level = max(level, zapcore.WarnLevel)
+			}
+			if !ctxLogger.Core().Enabled(level) {
+				return
+			}
+
+			// Bodies of streamed responses, and of responses below the configured status, are
+			// left out of the log
+			var bodyOmitted string
+			switch {
+			case !detailed:
+				bodyOmitted = "detail_sample"
+			case rw.timedOut:
+				// Whatever the handler wrote was discarded in favor of the 503
+				bodyOmitted = "timeout"
+			case rw.body == nil:
+				// Not captured as the configured level was disabled; the entry was escalated
+				bodyOmitted = "level"
+			case rw.streamed:
+				bodyOmitted = "streamed"
+			case !route.logResponseBody:
+				bodyOmitted = "route"
+			case cfg.CaptureResponseBodyOnError && state.getError() == nil:
+				bodyOmitted = "no_error"
+			case cfg.LogResponseBodyOnStatusAtLeast > 0 && status < cfg.LogResponseBodyOnStatusAtLeast:
+				bodyOmitted = "ok_status"
+			}
+
+			// Redact and prepare response body for logging. The error envelope still needs the
+			// redacted body when it isn't logged.
+			var redactedRespBody []byte
+			var respBodyTruncated, respRedactionApplied bool
+			var respRedactionSkipped string
+			if bodyOmitted == "" || len(cfg.ErrorEnvelopeFields) > 0 {
+				var redactionSkipped bool
+				redactedRespBody, respBodyTruncated, redactionSkipped, respRedactionApplied = redactBodyForLog(rw.capturedBody(), route.redactKeys, cfg.DropKeys, secrets, nestedJSONDepth(cfg), cfg.MaxBodyLogBytes)
+				if redactionSkipped && bodyOmitted == "" && !cfg.HashBodiesInsteadOfLog {
+					respRedactionSkipped = "parse_error"
+					if !cfg.LogUnredactableBodies {
+						bodyOmitted = "redaction_skipped"
+					}
+				}
+			}
 			var respBodyForLog json.RawMessage
-			if len(redactedRespBody) > 0 {
-				respBodyForLog = json.RawMessage(redactedRespBody)
+			var respBodyRaw, respBodyHash string
+			if bodyOmitted == "" && cfg.HashBodiesInsteadOfLog {
+				respBodyHash = bodySHA256(rw.capturedBody())
+			} else if bodyOmitted == "" {
+				audit.jsonBody("response.body", rw.capturedBody())
+				var rawTruncated bool
+				respBodyForLog, respBodyRaw, rawTruncated = loggedBody(redactedRespBody, cfg.MaxBodyLogBytes)
+				metrics.observeBody(rw.capturedBody(), redactedRespBody, respBodyTruncated || rawTruncated)
 			}
 
-			ctxLogger.Info("Response sent",
-				zap.String("method", r.Method),
-				zap.String("path", r.URL.Path),
-				zap.Int("status", rw.statusCode),
-				zap.Int64("latency_ms", latency.Milliseconds()),
-				zap.Any("response", map[string]interface{}{"body": respBodyForLog}),
-				zap.Error(nil), // Placeholder for actual error logging
+			handlerName := state.getHandler()
+			if handlerName == "" && cfg.HandlerNameFunc != nil {
+				handlerName = cfg.HandlerNameFunc(r)
+			}
+
+			respFields := []zap.Field{
+				projection.field(LogFieldMethod, zap.String(keys.method, r.Method)),
+				projection.field(LogFieldPath, zap.String(keys.path, logPath)),
+				projection.field(LogFieldStatus, zap.Int(keys.status, status)),
+				projection.field(LogFieldLatency, zap.Int64("latency_ms", latency.Milliseconds())),
+				projection.field(LogFieldLatency, zap.String("latency_bucket", buckets.bucket(latency))),
+			}
+			respFields = append(respFields,
+				optionalString("operation", state.getOperation()),
+				optionalString("handler", handlerName),
+				bodyHashField("response_body_sha256", rw.capturedBody(), cfg.HashBodies && !cfg.HashBodiesInsteadOfLog),
+				omittedBodySizeField("resp_body_bytes", cfg.LogOmittedBodySize,
+					bodyOmitted != "" || cfg.HashBodiesInsteadOfLog || !projection.includes(LogFieldRespBody), int64(rw.bytes)),
 			)
+			if rw.streamed {
+				respFields = append(respFields, zap.Int("response_bytes", rw.bytes))
+			}
+			if slow {
+				respFields = append(respFields, zap.Bool("slow", true))
+			}
+			if timedOut {
+				respFields = append(respFields, zap.Bool("timeout", true))
+			}
+			if !sampled {
+				respFields = append(respFields, zap.Bool("sampled", false))
+			}
+
+			// Promote error envelope fields (from the redacted body) to the top level
+			respFields = append(respFields, errorEnvelopeFields(status, redactedRespBody, cfg.ErrorEnvelopeFields)...)
+
+			// Trailers (e.g. Grpc-Status) are redacted like headers
+			if trailers := responseTrailers(rw.Header()); len(trailers) > 0 && detailed {
+				redactedTrailers := redactHeaders(trailers, route.redactKeys, cfg.DropKeys, cfg.AsyncCore)
+				if sanitize {
+					redactedTrailers = sanitizeHeaders(redactedTrailers)
+				}
+				respFields = append(respFields, keys.trailers(truncateHeaderValues(redactedTrailers, cfg.MaxHeaderValueLogBytes)))
+			}
+
+			if validation := state.getValidationErrors(); len(validation) > 0 {
+				respFields = append(respFields, zap.Array("validation_errors", validation))
+			}
+
+			if warnCount > 0 {
+				respFields = append(respFields, zap.Int("warn_count", warnCount))
+			}
+			if errorCount > 0 {
+				respFields = append(respFields, zap.Int("error_count", errorCount))
+			}
+
+			// Responses outside the detail sample only log metadata
+			if detailed {
+				var contentType string
+				if projection.includes(LogFieldHeaders) {
+					contentType = rw.Header().Get("Content-Type")
+				}
+				if sanitize {
+					contentType = sanitizeString(contentType)
+				}
+				respFields = append(respFields, keys.response(httpResponseLog{
+					body:             respBodyForLog,
+					bodyRaw:          respBodyRaw,
+					bodySHA256:       respBodyHash,
+					bodyOmitted:      bodyOmitted,
+					bodyTruncated:    respBodyTruncated && bodyOmitted == "" && !cfg.HashBodiesInsteadOfLog,
+					redactionSkipped: respRedactionSkipped,
+					redactionApplied: respRedactionApplied,
+					contentType:      contentType,
+					skipBody:         !projection.includes(LogFieldRespBody),
+				}))
+			} else {
+				respFields = append(respFields, zap.Bool("detailed", false))
+			}
+			// With stacktrace_on_5xx, only server errors carry a stack trace, whatever the
+			// level they are logged at: the panic's when Recovery caught one
+			if cfg.StacktraceOn5xx {
+				entryLogger = entryLogger.WithOptions(zap.AddStacktrace(neverEnabled))
+				if status >= 500 {
+					if panicStack := state.getPanicStack(); panicStack != nil {
+						respFields = append(respFields, zap.ByteString("stacktrace", panicStack))
+					} else {
+						respFields = append(respFields, zap.Stack("stacktrace"))
+					}
+				}
+			}
+
+			respFields = append(respFields, zap.Error(state.getError()))
+			entryLogger.Log(level, messages.ResponseMsg, respFields...)
 		})
 	}
 }
@@ -1,13 +1,25 @@
 package smartlog
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -193,3 +205,1795 @@ func TestServerLogging_SkipPath(t *testing.T) {
 	// Assert that logs were recorded for the non-skipped path
 	assert.Equal(t, 2, recorded.Len(), "Should record logs for a non-skipped path")
 }
+
+func TestServerLogging_GzipRequestBody(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	cfg := &Config{
+		RedactKeys: []string{"password"},
+	}
+
+	// Compress a JSON body containing a sensitive field
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(`{"user":"test","password":"sensitive"}`))
+	zw.Close()
+	compressedBytes := compressed.Bytes()
+
+	// The handler must still receive the original compressed stream
+	var handlerBody []byte
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	})
+	wrappedHandler := ServerLogging(logger, cfg)(testHandler)
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", bytes.NewReader(compressedBytes))
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	wrappedHandler.ServeHTTP(rr, req)
+
+	assert.Equal(t, compressedBytes, handlerBody, "Handler should receive the original compressed body")
+
+	require.Equal(t, 2, recorded.Len())
+	reqField, ok := recorded.All()[0].ContextMap()["request"].(map[string]interface{})
+	require.True(t, ok, "request field should be a map")
+	reqBody, ok := reqField["body"].(json.RawMessage)
+	require.True(t, ok, "request body should be a json.RawMessage, got %T", reqField["body"])
+
+	var reqData map[string]interface{}
+	require.NoError(t, json.Unmarshal(reqBody, &reqData), "Logged body should be decoded JSON")
+	assert.Equal(t, "test", reqData["user"])
+	assert.Equal(t, redactionPlaceholder, reqData["password"], "Sensitive field should be redacted")
+}
+
+// asyncCore is a zapcore.Core that encodes entries on a separate goroutine after Write
+// returns, simulating a queue-backed core.
+type asyncCore struct {
+	zapcore.LevelEnabler
+	wg *sync.WaitGroup
+}
+
+func (c *asyncCore) With(fields []zapcore.Field) zapcore.Core { return c }
+
+func (c *asyncCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *asyncCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		enc := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+		buf, _ := enc.EncodeEntry(ent, fields)
+		buf.Free()
+	}()
+	return nil
+}
+
+func (c *asyncCore) Sync() error { return nil }
+
+// TestServerLogging_AsyncCoreSnapshotsHeaders is a regression test for the
+// no-redaction fast path; run it with -race.
+func TestServerLogging_AsyncCoreSnapshotsHeaders(t *testing.T) {
+	var wg sync.WaitGroup
+	logger := zap.New(&asyncCore{LevelEnabler: zapcore.InfoLevel, wg: &wg})
+
+	// No redact keys, so the headers would otherwise be shared with the log entry
+	cfg := &Config{AsyncCore: true}
+
+	// The handler mutates the request headers while the entry may still be encoding
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 100; i++ {
+			r.Header.Set("X-Mutated", "value")
+			r.Header.Del("X-Mutated")
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	wrappedHandler := ServerLogging(logger, cfg)(testHandler)
+
+	req := httptest.NewRequest(http.MethodGet, "/async", nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	wrappedHandler.ServeHTTP(rr, req)
+	wg.Wait()
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestRedactHeaders_Snapshot(t *testing.T) {
+	headers := http.Header{"Accept": []string{"application/json"}}
+
+	shared := redactHeaders(headers, nil, nil, false)
+	shared.Set("Accept", "text/plain")
+	assert.Equal(t, "text/plain", headers.Get("Accept"), "Synchronous no-op redaction should share the original headers")
+
+	headers.Set("Accept", "application/json")
+	snapshot := redactHeaders(headers, nil, nil, true)
+	snapshot.Set("Accept", "text/plain")
+	assert.Equal(t, "application/json", headers.Get("Accept"), "Snapshot should not alias the original headers")
+}
+
+func TestServerLogging_LogIDFromContextKey(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	type upstreamKey string
+	cfg := &Config{
+		LogIDContextKeys: []interface{}{upstreamKey("missing"), upstreamKey("requestID")},
+	}
+
+	var handlerLogID string
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerLogID, _ = r.Context().Value(LogIDKey).(string)
+		w.WriteHeader(http.StatusOK)
+	})
+	wrappedHandler := ServerLogging(logger, cfg)(testHandler)
+
+	// An upstream middleware has already stored an ID under its own context key
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req = req.WithContext(context.WithValue(req.Context(), upstreamKey("requestID"), "upstream-id"))
+	req.Header.Set(HeaderLogID, "header-id")
+	wrappedHandler.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Equal(t, 2, recorded.Len())
+	assert.Equal(t, "upstream-id", recorded.All()[0].ContextMap()["log_id"], "Context key should take precedence over the header")
+	assert.Equal(t, "upstream-id", handlerLogID)
+}
+
+func TestServerLogging_ScalarJSONBodies(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	cfg := &Config{RedactKeys: []string{"password"}}
+
+	handler := ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`42`))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(`"hello"`)))
+
+	logs := recorded.All()
+	require.Len(t, logs, 2)
+	reqBody := logs[0].ContextMap()["request"].(map[string]interface{})["body"]
+	assert.JSONEq(t, `"hello"`, string(reqBody.(json.RawMessage)))
+	respBody := logs[1].ContextMap()["response"].(map[string]interface{})["body"]
+	assert.JSONEq(t, `42`, string(respBody.(json.RawMessage)))
+}
+
+func TestServerLogging_NonJSONBodies(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zap.New(zapcore.NewCore(
+		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.AddSync(&buf),
+		zapcore.InfoLevel,
+	))
+	cfg := &Config{MaxBodyLogBytes: 8}
+
+	handler := ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("plain text response"))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("not json")))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var requestLog, responseLog map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &requestLog), "the request log line should be valid JSON")
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &responseLog), "the response log line should be valid JSON")
+
+	request := requestLog["request"].(map[string]interface{})
+	assert.Equal(t, "not json", request["body_raw"])
+	assert.NotContains(t, request, "body")
+
+	response := responseLog["response"].(map[string]interface{})
+	assert.Equal(t, "plain te"+truncatedSuffix, response["body_raw"])
+}
+
+func TestServerLogging_TruncatesLargeJSONBodies(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	cfg := &Config{MaxBodyLogBytes: 40, RedactKeys: []string{"password"}}
+
+	handler := ServerLogging(zap.New(core), cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	body := `{"password":"hunter2","items":[1,2,3,4,5,6,7,8,9,10,11,12,13,14,15]}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(body)))
+	assert.Equal(t, body, rec.Body.String(), "The handler still gets the whole body")
+
+	logs := recorded.All()
+	require.Len(t, logs, 2)
+	for _, entry := range logs {
+		object := entry.ContextMap()["request"]
+		if entry.Message == defaultResponseMessage {
+			object = entry.ContextMap()["response"]
+		}
+		fields := object.(map[string]interface{})
+		assert.JSONEq(t, `{"password":"[REDACTED]","items":[1,2]}`, string(fields["body"].(json.RawMessage)))
+		assert.Equal(t, true, fields["body_truncated"])
+	}
+}
+
+func TestServerLogging_DefaultHeaderRedaction(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer secret-token")
+	req.Header.Set("Cookie", "session=abc")
+	req.Header.Set("X-Api-Key", "key-123")
+	req.Header.Set("Accept", "application/json")
+
+	t.Run("Redacted without configuration", func(t *testing.T) {
+		ServerLogging(logger, &Config{})(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+		headers := recorded.All()[0].ContextMap()["request"].(map[string]interface{})["headers"].(http.Header)
+		assert.Equal(t, redactionPlaceholder, headers.Get("Authorization"))
+		assert.Equal(t, redactionPlaceholder, headers.Get("Cookie"))
+		assert.Equal(t, redactionPlaceholder, headers.Get("X-Api-Key"))
+		assert.Equal(t, "application/json", headers.Get("Accept"))
+		assert.Equal(t, "Bearer secret-token", req.Header.Get("Authorization"), "The real request should be untouched")
+		recorded.TakeAll()
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		cfg := &Config{DisableDefaultHeaderRedaction: true, RedactKeys: []string{"Cookie"}}
+		ServerLogging(logger, cfg)(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+		headers := recorded.All()[0].ContextMap()["request"].(map[string]interface{})["headers"].(http.Header)
+		assert.Equal(t, "Bearer secret-token", headers.Get("Authorization"))
+		assert.Equal(t, redactionPlaceholder, headers.Get("Cookie"))
+		recorded.TakeAll()
+	})
+}
+
+func TestServerLogging_RedactPathSegments(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	cfg := &Config{
+		RedactPathSegments: []string{`^eyJ[A-Za-z0-9_.-]+$`},
+	}
+
+	var handlerPath string
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerPath = r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	})
+	wrappedHandler := ServerLogging(logger, cfg)(testHandler)
+
+	req := httptest.NewRequest(http.MethodGet, "/reset-password/eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxIn0.sig", nil)
+	wrappedHandler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "/reset-password/eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxIn0.sig", handlerPath, "The real request path should be untouched")
+
+	require.Equal(t, 2, recorded.Len())
+	for _, entry := range recorded.All() {
+		assert.Equal(t, "/reset-password/[REDACTED]", entry.ContextMap()["path"])
+	}
+}
+
+func TestServerLogging_SkipFunc(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	// Skip all CORS preflight requests
+	cfg := &Config{
+		SkipFunc: func(r *http.Request) bool {
+			return r.Method == http.MethodOptions
+		},
+	}
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	wrappedHandler := ServerLogging(logger, cfg)(testHandler)
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/data", nil)
+	rr := httptest.NewRecorder()
+	wrappedHandler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNoContent, rr.Code, "Handler should be called even if logging is skipped")
+	assert.Equal(t, 0, recorded.Len(), "Should not record any logs for a skipped request")
+
+	req = httptest.NewRequest(http.MethodGet, "/api/data", nil)
+	wrappedHandler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, 2, recorded.Len(), "Should record logs for a request that isn't skipped")
+}
+
+func TestServerLogging_SkipMethods(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	var logID string
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logID, _ = r.Context().Value(LogIDKey).(string)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	wrappedHandler := ServerLogging(logger, &Config{SkipMethods: []string{"options", http.MethodHead}})(testHandler)
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/data", nil)
+	req.Header.Set(HeaderLogID, "preflight-id")
+	rr := httptest.NewRecorder()
+	wrappedHandler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "preflight-id", logID, "The log ID should still be injected")
+	assert.Equal(t, 0, recorded.Len(), "Should not record any logs for a skipped method")
+
+	req = httptest.NewRequest(http.MethodPost, "/api/data", strings.NewReader(`{"a":1}`))
+	wrappedHandler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, 2, recorded.Len(), "Should record logs for a method that isn't skipped")
+}
+
+func TestServerLogging_SanitizesControlChars(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set("User-Agent", "curl\n{\"message\":\"forged\"}\x1b[31m")
+
+	t.Run("Escaped by default", func(t *testing.T) {
+		ServerLogging(logger, &Config{})(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+		headers := recorded.All()[0].ContextMap()["request"].(map[string]interface{})["headers"].(http.Header)
+		assert.Equal(t, `curl\n{"message":"forged"}\x1b[31m`, headers.Get("User-Agent"))
+		assert.Equal(t, "curl\n{\"message\":\"forged\"}\x1b[31m", req.Header.Get("User-Agent"), "The real request should be untouched")
+		recorded.TakeAll()
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		disabled := false
+		ServerLogging(logger, &Config{SanitizeControlChars: &disabled})(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+		headers := recorded.All()[0].ContextMap()["request"].(map[string]interface{})["headers"].(http.Header)
+		assert.Equal(t, "curl\n{\"message\":\"forged\"}\x1b[31m", headers.Get("User-Agent"))
+		recorded.TakeAll()
+	})
+}
+
+func BenchmarkServerLogging(b *testing.B) {
+	logger := zap.New(zapcore.NewCore(
+		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.AddSync(io.Discard),
+		zapcore.InfoLevel,
+	))
+	cfg := &Config{RedactKeys: []string{"password"}}
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	})
+	wrappedHandler := ServerLogging(logger, cfg)(testHandler)
+	reqBody := []byte(`{"user":"test","password":"sensitive"}`)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/bench", bytes.NewReader(reqBody))
+		req.Header.Set("Content-Type", "application/json")
+		wrappedHandler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+// BenchmarkServerLogging_LargeBody measures a 5MB JSON request through the middleware. The
+// body is buffered in full either way, for the handler; the limit saves decoding it all.
+func BenchmarkServerLogging_LargeBody(b *testing.B) {
+	logger := zap.New(zapcore.NewCore(
+		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.AddSync(io.Discard),
+		zapcore.InfoLevel,
+	))
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	reqBody := largeJSONBody(5 << 20)
+
+	for name, limit := range map[string]int{"No limit": 0, "Limit 4KB": 4 << 10} {
+		b.Run(name, func(b *testing.B) {
+			cfg := &Config{RedactKeys: []string{"password"}, MaxBodyLogBytes: limit}
+			wrappedHandler := ServerLogging(logger, cfg)(testHandler)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				req := httptest.NewRequest(http.MethodPost, "/bench", bytes.NewReader(reqBody))
+				req.Header.Set("Content-Type", "application/json")
+				wrappedHandler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		})
+	}
+}
+
+func BenchmarkServerLogging_LevelAboveInfo(b *testing.B) {
+	logger := zap.New(zapcore.NewCore(
+		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.AddSync(io.Discard),
+		zapcore.ErrorLevel,
+	))
+	cfg := &Config{RedactKeys: []string{"password"}}
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	})
+	wrappedHandler := ServerLogging(logger, cfg)(testHandler)
+	reqBody := []byte(`{"user":"test","password":"sensitive"}`)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/bench", bytes.NewReader(reqBody))
+		req.Header.Set("Content-Type", "application/json")
+		wrappedHandler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func TestServerLogging_LevelAboveInfo(t *testing.T) {
+	core, recorded := observer.New(zapcore.ErrorLevel)
+	logger := zap.New(core)
+
+	var received string
+	handler := ServerLogging(logger, &Config{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		if r.URL.Path == "/fail" {
+			r.Context().Value(LoggerKey).(*zap.Logger).Error("payment declined")
+		}
+		w.Write([]byte(`{"ok":false}`))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/ok", strings.NewReader(`{"a":1}`)))
+	assert.Equal(t, `{"a":1}`, received, "the handler still gets the body")
+	assert.Equal(t, 0, recorded.Len(), "nothing is logged below the logger's level")
+
+	// The error path is still logged, without the body that wasn't captured
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/fail", nil))
+	responses := recorded.FilterMessage(defaultResponseMessage).All()
+	require.Len(t, responses, 1)
+	assert.Equal(t, zapcore.ErrorLevel, responses[0].Level)
+	assert.Equal(t, "level", responses[0].ContextMap()["response"].(map[string]interface{})["body_omitted"])
+}
+
+func TestServerLogging_CustomMessages(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	cfg := &Config{
+		RequestMessage:  "http.request",
+		ResponseMessage: "http.response",
+	}
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	ServerLogging(logger, cfg)(testHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+
+	require.Equal(t, 2, recorded.Len())
+	assert.Equal(t, "http.request", recorded.All()[0].Message)
+	assert.Equal(t, "http.response", recorded.All()[1].Message)
+}
+
+func TestServerLogging_MessagesConfig(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	cfg := &Config{
+		RequestMessage: "legacy.request",
+		Messages:       MessagesConfig{RequestMsg: "http.request"},
+	}
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	ServerLogging(logger, cfg)(testHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+
+	require.Equal(t, 2, recorded.Len())
+	assert.Equal(t, "http.request", recorded.All()[0].Message, "Messages takes precedence over request_message")
+	assert.Equal(t, defaultResponseMessage, recorded.All()[1].Message, "Unset messages keep the default")
+}
+
+func TestServerLogging_RedactByEnv(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	for _, env := range []string{"dev", "prod"} {
+		cfg := &Config{
+			Env:         env,
+			RedactKeys:  []string{"password"},
+			RedactByEnv: map[string][]string{"prod": {"email"}},
+		}
+		handler := ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/users",
+			strings.NewReader(`{"email":"jules@example.com","password":"hunter2"}`)))
+	}
+
+	logs := recorded.FilterMessage(defaultRequestMessage).All()
+	require.Len(t, logs, 2)
+	devBody := logs[0].ContextMap()["request"].(map[string]interface{})["body"]
+	prodBody := logs[1].ContextMap()["request"].(map[string]interface{})["body"]
+	assert.JSONEq(t, `{"email":"jules@example.com","password":"[REDACTED]"}`, string(devBody.(json.RawMessage)))
+	assert.JSONEq(t, `{"email":"[REDACTED]","password":"[REDACTED]"}`, string(prodBody.(json.RawMessage)))
+}
+
+func TestServerLogging_Proto(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	handler := ServerLogging(zap.New(core), &Config{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+
+	logs := recorded.FilterMessage(defaultRequestMessage).All()
+	require.Len(t, logs, 1)
+	assert.Equal(t, "HTTP/1.1", logs[0].ContextMap()["proto"])
+}
+
+func TestServerLogging_KeepsLargeIntegerIDs(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	cfg := &Config{RedactKeys: []string{"password"}}
+	handler := ServerLogging(zap.New(core), cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	body := `{"id":10000000000000001,"password":"hunter2","price":12.50}`
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body)))
+
+	logs := recorded.All()
+	require.Len(t, logs, 2)
+	for _, entry := range logs {
+		object := entry.ContextMap()["request"]
+		if entry.Message == defaultResponseMessage {
+			object = entry.ContextMap()["response"]
+		}
+		logged := string(object.(map[string]interface{})["body"].(json.RawMessage))
+		assert.Contains(t, logged, `"id":10000000000000001`, "The id must not go through float64")
+		assert.Contains(t, logged, `"price":12.50`)
+		assert.Contains(t, logged, `"password":"[REDACTED]"`)
+	}
+}
+
+func TestServerLogging_StacktraceOn5xx(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	// The logger would attach a stack to every error-level entry on its own
+	logger := zap.New(core, zap.AddStacktrace(zapcore.ErrorLevel))
+	cfg := &Config{StacktraceOn5xx: true}
+
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		wantStatus     int
+		wantStacktrace bool
+		wantPanicStack bool
+	}{
+		{
+			name: "Server error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				Error(r.Context(), "database unavailable")
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			wantStatus:     http.StatusInternalServerError,
+			wantStacktrace: true,
+		},
+		{
+			name: "Client error logged at error level",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				Error(r.Context(), "invalid payload")
+				w.WriteHeader(http.StatusBadRequest)
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Recovered panic",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			},
+			wantStatus:     http.StatusInternalServerError,
+			wantStacktrace: true,
+			wantPanicStack: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Chain(ServerLogging(logger, cfg), Recovery(logger))(tt.handler)
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/orders", nil))
+
+			logs := recorded.FilterMessage(defaultResponseMessage).All()
+			require.Len(t, logs, 1)
+			assert.Equal(t, zapcore.ErrorLevel, logs[0].Level)
+			assert.Empty(t, logs[0].Stack, "The logger's own stack trace is left out")
+			fields := logs[0].ContextMap()
+			assert.EqualValues(t, tt.wantStatus, fields["status"])
+			if !tt.wantStacktrace {
+				assert.NotContains(t, fields, "stacktrace")
+			} else {
+				require.Contains(t, fields, "stacktrace")
+				stack := fmt.Sprint(fields["stacktrace"])
+				assert.Equal(t, tt.wantPanicStack, strings.Contains(stack, "runtime/debug.Stack"), "The panic's stack is used when there is one")
+			}
+			recorded.TakeAll()
+		})
+	}
+}
+
+func TestServerLogging_SharesLogIDWithClientCalls(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	cfg := &Config{}
+
+	var downstreamLogID string
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downstreamLogID = r.Header.Get(HeaderLogID)
+	}))
+	defer downstream.Close()
+
+	client := &http.Client{Transport: NewClientLogger(http.DefaultTransport, logger, cfg)}
+	handler := ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, downstream.URL+"/inventory", nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}))
+
+	// No X-Log-ID header: the server generates the ID
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/orders", nil))
+
+	logs := recorded.All()
+	messages := make([]string, 0, len(logs))
+	for _, entry := range logs {
+		messages = append(messages, entry.Message)
+	}
+	require.Equal(t, []string{defaultRequestMessage, defaultClientRequestMessage, defaultClientResponseMessage, defaultResponseMessage}, messages)
+
+	logID, _ := logs[0].ContextMap()["log_id"].(string)
+	require.NotEmpty(t, logID)
+	for _, entry := range logs {
+		fields := entry.ContextMap()
+		assert.Equal(t, logID, fields["log_id"], "%q carries the server's log_id", entry.Message)
+		logIDFields := 0
+		for _, field := range entry.Context {
+			if field.Key == "log_id" {
+				logIDFields++
+			}
+		}
+		assert.Equal(t, 1, logIDFields, "%q carries log_id once", entry.Message)
+	}
+	assert.Equal(t, logID, downstreamLogID, "The ID is propagated to the downstream service")
+}
+
+func TestServerLogging_GraphQL(t *testing.T) {
+	const body = `{"query":"mutation Login($email: String!, $password: String!) { login(email: $email, password: $password) { token } }",` +
+		`"operationName":"Login","variables":{"email":"jules@example.com","password":"hunter2"}}`
+
+	logRequest := func(t *testing.T, cfg *Config) map[string]interface{} {
+		core, recorded := observer.New(zapcore.InfoLevel)
+		handler := ServerLogging(zap.New(core), cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		logs := recorded.FilterMessage(defaultRequestMessage).All()
+		require.Len(t, logs, 1)
+		return logs[0].ContextMap()
+	}
+
+	t.Run("Logs the operation and redacts variables", func(t *testing.T) {
+		fields := logRequest(t, &Config{RedactKeys: []string{"password"}})
+		assert.Equal(t, "mutation", fields["graphql_operation_type"])
+		assert.Equal(t, "Login", fields["graphql_operation"])
+
+		var logged map[string]interface{}
+		require.NoError(t, json.Unmarshal(fields["request"].(map[string]interface{})["body"].(json.RawMessage), &logged))
+		assert.Equal(t, map[string]interface{}{"email": "jules@example.com", "password": "[REDACTED]"}, logged["variables"])
+		assert.Contains(t, logged["query"], "mutation Login")
+	})
+
+	t.Run("Strips the query", func(t *testing.T) {
+		fields := logRequest(t, &Config{RedactKeys: []string{"password"}, GraphQLStripQuery: true})
+		assert.Equal(t, "Login", fields["graphql_operation"])
+		assert.JSONEq(t, `{"operationName":"Login","variables":{"email":"jules@example.com","password":"[REDACTED]"}}`,
+			string(fields["request"].(map[string]interface{})["body"].(json.RawMessage)))
+	})
+
+	t.Run("Leaves bodies over the log limit alone", func(t *testing.T) {
+		fields := logRequest(t, &Config{GraphQLStripQuery: true, MaxBodyLogBytes: 64})
+		assert.NotContains(t, fields, "graphql_operation_type")
+		assert.Equal(t, true, fields["request"].(map[string]interface{})["body_truncated"])
+	})
+}
+
+func TestServerLogging_CaptureResponseBodyOnError(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	cfg := &Config{CaptureResponseBodyOnError: true}
+
+	var rw *responseWriter
+	handler := ServerLogging(zap.New(core), cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw = r.Context().Value(responseKey).(*responseWriter)
+		if r.URL.Path == "/fail" {
+			SetError(r.Context(), errors.New("payment declined"))
+			w.WriteHeader(http.StatusPaymentRequired)
+			w.Write([]byte(`{"error":"payment declined"}`))
+			return
+		}
+		w.Write([]byte(`{"id":"order-1"}`))
+	}))
+
+	t.Run("Success skips the capture", func(t *testing.T) {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/orders", nil))
+		assert.Empty(t, rw.capturedBody(), "Writes aren't buffered without an error")
+
+		logs := recorded.FilterMessage(defaultResponseMessage).All()
+		require.Len(t, logs, 1)
+		fields := logs[0].ContextMap()
+		assert.NotContains(t, fields, "error")
+		response := fields["response"].(map[string]interface{})
+		assert.Equal(t, "no_error", response["body_omitted"])
+		assert.NotContains(t, response, "body")
+		recorded.TakeAll()
+	})
+
+	t.Run("Error retains the body", func(t *testing.T) {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/fail", nil))
+		assert.Equal(t, `{"error":"payment declined"}`, string(rw.capturedBody()))
+
+		logs := recorded.FilterMessage(defaultResponseMessage).All()
+		require.Len(t, logs, 1)
+		fields := logs[0].ContextMap()
+		assert.Equal(t, "payment declined", fields["error"])
+		response := fields["response"].(map[string]interface{})
+		assert.JSONEq(t, `{"error":"payment declined"}`, string(response["body"].(json.RawMessage)))
+		assert.NotContains(t, response, "body_omitted")
+	})
+}
+
+func TestServerLogging_MaxHeaderValueLogBytes(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	cfg := &Config{MaxHeaderValueLogBytes: 1024}
+
+	huge := strings.Repeat("a", 64*1024)
+	var handlerValue string
+	handler := ServerLogging(zap.New(core), cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerValue = r.Header.Get("X-Huge")
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Huge", huge)
+	req.Header.Set("X-Small", "ok")
+	// Escaping turns each control character into 4 bytes; the value fits the limit before escaping
+	req.Header.Set("X-Control", strings.Repeat("\x01", 1000))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, huge, handlerValue, "The handler sees the real value")
+
+	logs := recorded.FilterMessage(defaultRequestMessage).All()
+	require.Len(t, logs, 1)
+	headers := logs[0].ContextMap()["request"].(map[string]interface{})["headers"].(http.Header)
+	assert.Equal(t, strings.Repeat("a", 1024)+"...(truncated)", headers.Get("X-Huge"))
+	assert.Equal(t, "ok", headers.Get("X-Small"))
+	assert.Len(t, headers.Get("X-Control"), 1024+len("...(truncated)"), "Values are cut after escaping")
+	assert.True(t, strings.HasPrefix(headers.Get("X-Control"), `\x01\x01`))
+}
+
+func TestServerLogging_LogOmittedBodySize(t *testing.T) {
+	const reqBody = `{"card":"4111111111111111"}`
+	const respBody = `{"status":"approved"}`
+	noBody, noDetail := false, 0.0
+	writeBody := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(respBody)) })
+
+	tests := []struct {
+		name            string
+		cfg             Config
+		handler         http.HandlerFunc
+		wantReqBytes    bool
+		wantRespBytes   bool
+		wantRespOmitted string
+	}{
+		{
+			name:          "Route omits the bodies",
+			cfg:           Config{RouteOverrides: map[string]RouteConfig{"/payments": {LogRequestBody: &noBody, LogResponseBody: &noBody}}},
+			handler:       writeBody,
+			wantReqBytes:  true,
+			wantRespBytes: true,
+		},
+		{
+			name:          "Hashed bodies",
+			cfg:           Config{HashBodiesInsteadOfLog: true},
+			handler:       writeBody,
+			wantReqBytes:  true,
+			wantRespBytes: true,
+		},
+		{
+			name:          "Outside the detail sample",
+			cfg:           Config{DetailSampleRate: &noDetail},
+			handler:       writeBody,
+			wantReqBytes:  true,
+			wantRespBytes: true,
+		},
+		{
+			name:            "Status below the threshold",
+			cfg:             Config{LogResponseBodyOnStatusAtLeast: 400},
+			handler:         writeBody,
+			wantRespBytes:   true,
+			wantRespOmitted: "ok_status",
+		},
+		{
+			name: "Streamed response",
+			cfg:  Config{},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(respBody))
+				w.(http.Flusher).Flush()
+			},
+			wantRespBytes:   true,
+			wantRespOmitted: "streamed",
+		},
+		{
+			name:    "Bodies logged",
+			cfg:     Config{},
+			handler: writeBody,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, recorded := observer.New(zapcore.InfoLevel)
+			cfg := tt.cfg
+			cfg.LogOmittedBodySize = true
+			handler := ServerLogging(zap.New(core), &cfg)(tt.handler)
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader(reqBody)))
+
+			logs := recorded.All()
+			require.Len(t, logs, 2)
+			reqFields, respFields := logs[0].ContextMap(), logs[1].ContextMap()
+			if tt.wantReqBytes {
+				assert.EqualValues(t, len(reqBody), reqFields["req_body_bytes"])
+			} else {
+				assert.NotContains(t, reqFields, "req_body_bytes")
+			}
+			if tt.wantRespBytes {
+				assert.EqualValues(t, len(respBody), respFields["resp_body_bytes"])
+			} else {
+				assert.NotContains(t, respFields, "resp_body_bytes")
+			}
+			if tt.wantRespOmitted != "" {
+				assert.Equal(t, tt.wantRespOmitted, respFields["response"].(map[string]interface{})["body_omitted"])
+			}
+		})
+	}
+
+	t.Run("Not logged by default", func(t *testing.T) {
+		core, recorded := observer.New(zapcore.InfoLevel)
+		handler := ServerLogging(zap.New(core), &Config{HashBodiesInsteadOfLog: true})(writeBody)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader(reqBody)))
+		for _, entry := range recorded.All() {
+			assert.NotContains(t, entry.ContextMap(), "req_body_bytes")
+			assert.NotContains(t, entry.ContextMap(), "resp_body_bytes")
+		}
+	})
+}
+
+func TestServerLogging_UnredactableBodies(t *testing.T) {
+	// Missing closing brace: the password can't be redacted
+	const malformed = `{"user":"jules","password":"hunter2"`
+	serve := func(cfg *Config, body string) (request, response map[string]interface{}) {
+		core, recorded := observer.New(zapcore.InfoLevel)
+		handler := ServerLogging(zap.New(core), cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body)))
+		logs := recorded.All()
+		require.Len(t, logs, 2)
+		return logs[0].ContextMap()["request"].(map[string]interface{}), logs[1].ContextMap()["response"].(map[string]interface{})
+	}
+
+	t.Run("Omitted by default", func(t *testing.T) {
+		request, response := serve(&Config{RedactKeys: []string{"password"}}, malformed)
+		for _, logged := range []map[string]interface{}{request, response} {
+			assert.Equal(t, "redaction_skipped", logged["body_omitted"])
+			assert.Equal(t, "parse_error", logged["redaction_skipped"])
+			assert.Equal(t, false, logged["redaction_applied"])
+			assert.NotContains(t, logged, "body_raw")
+			assert.NotContains(t, fmt.Sprint(logged), "hunter2")
+		}
+	})
+
+	t.Run("Logged as is when allowed", func(t *testing.T) {
+		request, response := serve(&Config{RedactKeys: []string{"password"}, LogUnredactableBodies: true}, malformed)
+		for _, logged := range []map[string]interface{}{request, response} {
+			assert.Equal(t, malformed, logged["body_raw"])
+			assert.Equal(t, "parse_error", logged["redaction_skipped"])
+			assert.Equal(t, false, logged["redaction_applied"])
+			assert.NotContains(t, logged, "body_omitted")
+		}
+	})
+
+	t.Run("Plain text and valid JSON are unaffected", func(t *testing.T) {
+		request, _ := serve(&Config{RedactKeys: []string{"password"}}, "hello")
+		assert.Equal(t, "hello", request["body_raw"])
+		assert.NotContains(t, request, "redaction_skipped")
+
+		assert.NotContains(t, request, "redaction_applied", "Plain text isn't redacted")
+
+		request, response := serve(&Config{RedactKeys: []string{"password"}}, `{"password":"hunter2"}`)
+		assert.JSONEq(t, `{"password":"[REDACTED]"}`, string(request["body"].(json.RawMessage)))
+		assert.Equal(t, true, request["redaction_applied"])
+		assert.Equal(t, true, response["redaction_applied"])
+	})
+
+	t.Run("Not marked when nothing was redacted", func(t *testing.T) {
+		request, response := serve(&Config{DisableDefaultHeaderRedaction: true}, `{"password":"hunter2"}`)
+		assert.JSONEq(t, `{"password":"hunter2"}`, string(request["body"].(json.RawMessage)))
+		assert.NotContains(t, request, "redaction_applied", "No keys are configured")
+		assert.NotContains(t, response, "redaction_applied", "No keys are configured")
+
+		request, _ = serve(&Config{RedactKeys: []string{"password"}}, `42`)
+		assert.NotContains(t, request, "redaction_applied", "A scalar has no keys to redact")
+	})
+}
+
+func TestServerLogging_GeoHeaders(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	cfg := &Config{GeoHeaders: map[string]string{"cf-ipcountry": "geo_country", "X-Geo-City": "geo_city"}}
+	handler := ServerLogging(zap.New(core), cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/products", nil)
+	req.Header.Set("CF-IPCountry", "DE")
+	req.Header.Set("Accept-Language", "de-DE,de;q=0.9,en;q=0.8")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	logs := recorded.FilterMessage(defaultRequestMessage).All()
+	require.Len(t, logs, 1)
+	fields := logs[0].ContextMap()
+	assert.Equal(t, "DE", fields["geo_country"])
+	assert.NotContains(t, fields, "geo_city", "Absent headers are left out")
+	assert.Equal(t, "de-DE,de;q=0.9,en;q=0.8", fields["request"].(map[string]interface{})["accept_language"])
+}
+
+func TestServerLogging_LogFields(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	cfg := &Config{
+		LogFields:  []string{"method", "path", "status"},
+		GeoHeaders: map[string]string{"CF-IPCountry": "geo_country"},
+	}
+
+	handler := ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":7}`))
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"name":"jules"}`))
+	req.Header.Set(HeaderLogID, "req-123")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("CF-IPCountry", "FR")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	logs := recorded.All()
+	require.Len(t, logs, 2)
+
+	reqFields := logs[0].ContextMap()
+	assert.Equal(t, "POST", reqFields["method"])
+	assert.NotContains(t, reqFields, "proto", "proto is selected on its own")
+	assert.Equal(t, "/users", reqFields["path"])
+	assert.NotContains(t, reqFields, "log_id")
+	assert.NotContains(t, reqFields, "geo_country", "Fields read from the headers go with them")
+	assert.NotContains(t, reqFields, "request", "Headers and body aren't projected")
+
+	respFields := logs[1].ContextMap()
+	assert.Equal(t, "POST", respFields["method"])
+	assert.Equal(t, "/users", respFields["path"])
+	assert.Equal(t, int64(http.StatusCreated), respFields["status"])
+	for _, key := range []string{"log_id", "latency_ms", "latency_bucket"} {
+		assert.NotContains(t, respFields, key)
+	}
+	assert.NotContains(t, respFields, "response", "The body and headers aren't projected")
+
+	// proto doesn't need method
+	recorded.TakeAll()
+	cfg = &Config{LogFields: []string{"proto", "path", "headers"}, GeoHeaders: cfg.GeoHeaders}
+	ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(httptest.NewRecorder(), req)
+	reqFields = recorded.All()[0].ContextMap()
+	assert.Equal(t, "HTTP/1.1", reqFields["proto"])
+	assert.NotContains(t, reqFields, "method")
+	assert.Equal(t, "FR", reqFields["geo_country"])
+	assert.Equal(t, "application/json", reqFields["request"].(map[string]interface{})["accept"])
+}
+
+func TestServerLogging_LogFullURL(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	t.Run("Query string is logged and redacted", func(t *testing.T) {
+		cfg := &Config{LogFullURL: true, RedactKeys: []string{"token"}}
+		req := httptest.NewRequest(http.MethodGet, "/search?q=shoes&token=secret&page=2", nil)
+		ServerLogging(logger, cfg)(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+		fields := recorded.All()[0].ContextMap()
+		assert.Equal(t, "/search?q=shoes&token=[REDACTED]&page=2", fields["url"])
+		assert.Equal(t, "/search", fields["path"], "The bare path should still be logged")
+		recorded.TakeAll()
+	})
+
+	t.Run("Proxied request includes scheme and host", func(t *testing.T) {
+		cfg := &Config{LogFullURL: true, TrustProxyHeaders: true}
+		req := httptest.NewRequest(http.MethodGet, "/orders?id=7", nil)
+		req.Header.Set("X-Forwarded-Host", "shop.example.com")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		ServerLogging(logger, cfg)(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, "https://shop.example.com/orders?id=7", recorded.All()[0].ContextMap()["url"])
+		recorded.TakeAll()
+	})
+
+	t.Run("Proxy headers are ignored unless trusted", func(t *testing.T) {
+		cfg := &Config{LogFullURL: true}
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.Header.Set("X-Forwarded-Host", "evil.example.com")
+		ServerLogging(logger, cfg)(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, "/orders", recorded.All()[0].ContextMap()["url"])
+		recorded.TakeAll()
+	})
+
+	t.Run("Absolute-form request includes scheme and host", func(t *testing.T) {
+		cfg := &Config{LogFullURL: true}
+		req := httptest.NewRequest(http.MethodGet, "http://upstream.internal/orders", nil)
+		ServerLogging(logger, cfg)(testHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, "http://upstream.internal/orders", recorded.All()[0].ContextMap()["url"])
+		recorded.TakeAll()
+	})
+}
+
+func TestServerLogging_StreamedResponse(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	// An SSE handler that waits for the client to see each event before sending the next
+	received := make(chan struct{})
+	sseHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []string{"data: one\n\n", "data: two\n\n"} {
+			io.WriteString(w, event)
+			w.(http.Flusher).Flush()
+			<-received
+		}
+	})
+	server := httptest.NewServer(ServerLogging(logger, &Config{})(sseHandler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	for _, want := range []string{"data: one", "data: two"} {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, want+"\n", line, "each event should be forwarded as it is flushed")
+		reader.ReadString('\n')
+		received <- struct{}{}
+	}
+
+	require.Eventually(t, func() bool { return recorded.Len() == 2 }, time.Second, 10*time.Millisecond)
+	entry := recorded.All()[1].ContextMap()
+	assert.Equal(t, "streamed", entry["response"].(map[string]interface{})["body_omitted"])
+	assert.Equal(t, int64(len("data: one\n\ndata: two\n\n")), entry["response_bytes"])
+}
+
+func TestServerLogging_WebSocketUpgrade(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	// A minimal WebSocket handler that hijacks the connection and reports the close
+	wsHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("hijack failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		LogWSClose(r.Context(), 1000, "normal closure")
+	})
+	server := httptest.NewServer(ServerLogging(logger, &Config{})(wsHandler))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Protocol: chat\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"))
+	statusLine, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Contains(t, statusLine, "101")
+
+	// The response log is written once the handler returns
+	require.Eventually(t, func() bool { return recorded.Len() == 3 }, time.Second, 10*time.Millisecond)
+	entries := recorded.All()
+
+	reqFields := entries[0].ContextMap()
+	assert.Equal(t, true, reqFields["websocket"])
+	assert.Equal(t, "chat", reqFields["ws_protocol"])
+
+	assert.Equal(t, "WebSocket closed", entries[1].Message)
+	assert.Equal(t, int64(1000), entries[1].ContextMap()["ws_close_code"])
+	assert.Equal(t, "normal closure", entries[1].ContextMap()["ws_close_reason"])
+	assert.NotEmpty(t, entries[1].ContextMap()["log_id"], "Close log should be correlated with the request")
+
+	assert.Equal(t, int64(http.StatusSwitchingProtocols), entries[2].ContextMap()["status"])
+}
+
+func TestServerLogging_ErrorEnvelopeFields(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	cfg := &Config{
+		RedactKeys: []string{"token"},
+		ErrorEnvelopeFields: map[string]string{
+			"code":          "error_code",
+			"error.message": "error_message",
+			"error.token":   "error_token",
+			"missing.path":  "missing",
+		},
+	}
+
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code":3,"error":{"message":"invalid argument","token":"secret"},"details":[]}`))
+	})
+	ServerLogging(logger, cfg)(testHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/users", nil))
+
+	require.Equal(t, 2, recorded.Len())
+	fields := recorded.All()[1].ContextMap()
+	assert.Equal(t, int64(3), fields["error_code"])
+	assert.Equal(t, "invalid argument", fields["error_message"])
+	assert.Equal(t, redactionPlaceholder, fields["error_token"], "Promoted fields should be redacted")
+	assert.NotContains(t, fields, "missing")
+
+	// Successful responses are left alone
+	recorded.TakeAll()
+	okHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"code":0}`))
+	})
+	ServerLogging(logger, cfg)(okHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+	assert.NotContains(t, recorded.All()[1].ContextMap(), "error_code")
+}
+
+func TestServerLogging_LogRequestAndResponseToggles(t *testing.T) {
+	enabled, disabled := true, false
+	testCases := []struct {
+		name             string
+		logRequest       *bool
+		logResponse      *bool
+		expectedMessages []string
+	}{
+		{name: "Defaults log both", expectedMessages: []string{"Request received", "Response sent"}},
+		{name: "Request only", logRequest: &enabled, logResponse: &disabled, expectedMessages: []string{"Request received"}},
+		{name: "Response only", logRequest: &disabled, logResponse: &enabled, expectedMessages: []string{"Response sent"}},
+		{name: "Neither", logRequest: &disabled, logResponse: &disabled, expectedMessages: nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			core, recorded := observer.New(zapcore.InfoLevel)
+			logger := zap.New(core)
+			cfg := &Config{LogRequest: tc.logRequest, LogResponse: tc.logResponse}
+
+			// The logger and log ID are injected regardless
+			var handlerLogID string
+			var handlerHasLogger bool
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerLogID = LogIDFromContext(r.Context())
+				_, handlerHasLogger = r.Context().Value(LoggerKey).(*zap.Logger)
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/users", nil)
+			req.Header.Set(HeaderLogID, "toggle-id")
+			rr := httptest.NewRecorder()
+			ServerLogging(logger, cfg)(testHandler).ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, "toggle-id", handlerLogID)
+			assert.True(t, handlerHasLogger)
+
+			var messages []string
+			for _, entry := range recorded.All() {
+				messages = append(messages, entry.Message)
+			}
+			assert.Equal(t, tc.expectedMessages, messages)
+		})
+	}
+}
+
+func TestServerLogging_RedactAudit(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	cfg := &Config{
+		RedactKeys:  []string{"Authorization", "password", "token", "ssn"},
+		RedactAudit: true,
+	}
+
+	handler := ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"session":{"token":"resp-secret-token"}}`))
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"user":{"name":"bob","password":"hunter2"}}`))
+	req.Header.Set("Authorization", "Bearer header-secret")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	audits := recorded.FilterMessage("Redaction audit").All()
+	require.Len(t, audits, 1)
+	audit := audits[0].ContextMap()["redaction_audit"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"Authorization", "password", "token"}, audit["matched_keys"])
+	assert.Equal(t, []interface{}{"request.body.user.password", "request.headers.Authorization", "response.body.session.token"}, audit["fields"])
+
+	encoded, err := json.Marshal(audits[0].ContextMap())
+	require.NoError(t, err)
+	for _, secret := range []string{"hunter2", "header-secret", "resp-secret-token"} {
+		assert.NotContains(t, string(encoded), secret)
+	}
+}
+
+func TestServerLogging_StoresRoute(t *testing.T) {
+	logger := zap.NewNop()
+
+	t.Run("Uses the ServeMux pattern when inside a route", func(t *testing.T) {
+		var route string
+		mux := http.NewServeMux()
+		mux.Handle("GET /users/{id}", ServerLogging(logger, &Config{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route = RouteFromContext(r.Context())
+		})))
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/42", nil))
+		assert.Equal(t, "GET /users/{id}", route)
+	})
+
+	t.Run("Uses RouteFunc, falling back to the logged path", func(t *testing.T) {
+		var route string
+		cfg := &Config{RouteFunc: func(r *http.Request) string {
+			if r.URL.Path == "/orders" {
+				return "orders.list"
+			}
+			return ""
+		}}
+		handler := ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route = RouteFromContext(r.Context())
+		}))
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/orders", nil))
+		assert.Equal(t, "orders.list", route)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/other", nil))
+		assert.Equal(t, "/other", route)
+	})
+}
+
+func TestServerLogging_MaxRequestBytes(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	cfg := &Config{MaxRequestBytes: 16}
+
+	handlerCalled := false
+	handler := ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerCalled = true
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+
+	t.Run("Rejects an oversized body", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(`{"data":"way more than sixteen bytes"}`)))
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+		assert.False(t, handlerCalled)
+
+		logs := recorded.TakeAll()
+		require.Len(t, logs, 1)
+		assert.Equal(t, zapcore.WarnLevel, logs[0].Level)
+		assert.Equal(t, "request_too_large", logs[0].ContextMap()["error_kind"])
+		assert.Equal(t, int64(16), logs[0].ContextMap()["max_request_bytes"])
+	})
+
+	t.Run("Passes a body within the limit", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(`{"ok":true}`)))
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.True(t, handlerCalled)
+		assert.Equal(t, `{"ok":true}`, rr.Body.String())
+		assert.Len(t, recorded.TakeAll(), 2)
+	})
+}
+
+// trackingReader records whether its contents have been read.
+type trackingReader struct {
+	io.Reader
+	read bool
+}
+
+func (r *trackingReader) Read(p []byte) (int, error) {
+	r.read = true
+	return r.Reader.Read(p)
+}
+
+func TestServerLogging_ExpectContinue(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	body := &trackingReader{Reader: strings.NewReader(`{"file":"large upload"}`)}
+	var readBeforeHandler bool
+	var received string
+	handler := ServerLogging(logger, &Config{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		readBeforeHandler = body.read
+		data, _ := io.ReadAll(r.Body)
+		received = string(data)
+	}))
+
+	req := httptest.NewRequest(http.MethodPut, "/upload", body)
+	req.Header.Set("Expect", "100-continue")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.False(t, readBeforeHandler, "The middleware shouldn't read the body before the handler")
+	assert.Equal(t, `{"file":"large upload"}`, received)
+
+	logs := recorded.All()
+	require.Len(t, logs, 2)
+	request := logs[0].ContextMap()["request"].(map[string]interface{})
+	assert.Equal(t, "expect_continue", request["body_omitted"])
+	assert.NotContains(t, request, "body")
+}
+
+func TestServerLogging_LogResponseBodyOnStatusAtLeast(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	cfg := &Config{LogResponseBodyOnStatusAtLeast: 400}
+
+	handler := ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid id"}`))
+			return
+		}
+		w.Write([]byte(`{"items":[1,2,3]}`))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/bad", nil))
+
+	responses := recorded.FilterMessage(defaultResponseMessage).All()
+	require.Len(t, responses, 2)
+
+	okResponse := responses[0].ContextMap()["response"].(map[string]interface{})
+	assert.Equal(t, "ok_status", okResponse["body_omitted"])
+	assert.NotContains(t, okResponse, "body")
+
+	badResponse := responses[1].ContextMap()["response"].(map[string]interface{})
+	assert.JSONEq(t, `{"error":"invalid id"}`, string(badResponse["body"].(json.RawMessage)))
+	assert.NotContains(t, badResponse, "body_omitted")
+}
+
+func TestServerLogging_LogTLSInfo(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	handler := ServerLogging(logger, &Config{LogTLSInfo: true})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/secure", nil)
+	req.TLS = &tls.ConnectionState{
+		Version:          tls.VersionTLS13,
+		CipherSuite:      tls.TLS_AES_128_GCM_SHA256,
+		PeerCertificates: []*x509.Certificate{{}},
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/plain", nil))
+
+	requests := recorded.FilterMessage(defaultRequestMessage).All()
+	require.Len(t, requests, 2)
+
+	fields := requests[0].ContextMap()
+	assert.Equal(t, "TLS 1.3", fields["tls_version"])
+	assert.Equal(t, "TLS_AES_128_GCM_SHA256", fields["tls_cipher"])
+	assert.Equal(t, true, fields["tls_client_cert"])
+	assert.NotContains(t, requests[1].ContextMap(), "tls_version")
+}
+
+func TestServerLogging_LogTLSInfo_TLSServer(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	server := httptest.NewTLSServer(ServerLogging(logger, &Config{LogTLSInfo: true})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/secure")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	requests := recorded.FilterMessage(defaultRequestMessage).All()
+	require.Len(t, requests, 1)
+	fields := requests[0].ContextMap()
+	state := resp.TLS
+	assert.Equal(t, tls.VersionName(state.Version), fields["tls_version"])
+	assert.Equal(t, tls.CipherSuiteName(state.CipherSuite), fields["tls_cipher"])
+	assert.NotEmpty(t, fields["tls_cipher"])
+	assert.Equal(t, false, fields["tls_client_cert"])
+}
+
+func TestServerLogging_ResponseTrailers(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	cfg := &Config{RedactKeys: []string{"X-Session-Token"}}
+
+	handler := ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/plain" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.Header().Set("Trailer", "Grpc-Status, X-Session-Token")
+		w.Write([]byte(`{}`))
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("X-Session-Token", "secret")
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "ok")
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/grpc", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/plain", nil))
+
+	responses := recorded.FilterMessage(defaultResponseMessage).All()
+	require.Len(t, responses, 2)
+	assert.Equal(t, http.Header{
+		"Grpc-Status":     {"0"},
+		"Grpc-Message":    {"ok"},
+		"X-Session-Token": {redactionPlaceholder},
+	}, responses[0].ContextMap()["response_trailers"])
+	assert.NotContains(t, responses[1].ContextMap(), "response_trailers")
+}
+
+func TestServerLogging_FlattenFields(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	cfg := &Config{FlattenFields: true, RedactKeys: []string{"password"}}
+
+	handler := ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":7}`))
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"password":"hunter2"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderBaggage, "tenant=acme,Region-Code=eu")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	logs := recorded.All()
+	require.Len(t, logs, 2)
+
+	reqFields := logs[0].ContextMap()
+	assert.Equal(t, "POST", reqFields["request_method"])
+	assert.Equal(t, "/users", reqFields["request_path"])
+	assert.Equal(t, "application/json", reqFields["request_header_content_type"])
+	assert.Equal(t, `{"password":"[REDACTED]"}`, reqFields["request_body"])
+	assert.NotContains(t, reqFields, "request")
+	assert.NotContains(t, reqFields, "method")
+
+	respFields := logs[1].ContextMap()
+	assert.Equal(t, int64(http.StatusCreated), respFields["response_status"])
+	assert.Equal(t, `{"id":7}`, respFields["response_body"])
+	assert.NotContains(t, respFields, "response")
+	assert.NotContains(t, respFields, "status")
+	assert.Equal(t, "0", respFields["response_trailer_grpc_status"])
+	assert.NotContains(t, respFields, "response_trailers")
+
+	for _, entry := range logs {
+		assert.Equal(t, "acme", entry.ContextMap()["baggage_tenant"])
+		assert.Equal(t, "eu", entry.ContextMap()["baggage_region_code"])
+		for key, value := range entry.ContextMap() {
+			switch value.(type) {
+			case map[string]interface{}, http.Header:
+				t.Errorf("field %q should be flat, got %T", key, value)
+			}
+		}
+	}
+}
+
+func TestServerLogging_HashBodies(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	cfg := &Config{HashBodies: true, RedactKeys: []string{"password"}}
+
+	handler := ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	for _, body := range []string{`{"password":"a"}`, `{"password":"a"}`, `{"password":"b"}`} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(body)))
+	}
+
+	requests := recorded.FilterMessage(defaultRequestMessage).All()
+	responses := recorded.FilterMessage(defaultResponseMessage).All()
+	require.Len(t, requests, 3)
+	require.Len(t, responses, 3)
+
+	first := requests[0].ContextMap()["request_body_sha256"]
+	assert.Len(t, first, 64)
+	assert.Equal(t, first, requests[1].ContextMap()["request_body_sha256"], "same body, same hash")
+	assert.NotEqual(t, first, requests[2].ContextMap()["request_body_sha256"], "hash covers the raw, unredacted body")
+	assert.Equal(t, first, responses[0].ContextMap()["response_body_sha256"], "the echoed response hashes the same")
+}
+
+func TestServerLogging_HashBodiesInsteadOfLog(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	cfg := &Config{HashBodiesInsteadOfLog: true}
+
+	handler := ServerLogging(logger, cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	for _, body := range []string{`{"card":"4111"}`, `{"card":"4111"}`, `{"card":"5500"}`} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/pay", strings.NewReader(body)))
+	}
+
+	requests := recorded.FilterMessage(defaultRequestMessage).All()
+	responses := recorded.FilterMessage(defaultResponseMessage).All()
+	require.Len(t, requests, 3)
+	require.Len(t, responses, 3)
+
+	hashes := make([]interface{}, 3)
+	for i, entry := range requests {
+		request := entry.ContextMap()["request"].(map[string]interface{})
+		assert.NotContains(t, request, "body")
+		assert.NotContains(t, request, "body_raw")
+		hashes[i] = request["body_sha256"]
+
+		response := responses[i].ContextMap()["response"].(map[string]interface{})
+		assert.NotContains(t, response, "body")
+		assert.Equal(t, hashes[i], response["body_sha256"], "the echoed response hashes the same")
+	}
+	assert.Len(t, hashes[0], 64)
+	assert.Equal(t, hashes[0], hashes[1], "same body, same hash")
+	assert.NotEqual(t, hashes[0], hashes[2])
+}
+
+func TestServerLogging_LogQueryParams(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zap.New(zapcore.NewCore(
+		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.AddSync(&buf),
+		zapcore.InfoLevel,
+	))
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	target := "/search?page=2&token=secret&tag=go&tag=zap"
+
+	requestLog := func(cfg *Config) map[string]interface{} {
+		buf.Reset()
+		ServerLogging(logger, cfg)(testHandler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+		line, _, _ := strings.Cut(buf.String(), "\n")
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		return entry
+	}
+
+	entry := requestLog(&Config{LogQueryParams: true, RedactKeys: []string{"token"}})
+	assert.Equal(t, map[string]interface{}{
+		"page":  "2",
+		"token": redactionPlaceholder,
+		"tag":   []interface{}{"go", "zap"},
+	}, entry["query"])
+
+	entry = requestLog(&Config{LogQueryParams: true, RedactKeys: []string{"token"}, FlattenFields: true})
+	assert.Equal(t, "2", entry["query_page"])
+	assert.Equal(t, redactionPlaceholder, entry["query_token"])
+	assert.Equal(t, "go, zap", entry["query_tag"])
+
+	entry = requestLog(&Config{})
+	assert.NotContains(t, entry, "query")
+}
+
+func TestServerLogging_ContentNegotiation(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.api+json")
+		w.Write([]byte(`{"ok":true}`))
+	})
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.Header.Set("Accept", "application/vnd.api+json, application/json;q=0.9")
+		return req
+	}
+
+	ServerLogging(logger, &Config{})(testHandler).ServeHTTP(httptest.NewRecorder(), newRequest())
+	logs := recorded.TakeAll()
+	require.Len(t, logs, 2)
+	assert.Equal(t, "application/vnd.api+json, application/json;q=0.9", logs[0].ContextMap()["request"].(map[string]interface{})["accept"])
+	assert.Equal(t, "application/vnd.api+json", logs[1].ContextMap()["response"].(map[string]interface{})["content_type"])
+
+	ServerLogging(logger, &Config{FlattenFields: true})(testHandler).ServeHTTP(httptest.NewRecorder(), newRequest())
+	logs = recorded.TakeAll()
+	require.Len(t, logs, 2)
+	assert.Equal(t, "application/vnd.api+json, application/json;q=0.9", logs[0].ContextMap()["request_accept"])
+	assert.Equal(t, "application/vnd.api+json", logs[1].ContextMap()["response_content_type"])
+}
+
+func TestServerLogging_BodylessRequests(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	var body io.ReadCloser
+	var received string
+	handler := ServerLogging(logger, &Config{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body = r.Body
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+	}))
+
+	t.Run("GET without a body skips the read", func(t *testing.T) {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+
+		assert.Equal(t, http.NoBody, body, "the body shouldn't be read and replaced")
+		request := recorded.TakeAll()[0].ContextMap()["request"].(map[string]interface{})
+		assert.Nil(t, request["body"])
+		assert.NotContains(t, request, "body_raw")
+	})
+
+	t.Run("Body of unknown length is still read", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/users", io.MultiReader(strings.NewReader(`{"name":"jules"}`)))
+		require.Equal(t, int64(-1), req.ContentLength)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, `{"name":"jules"}`, received)
+		request := recorded.TakeAll()[0].ContextMap()["request"].(map[string]interface{})
+		assert.JSONEq(t, `{"name":"jules"}`, string(request["body"].(json.RawMessage)))
+	})
+}
+
+func BenchmarkServerLogging_GET(b *testing.B) {
+	logger := zap.New(zapcore.NewCore(
+		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.AddSync(io.Discard),
+		zapcore.InfoLevel,
+	))
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	wrappedHandler := ServerLogging(logger, &Config{})(testHandler)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		wrappedHandler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func TestServerLogging_TimeoutHandler(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	// The slow handler writes its response only after the timeout has fired
+	slowHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte(`{"late":true}`))
+	})
+
+	t.Run("Enclosing TimeoutHandler", func(t *testing.T) {
+		handler := http.TimeoutHandler(ServerLogging(logger, &Config{})(slowHandler), 20*time.Millisecond, "timed out")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+		// TimeoutHandler returns without waiting for the handler, which is logged once it's done
+		require.Eventually(t, func() bool {
+			return recorded.FilterMessage(defaultResponseMessage).Len() == 1
+		}, time.Second, 5*time.Millisecond)
+		responses := recorded.FilterMessage(defaultResponseMessage).All()
+		assert.Equal(t, zapcore.WarnLevel, responses[0].Level)
+		assert.Equal(t, int64(http.StatusServiceUnavailable), responses[0].ContextMap()["status"])
+		assert.Equal(t, true, responses[0].ContextMap()["timeout"])
+		assert.Equal(t, "timeout", responses[0].ContextMap()["response"].(map[string]interface{})["body_omitted"])
+		recorded.TakeAll()
+	})
+
+	t.Run("Enclosed TimeoutHandler", func(t *testing.T) {
+		handler := ServerLogging(logger, &Config{})(http.TimeoutHandler(slowHandler, 20*time.Millisecond, "timed out"))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+		// The 503 goes through the middleware's writer, so it's captured as is
+		responses := recorded.FilterMessage(defaultResponseMessage).All()
+		require.Len(t, responses, 1)
+		assert.Equal(t, int64(http.StatusServiceUnavailable), responses[0].ContextMap()["status"])
+		recorded.TakeAll()
+	})
+
+	// This handler gives up once the deadline passes, without writing anything
+	giveUp := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+
+	t.Run("Enclosed WithTimeout", func(t *testing.T) {
+		handler := ServerLogging(logger, &Config{})(WithTimeout(20 * time.Millisecond)(giveUp))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		// Nothing answers 503: the status net/http sent is logged
+		responses := recorded.FilterMessage(defaultResponseMessage).All()
+		require.Len(t, responses, 1)
+		assert.Equal(t, zapcore.WarnLevel, responses[0].Level)
+		assert.Equal(t, int64(http.StatusOK), responses[0].ContextMap()["status"])
+		assert.Equal(t, true, responses[0].ContextMap()["timeout"])
+		recorded.TakeAll()
+	})
+
+	t.Run("Deadline without a timeout middleware", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		rec := httptest.NewRecorder()
+		ServerLogging(logger, &Config{})(giveUp).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil).WithContext(ctx))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		responses := recorded.FilterMessage(defaultResponseMessage).All()
+		require.Len(t, responses, 1)
+		assert.Equal(t, zapcore.InfoLevel, responses[0].Level)
+		assert.Equal(t, int64(http.StatusOK), responses[0].ContextMap()["status"])
+		assert.NotContains(t, responses[0].ContextMap(), "timeout")
+		recorded.TakeAll()
+	})
+}
+
+func TestServerLogger_Builder(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	cfg := &Config{RedactKeys: make([]string, 1, 4), DisableDefaultHeaderRedaction: true}
+	cfg.RedactKeys[0] = "password"
+
+	builder := NewServerLogger(logger, cfg).
+		SkipPath("/healthz").
+		RedactKey("ssn").
+		WithRouteFunc(func(r *http.Request) string { return "users" })
+	mw := builder.Handler()
+
+	var route string
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route = RouteFromContext(r.Context())
+	}))
+
+	t.Run("Applies the builder settings", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"password":"p","ssn":"123","name":"jules"}`))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, "users", route)
+		logs := recorded.TakeAll()
+		require.NotEmpty(t, logs)
+		body := logs[0].ContextMap()["request"].(map[string]interface{})["body"].(json.RawMessage)
+		assert.JSONEq(t, `{"password":"[REDACTED]","ssn":"[REDACTED]","name":"jules"}`, string(body))
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		assert.Empty(t, recorded.TakeAll(), "skipped path")
+	})
+
+	t.Run("Leaves the shared config untouched", func(t *testing.T) {
+		assert.Equal(t, []string{"password"}, cfg.RedactKeys)
+		assert.Empty(t, cfg.RedactKeys[:2][1], "the spare capacity isn't written to")
+		assert.Empty(t, cfg.SkipPaths)
+		assert.Nil(t, cfg.RouteFunc)
+	})
+
+	t.Run("Later builder calls don't affect built middlewares", func(t *testing.T) {
+		builder.SkipPath("/users")
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+		assert.Len(t, recorded.TakeAll(), 2)
+	})
+}
@@ -0,0 +1,149 @@
+// Package smartlogtest helps tests assert on the entries smartlog writes to a
+// zaptest/observer core, without digging through nested maps and raw JSON bodies:
+//
+//	core, recorded := observer.New(zapcore.InfoLevel)
+//	handler := smartlog.ServerLogging(zap.New(core), &cfg)(router)
+//	...
+//	smartlogtest.AssertLogged(t, recorded, "Response sent", map[string]interface{}{
+//		"status":           201,
+//		"response.body.id": "order-1",
+//	})
+package smartlogtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// FindEntry returns the first entry logged with message, and false if there is none.
+func FindEntry(recorded *observer.ObservedLogs, message string) (observer.LoggedEntry, bool) {
+	for _, entry := range recorded.All() {
+		if entry.Message == message {
+			return entry, true
+		}
+	}
+	return observer.LoggedEntry{}, false
+}
+
+// AssertLogged checks that an entry was logged with message and the given fields, and
+// reports an error on t otherwise. Other fields of the entry are ignored.
+//
+// Field names are dot-separated paths into nested objects, so "request.headers" and
+// "response.body.user.id" reach into the request and response objects and the JSON bodies
+// they carry. Values are compared by their JSON encoding, so 200 matches a status logged
+// with zap.Int and a map matches a logged object with the same keys. When several entries
+// have the message, any of them may match.
+func AssertLogged(t testing.TB, recorded *observer.ObservedLogs, message string, fields map[string]interface{}) bool {
+	t.Helper()
+
+	var candidates []observer.LoggedEntry
+	for _, entry := range recorded.All() {
+		if entry.Message == message {
+			candidates = append(candidates, entry)
+		}
+	}
+	if len(candidates) == 0 {
+		t.Errorf("smartlogtest: no entry logged with message %q; logged messages: %s", message, loggedMessages(recorded))
+		return false
+	}
+
+	var problems []string
+	for _, entry := range candidates {
+		problems = mismatches(entry, fields)
+		if len(problems) == 0 {
+			return true
+		}
+	}
+	if len(candidates) > 1 {
+		t.Errorf("smartlogtest: none of the %d entries logged with message %q has the expected fields; the last one:\n\t%s",
+			len(candidates), message, strings.Join(problems, "\n\t"))
+	} else {
+		t.Errorf("smartlogtest: the entry logged with message %q doesn't have the expected fields:\n\t%s",
+			message, strings.Join(problems, "\n\t"))
+	}
+	return false
+}
+
+// mismatches describes each expected field that entry is missing or has another value for.
+func mismatches(entry observer.LoggedEntry, fields map[string]interface{}) []string {
+	logged := normalize(entry.ContextMap())
+
+	paths := make([]string, 0, len(fields))
+	for path := range fields {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var problems []string
+	for _, path := range paths {
+		expected := normalize(fields[path])
+		actual, ok := lookup(logged, path)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: missing, expected %s", path, encode(expected)))
+			continue
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			problems = append(problems, fmt.Sprintf("%s: expected %s, got %s", path, encode(expected), encode(actual)))
+		}
+	}
+	return problems
+}
+
+// lookup follows the dot-separated path through nested objects.
+func lookup(value interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// normalize turns v into the value decoding its JSON encoding gives, with numbers kept as
+// json.Number, so that logged and expected values compare equal whatever their Go types.
+// Logged bodies are json.RawMessage and decode into objects like the rest of the entry.
+func normalize(v interface{}) interface{} {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	dec := json.NewDecoder(bytes.NewReader(encoded))
+	dec.UseNumber()
+	var normalized interface{}
+	if err := dec.Decode(&normalized); err != nil {
+		return v
+	}
+	return normalized
+}
+
+func encode(v interface{}) string {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%#v", v)
+	}
+	return string(encoded)
+}
+
+// loggedMessages lists the messages of the recorded entries, for failure reports.
+func loggedMessages(recorded *observer.ObservedLogs) string {
+	entries := recorded.All()
+	if len(entries) == 0 {
+		return "none"
+	}
+	messages := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		messages = append(messages, fmt.Sprintf("%q", entry.Message))
+	}
+	return strings.Join(messages, ", ")
+}
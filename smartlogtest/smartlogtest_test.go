@@ -0,0 +1,111 @@
+package smartlogtest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"smartlog"
+)
+
+// recordingT is a testing.TB recording the failures reported to it instead of failing.
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+// serveOrder logs a request through the server middleware, echoing its JSON body.
+func serveOrder(t *testing.T) *observer.ObservedLogs {
+	t.Helper()
+	core, recorded := observer.New(zapcore.InfoLevel)
+	cfg := &smartlog.Config{RedactKeys: []string{"card"}}
+	handler := smartlog.ServerLogging(zap.New(core), cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"order-1","items":[{"sku":"A1","qty":2}]}`))
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"card":"4111111111111111","qty":2}`))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	return recorded
+}
+
+func TestFindEntry(t *testing.T) {
+	recorded := serveOrder(t)
+
+	entry, ok := FindEntry(recorded, "Response sent")
+	require.True(t, ok)
+	assert.EqualValues(t, http.StatusCreated, entry.ContextMap()["status"])
+
+	_, ok = FindEntry(recorded, "Client request sent")
+	assert.False(t, ok)
+}
+
+func TestAssertLogged(t *testing.T) {
+	recorded := serveOrder(t)
+
+	t.Run("Matching fields", func(t *testing.T) {
+		rt := &recordingT{TB: t}
+		assert.True(t, AssertLogged(rt, recorded, "Response sent", map[string]interface{}{
+			"method":                "POST",
+			"status":                201,
+			"response.body.id":      "order-1",
+			"response.body.items":   []map[string]interface{}{{"sku": "A1", "qty": 2}},
+			"response.content_type": "application/json",
+		}))
+		assert.True(t, AssertLogged(rt, recorded, "Request received", map[string]interface{}{
+			"path":              "/orders",
+			"request.body.card": "[REDACTED]",
+			"request.body.qty":  2,
+		}))
+		assert.Empty(t, rt.errors)
+	})
+
+	t.Run("Reports mismatched and missing fields", func(t *testing.T) {
+		rt := &recordingT{TB: t}
+		ok := AssertLogged(rt, recorded, "Response sent", map[string]interface{}{
+			"status":           200,
+			"response.body.id": "order-1",
+			"operation":        "CreateOrder",
+		})
+		assert.False(t, ok)
+		require.Len(t, rt.errors, 1)
+		assert.Contains(t, rt.errors[0], `status: expected 200, got 201`)
+		assert.Contains(t, rt.errors[0], `operation: missing, expected "CreateOrder"`)
+		assert.NotContains(t, rt.errors[0], "response.body.id")
+	})
+
+	t.Run("Reports a missing message", func(t *testing.T) {
+		rt := &recordingT{TB: t}
+		assert.False(t, AssertLogged(rt, recorded, "Order created", nil))
+		require.Len(t, rt.errors, 1)
+		assert.Contains(t, rt.errors[0], `no entry logged with message "Order created"`)
+		assert.Contains(t, rt.errors[0], `"Request received", "Response sent"`)
+	})
+
+	t.Run("Any entry with the message may match", func(t *testing.T) {
+		core, recorded := observer.New(zapcore.InfoLevel)
+		logger := zap.New(core)
+		logger.Info("Job done", zap.Int("attempt", 1), zap.Bool("ok", false))
+		logger.Info("Job done", zap.Int("attempt", 2), zap.Bool("ok", true))
+
+		rt := &recordingT{TB: t}
+		assert.True(t, AssertLogged(rt, recorded, "Job done", map[string]interface{}{"attempt": 2, "ok": true}))
+		assert.False(t, AssertLogged(rt, recorded, "Job done", map[string]interface{}{"attempt": 3}))
+		require.Len(t, rt.errors, 1)
+		assert.Contains(t, rt.errors[0], "none of the 2 entries")
+	})
+}
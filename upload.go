@@ -0,0 +1,110 @@
+package smartlog
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// defaultUploadScanMaxBytes is how much of a multipart body is scanned for file metadata
+// when Config.UploadScanMaxBytes is unset.
+const defaultUploadScanMaxBytes = 10 << 20
+
+// uploadedFile is the metadata of a file part of a multipart/form-data request. The
+// contents are never logged.
+type uploadedFile struct {
+	field       string
+	filename    string
+	size        int64
+	contentType string
+	// truncated is set when the part extends beyond the scanned bytes, so size is a lower bound.
+	truncated bool
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (f uploadedFile) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("field", f.field)
+	enc.AddString("filename", f.filename)
+	enc.AddInt64("size", f.size)
+	if f.contentType != "" {
+		enc.AddString("content_type", f.contentType)
+	}
+	if f.truncated {
+		enc.AddBool("truncated", true)
+	}
+	return nil
+}
+
+// uploadedFiles is the "files" array of a request log entry.
+type uploadedFiles []uploadedFile
+
+// MarshalLogArray implements zapcore.ArrayMarshaler.
+func (files uploadedFiles) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, f := range files {
+		if err := enc.AppendObject(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// multipartBoundary returns the boundary of a multipart/form-data request, or an empty
+// string for any other request.
+func multipartBoundary(r *http.Request) string {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return ""
+	}
+	return params["boundary"]
+}
+
+// peekBody reads up to maxBytes of the request body and puts them back in front of the rest,
+// so the handler still reads the whole body while no more than maxBytes are buffered.
+func peekBody(r *http.Request, maxBytes int64) []byte {
+	peeked, _ := io.ReadAll(io.LimitReader(r.Body, maxBytes))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(peeked), r.Body), r.Body}
+	return peeked
+}
+
+// scanUploadedFiles lists the file parts of a multipart body, which may be cut short by
+// the scan limit. Form fields without a filename are skipped. Filenames are sanitized when
+// sanitize is set, as they come from the client.
+func scanUploadedFiles(body []byte, boundary string, sanitize bool) uploadedFiles {
+	var files uploadedFiles
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		// Raw parts keep their size as sent, without decoding a transfer encoding
+		part, err := mr.NextRawPart()
+		if err != nil {
+			return files
+		}
+		if part.FileName() == "" {
+			continue
+		}
+
+		f := uploadedFile{
+			field:       part.FormName(),
+			filename:    part.FileName(),
+			contentType: part.Header.Get("Content-Type"),
+		}
+		if sanitize {
+			f.field = sanitizeString(f.field)
+			f.filename = sanitizeString(f.filename)
+			f.contentType = sanitizeString(f.contentType)
+		}
+		f.size, err = io.Copy(io.Discard, part)
+		if err != nil {
+			// The part runs past the scanned bytes
+			f.truncated = true
+			return append(files, f)
+		}
+		files = append(files, f)
+	}
+}
@@ -0,0 +1,96 @@
+package smartlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// newUploadRequest builds a multipart/form-data request with a text field and two files.
+func newUploadRequest(t *testing.T) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	require.NoError(t, mw.WriteField("title", "holiday"))
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="photo"; filename="beach.png"`)
+	header.Set("Content-Type", "image/png")
+	part, err := mw.CreatePart(header)
+	require.NoError(t, err)
+	part.Write([]byte("PNG-SECRET-PIXELS"))
+
+	part, err = mw.CreateFormFile("notes", "notes.txt")
+	require.NoError(t, err)
+	part.Write([]byte("TOP-SECRET-NOTES"))
+	require.NoError(t, mw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestServerLogging_LogUploadFiles(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zap.New(zapcore.NewCore(
+		zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
+		zapcore.AddSync(&buf),
+		zapcore.InfoLevel,
+	))
+
+	var received map[string]string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		received = map[string]string{"title": r.FormValue("title")}
+		for field, headers := range r.MultipartForm.File {
+			f, err := headers[0].Open()
+			require.NoError(t, err)
+			content, _ := io.ReadAll(f)
+			received[field] = string(content)
+		}
+	})
+	requestLog := func(cfg *Config) map[string]interface{} {
+		buf.Reset()
+		ServerLogging(logger, cfg)(handler).ServeHTTP(httptest.NewRecorder(), newUploadRequest(t))
+		line, _, _ := strings.Cut(buf.String(), "\n")
+		assert.NotContains(t, line, "SECRET", "file contents must not be logged")
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		return entry
+	}
+
+	t.Run("Logs file metadata only", func(t *testing.T) {
+		entry := requestLog(&Config{LogUploadFiles: true})
+
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"field": "photo", "filename": "beach.png", "size": float64(17), "content_type": "image/png"},
+			map[string]interface{}{"field": "notes", "filename": "notes.txt", "size": float64(16), "content_type": "application/octet-stream"},
+		}, entry["files"])
+		assert.Equal(t, "multipart", entry["request"].(map[string]interface{})["body_omitted"])
+		assert.Equal(t, map[string]string{"title": "holiday", "photo": "PNG-SECRET-PIXELS", "notes": "TOP-SECRET-NOTES"}, received,
+			"the handler still gets the whole body")
+	})
+
+	t.Run("Marks files past the scan limit as truncated", func(t *testing.T) {
+		// The limit cuts through the photo's contents
+		entry := requestLog(&Config{LogUploadFiles: true, UploadScanMaxBytes: 290})
+
+		files := entry["files"].([]interface{})
+		require.Len(t, files, 1)
+		photo := files[0].(map[string]interface{})
+		assert.Equal(t, "beach.png", photo["filename"])
+		assert.Equal(t, true, photo["truncated"])
+		assert.Less(t, photo["size"], float64(17))
+		assert.Equal(t, "PNG-SECRET-PIXELS", received["photo"])
+	})
+}
@@ -0,0 +1,144 @@
+package smartlog
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// redactQuery redacts the values of query parameters whose names match one of the keys.
+// Parameter order and encoding are otherwise preserved.
+func redactQuery(rawQuery string, keysToRedact []string) string {
+	if rawQuery == "" || len(keysToRedact) == 0 {
+		return rawQuery
+	}
+
+	keyMap := make(map[string]struct{})
+	for _, key := range keysToRedact {
+		keyMap[strings.ToLower(key)] = struct{}{}
+	}
+
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		rawName, _, _ := strings.Cut(param, "=")
+		name := rawName
+		if unescaped, err := url.QueryUnescape(rawName); err == nil {
+			name = unescaped
+		}
+		if _, exists := keyMap[strings.ToLower(name)]; exists {
+			params[i] = rawName + "=" + redactionPlaceholder
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// requestURLForLog reconstructs the request URL for logging from the (already redacted)
+// path and the redacted query. Scheme and host are included for absolute-form requests
+// and, when trustProxy is set, from the X-Forwarded-Host and X-Forwarded-Proto headers.
+func requestURLForLog(r *http.Request, path string, keysToRedact []string, trustProxy bool) string {
+	var scheme, host string
+	if r.URL.IsAbs() {
+		scheme, host = r.URL.Scheme, r.URL.Host
+	} else if trustProxy && r.Header.Get("X-Forwarded-Host") != "" {
+		host = r.Header.Get("X-Forwarded-Host")
+		scheme = r.Header.Get("X-Forwarded-Proto")
+		if scheme == "" {
+			scheme = "http"
+			if r.TLS != nil {
+				scheme = "https"
+			}
+		}
+	}
+
+	// Build the string manually so the placeholder in the query isn't escaped
+	var b strings.Builder
+	if host != "" {
+		b.WriteString(scheme + "://" + host)
+	}
+	b.WriteString(path)
+	if query := redactQuery(r.URL.RawQuery, keysToRedact); query != "" {
+		b.WriteString("?" + query)
+	}
+	return b.String()
+}
+
+// queryParams is the parsed query string of a request, logged as the query field.
+// Parameters with a single value are logged as strings, others as arrays.
+type queryParams url.Values
+
+// parseQueryParams parses rawQuery for logging, redacting the values of parameters named in
+// keysToRedact. Malformed pairs are skipped. It returns nil if there are no parameters.
+func parseQueryParams(rawQuery string, keysToRedact []string, sanitize bool) queryParams {
+	if rawQuery == "" {
+		return nil
+	}
+	values, _ := url.ParseQuery(rawQuery)
+	if len(values) == 0 {
+		return nil
+	}
+
+	keyMap := make(map[string]struct{})
+	for _, key := range keysToRedact {
+		keyMap[strings.ToLower(key)] = struct{}{}
+	}
+
+	params := make(queryParams, len(values))
+	for name, vals := range values {
+		if _, exists := keyMap[strings.ToLower(name)]; exists {
+			vals = []string{redactionPlaceholder}
+		} else if sanitize {
+			for i, v := range vals {
+				vals[i] = sanitizeString(v)
+			}
+		}
+		if sanitize {
+			name = sanitizeString(name)
+		}
+		params[name] = vals
+	}
+	return params
+}
+
+// MarshalLogObject encodes the parameters in name order.
+func (q queryParams) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, name := range q.names() {
+		vals := q[name]
+		if len(vals) == 1 {
+			enc.AddString(name, vals[0])
+			continue
+		}
+		if err := enc.AddArray(name, zapcore.ArrayMarshalerFunc(func(arr zapcore.ArrayEncoder) error {
+			for _, v := range vals {
+				arr.AppendString(v)
+			}
+			return nil
+		})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q queryParams) names() []string {
+	names := make([]string, 0, len(q))
+	for name := range q {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// flatQueryParams encodes the parameters as query_<name> keys, joining multiple values
+// like flattened headers.
+type flatQueryParams queryParams
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (q flatQueryParams) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, name := range queryParams(q).names() {
+		enc.AddString("query_"+name, strings.Join(q[name], ", "))
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package smartlog
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// fieldError is the shape of validator.FieldError from github.com/go-playground/validator,
+// which Gin's ShouldBind* and Echo's validators return, matched without depending on it.
+type fieldError interface {
+	error
+	Field() string
+	Tag() string
+}
+
+// validationError is a failed validation of one field, logged in validation_errors.
+type validationError struct {
+	field   string
+	tag     string
+	param   string
+	message string
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (e validationError) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if e.field != "" {
+		enc.AddString("field", e.field)
+	}
+	if e.tag != "" {
+		enc.AddString("tag", e.tag)
+	}
+	if e.param != "" {
+		enc.AddString("param", e.param)
+	}
+	enc.AddString("message", e.message)
+	return nil
+}
+
+// validationErrors is the validation_errors field of the response log.
+type validationErrors []validationError
+
+// MarshalLogArray implements zapcore.ArrayMarshaler.
+func (v validationErrors) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, e := range v {
+		if err := enc.AppendObject(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LogValidationError records the error returned by request binding or validation, such as
+// Gin's ShouldBindJSON or Echo's Bind, for the response log. It's logged there as a
+// validation_errors array with the field, tag and message of each failed validation when
+// err is (or wraps) validator.ValidationErrors or a single validator.FieldError, and as a
+// lone message otherwise. It does nothing if err is nil or the context doesn't belong to a
+// request served by the middleware.
+func LogValidationError(ctx context.Context, err error) {
+	state := requestStateFromContext(ctx)
+	if state == nil || err == nil {
+		return
+	}
+	normalized := normalizeValidationError(err)
+	state.mu.Lock()
+	state.validationErrors = append(state.validationErrors, normalized...)
+	state.mu.Unlock()
+}
+
+// getValidationErrors returns the validation errors recorded by the handler.
+func (s *requestState) getValidationErrors() validationErrors {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.validationErrors
+}
+
+// normalizeValidationError breaks err down into the failed validations it describes.
+func normalizeValidationError(err error) validationErrors {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if fe, ok := e.(fieldError); ok {
+			return validationErrors{newValidationError(fe)}
+		}
+		// validator.ValidationErrors is a slice of FieldError
+		if v := reflect.ValueOf(e); v.Kind() == reflect.Slice && v.Len() > 0 {
+			var normalized validationErrors
+			for i := 0; i < v.Len(); i++ {
+				fe, ok := v.Index(i).Interface().(fieldError)
+				if !ok {
+					normalized = nil
+					break
+				}
+				normalized = append(normalized, newValidationError(fe))
+			}
+			if len(normalized) > 0 {
+				return normalized
+			}
+		}
+	}
+	return validationErrors{{message: err.Error()}}
+}
+
+func newValidationError(fe fieldError) validationError {
+	e := validationError{field: fe.Field(), tag: fe.Tag(), message: fe.Error()}
+	if p, ok := fe.(interface{ Param() string }); ok {
+		e.param = p.Param()
+	}
+	return e
+}
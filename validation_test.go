@@ -0,0 +1,75 @@
+package smartlog
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// testFieldError and testValidationErrors have the shape of validator.FieldError and
+// validator.ValidationErrors.
+type testFieldError struct {
+	field, tag, param string
+}
+
+func (e testFieldError) Field() string { return e.field }
+func (e testFieldError) Tag() string   { return e.tag }
+func (e testFieldError) Param() string { return e.param }
+func (e testFieldError) Error() string {
+	return fmt.Sprintf("Key: 'CreateUser.%s' Error:Field validation for '%s' failed on the '%s' tag", e.field, e.field, e.tag)
+}
+
+type testValidationErrors []testFieldError
+
+func (v testValidationErrors) Error() string { return "validation failed" }
+
+func TestLogValidationError(t *testing.T) {
+	core, recorded := observer.New(zapcore.InfoLevel)
+	handler := ServerLogging(zap.New(core), &Config{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users":
+			// What ShouldBindJSON returns for a struct failing `binding:"required"` and `binding:"min=8"`
+			err := testValidationErrors{{field: "Email", tag: "required"}, {field: "Password", tag: "min", param: "8"}}
+			LogValidationError(r.Context(), fmt.Errorf("binding: %w", err))
+		case "/orders":
+			LogValidationError(r.Context(), errors.New("invalid character 'x' looking for beginning of value"))
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/users", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/orders", nil))
+
+	responses := recorded.FilterMessage(defaultResponseMessage).All()
+	require.Len(t, responses, 2)
+
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"field":   "Email",
+			"tag":     "required",
+			"message": "Key: 'CreateUser.Email' Error:Field validation for 'Email' failed on the 'required' tag",
+		},
+		map[string]interface{}{
+			"field":   "Password",
+			"tag":     "min",
+			"param":   "8",
+			"message": "Key: 'CreateUser.Password' Error:Field validation for 'Password' failed on the 'min' tag",
+		},
+	}, responses[0].ContextMap()["validation_errors"])
+
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"message": "invalid character 'x' looking for beginning of value"},
+	}, responses[1].ContextMap()["validation_errors"], "Other errors are logged as a message")
+
+	// Outside the middleware it's a no-op
+	assert.NotPanics(t, func() {
+		LogValidationError(httptest.NewRequest(http.MethodGet, "/", nil).Context(), errors.New("orphan"))
+	})
+}
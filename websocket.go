@@ -0,0 +1,43 @@
+package smartlog
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// isWebSocketUpgrade reports whether the request asks to upgrade the connection to a WebSocket.
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerContainsToken(r.Header, "Connection", "upgrade") &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// headerContainsToken reports whether a comma-separated header contains the token.
+func headerContainsToken(headers http.Header, name, token string) bool {
+	for _, value := range headers.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LogWSClose logs the close code and reason of a WebSocket connection.
+//
+// The middleware can't see frames once the connection is hijacked, so handlers should
+// call it when the connection ends, passing the request context. It does nothing if the
+// context doesn't carry a smartlog logger.
+func LogWSClose(ctx context.Context, code int, reason string) {
+	logger, ok := ctx.Value(LoggerKey).(*zap.Logger)
+	if !ok {
+		return
+	}
+	logger.Info("WebSocket closed",
+		zap.Int("ws_close_code", code),
+		zap.String("ws_close_reason", reason),
+	)
+}